@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/blob"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+)
+
+const maxAttachmentsPerMessage = 10
+
+type attachmentConfig struct {
+	// size limits in bytes, per kind of file
+	maxImageSize int64
+	maxVideoSize int64
+	maxAudioSize int64
+	maxFileSize  int64
+	// how long a download URL stays valid
+	urlTTL        time.Duration
+	signingSecret string
+}
+
+// maxSize is the upload limit for a mime type
+func (c attachmentConfig) maxSize(mimeType string) int64 {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return c.maxImageSize
+	case strings.HasPrefix(mimeType, "video/"):
+		return c.maxVideoSize
+	case strings.HasPrefix(mimeType, "audio/"):
+		return c.maxAudioSize
+	default:
+		return c.maxFileSize
+	}
+}
+
+// maxUploadSize is the biggest file any kind of upload allows
+func (c attachmentConfig) maxUploadSize() int64 {
+	return max(c.maxImageSize, c.maxVideoSize, c.maxAudioSize, c.maxFileSize)
+}
+
+type attachmentResponse struct {
+	queries.Attachment
+	// Signed download URL, relative to the API
+	URL          string    `json:"url"`
+	URLExpiresAt time.Time `json:"url_expires_at"`
+}
+
+var errInvalidSignature = errors.New("invalid or expired signature")
+
+func (a *api) newAttachmentResponse(attachment queries.Attachment) attachmentResponse {
+	expires := time.Now().Add(a.attachmentConfig.urlTTL).Truncate(time.Second)
+	return attachmentResponse{
+		Attachment:   attachment,
+		URL:          a.signAttachmentURL(attachment.ID, expires),
+		URLExpiresAt: expires,
+	}
+}
+
+func (a *api) newAttachmentResponses(attachments []queries.Attachment) []attachmentResponse {
+	resp := make([]attachmentResponse, len(attachments))
+	for i, attachment := range attachments {
+		resp[i] = a.newAttachmentResponse(attachment)
+	}
+	return resp
+}
+
+func (a *api) attachmentSignature(id string, expires int64) string {
+	h := hmac.New(sha256.New, []byte(a.attachmentConfig.signingSecret))
+	fmt.Fprintf(h, "%s:%d", id, expires)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (a *api) signAttachmentURL(id uuid.UUID, expires time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", a.attachmentSignature(id.String(), expires.Unix()))
+	return fmt.Sprintf("/attachments/%s/download?%s", id, query.Encode())
+}
+
+func (a *api) verifyAttachmentURL(id, expires, sig string) error {
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresUnix {
+		return errInvalidSignature
+	}
+
+	expected := a.attachmentSignature(id, expiresUnix)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// uploadAttachmentHandler stores a file for a conversation, the returned id can then
+// be sent along with a CHAT message in attachment_ids
+func (a *api) uploadAttachmentHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	ctx := c.Request().Context()
+
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, a.attachmentConfig.maxUploadSize()+1<<20)
+
+	conversationID, err := uuid.Parse(c.FormValue("conversation_id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid conversation id")
+	}
+
+	isMember, err := a.storage.Conversations.IsMember(ctx, conversationID, user.ID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if !isMember {
+		a.notFoundLog(c.Request().Method, c.Path(), store.ErrNotFound)
+		return echo.NewHTTPError(http.StatusNotFound, store.ErrNotFound.Error())
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "file is required")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	defer file.Close()
+
+	// the declared content type can't be trusted, sniff it instead
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "empty file")
+	}
+
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+	if err != nil {
+		mimeType = "application/octet-stream"
+	}
+
+	if limit := a.attachmentConfig.maxSize(mimeType); fileHeader.Size > limit {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("%s files can be at most %d bytes", mimeType, limit))
+	}
+
+	checksum := sha256.New()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	if _, err := io.Copy(checksum, file); err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	var width, height pgtype.Int4
+	if strings.HasPrefix(mimeType, "image/") {
+		if _, err := file.Seek(0, io.SeekStart); err == nil {
+			if cfg, _, err := image.DecodeConfig(file); err == nil {
+				width = pgtype.Int4{Int32: int32(cfg.Width), Valid: true}
+				height = pgtype.Int4{Int32: int32(cfg.Height), Valid: true}
+			}
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	key := fmt.Sprintf("%s/%s", conversationID, uuid.NewString())
+	if err := a.blobs.Put(ctx, key, file, fileHeader.Size, mimeType); err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	attachment, err := a.storage.Attachments.Create(ctx, queries.CreateAttachmentParams{
+		UploaderID:     user.ID,
+		ConversationID: conversationID,
+		StorageKey:     key,
+		FileName:       attachmentFileName(fileHeader.Filename),
+		MimeType:       mimeType,
+		SizeBytes:      fileHeader.Size,
+		ChecksumSha256: hex.EncodeToString(checksum.Sum(nil)),
+		Width:          width,
+		Height:         height,
+	})
+
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		go a.deleteBlobs(key)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusCreated, a.newAttachmentResponse(attachment))
+}
+
+// getAttachmentHandler returns the metadata along with a fresh download URL
+func (a *api) getAttachmentHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	attachmentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid attachment id")
+	}
+
+	attachment, err := a.storage.Attachments.GetByID(c.Request().Context(), attachmentID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	isMember, err := a.storage.Conversations.IsMember(c.Request().Context(), attachment.ConversationID, user.ID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if !isMember {
+		a.notFoundLog(c.Request().Method, c.Path(), store.ErrNotFound)
+		return echo.NewHTTPError(http.StatusNotFound, store.ErrNotFound.Error())
+	}
+
+	return c.JSON(http.StatusOK, a.newAttachmentResponse(attachment))
+}
+
+// downloadAttachmentHandler serves the file itself, it's not behind AuthMiddleware
+// so it works in <img> tags, the signed URL is handed out to conversation members only
+func (a *api) downloadAttachmentHandler(c echo.Context) error {
+	id := c.Param("id")
+	if err := a.verifyAttachmentURL(id, c.QueryParam("expires"), c.QueryParam("sig")); err != nil {
+		a.unauthorizedLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	attachmentID, err := uuid.Parse(id)
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid attachment id")
+	}
+
+	attachment, err := a.storage.Attachments.GetByID(c.Request().Context(), attachmentID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	body, err := a.blobs.Get(c.Request().Context(), attachment.StorageKey)
+	if err != nil {
+		switch err {
+		case blob.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+	defer body.Close()
+
+	disposition := "attachment"
+	if isInlineMimeType(attachment.MimeType) {
+		disposition = "inline"
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(attachment.SizeBytes, 10))
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	header.Set("Cache-Control", "private, max-age=3600")
+
+	return c.Stream(http.StatusOK, attachment.MimeType, body)
+}
+
+// deleteBlobs removes files whose metadata is already gone, failures only leave garbage behind
+func (a *api) deleteBlobs(keys ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, key := range keys {
+		if err := a.blobs.Delete(ctx, key); err != nil {
+			a.logger.Errorw("couldn't delete blob", "key", key, "error", err.Error())
+		}
+	}
+}
+
+// isInlineMimeType tells which files browsers may render right away,
+// everything else is downloaded so uploads can't run scripts on our origin
+func isInlineMimeType(mimeType string) bool {
+	switch {
+	case mimeType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mimeType, "image/"),
+		strings.HasPrefix(mimeType, "video/"),
+		strings.HasPrefix(mimeType, "audio/"):
+		return true
+	default:
+		return false
+	}
+}
+
+func attachmentFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+
+	for utf8.RuneCountInString(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 	"github.com/myselfBZ/chatrix-v2/internal/store"
 	"golang.org/x/crypto/bcrypt"
@@ -15,17 +18,22 @@ type authConfig struct {
 	accessSecret, refreshSecret string
 	aud                         string
 	iss                         string
+	// domain of the refresh token cookie
+	cookieDomain string
 }
 
 type loginPayload struct {
 	Email    string `json:"email" validate:"required"`
 	Password string `json:"password" validate:"required"`
+	// Shown in the list of sessions, e.g. "Pixel 8"
+	DeviceName string `json:"device_name" validate:"max=100"`
 }
 
 type userPayload struct {
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
-	Email    string `json:"email" validate:"required"`
+	Username   string `json:"username" validate:"required"`
+	Password   string `json:"password" validate:"required"`
+	Email      string `json:"email" validate:"required"`
+	DeviceName string `json:"device_name" validate:"max=100"`
 }
 
 type tokenEnvelope struct {
@@ -33,8 +41,37 @@ type tokenEnvelope struct {
 	Token string        `json:"access_token"`
 }
 
+const refreshCookieName = "refresh_token"
+
+func (a *api) setRefreshCookie(c echo.Context, token string, expiresAt time.Time) {
+	c.SetCookie(&http.Cookie{
+		Name:     refreshCookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   a.authConfig.cookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+	})
+}
+
+func (a *api) clearRefreshCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     refreshCookieName,
+		Value:    "",
+		Path:     "/",
+		Domain:   a.authConfig.cookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   -1,
+	})
+}
+
+// refreshTokenHandler swaps the refresh token for a new pair, every refresh token works once
 func (a *api) refreshTokenHandler(c echo.Context) error {
-	cookie, err := c.Cookie("refresh_token")
+	cookie, err := c.Cookie(refreshCookieName)
 	if err != nil {
 		a.unauthorizedLog(c.Request().Method, c.Path(), err)
 		return echo.NewHTTPError(http.StatusUnauthorized, "no refresh token")
@@ -42,11 +79,7 @@ func (a *api) refreshTokenHandler(c echo.Context) error {
 
 	token, err := a.auth.ValidateRefreshToken(cookie.Value)
 	if err != nil {
-		c.SetCookie(&http.Cookie{
-			Name:   "refresh_token",
-			Value:  "",
-			MaxAge: -1,
-		})
+		a.clearRefreshCookie(c)
 		return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
 	}
 
@@ -61,22 +94,145 @@ func (a *api) refreshTokenHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "invalid uuid")
 	}
 
+	tokenID, familyID, err := a.refreshTokenIDs(token)
+	if err != nil {
+		a.clearRefreshCookie(c)
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token claims")
+	}
+
 	user, err := a.storage.Users.GetByID(c.Request().Context(), validUUID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, "user not found")
 	}
 
-	accessToken, err := a.auth.GenerateAccessToken(user.ID.String())
+	tokens, err := a.auth.GenerateTokenPair(user.ID.String(), familyID.String(), sessionClaims(familyID))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate tokens")
 	}
 
+	err = a.storage.RefreshTokens.Rotate(c.Request().Context(), tokenID, queries.CreateRefreshTokenParams{
+		ID:        uuid.MustParse(tokens.RefreshTokenID),
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		ExpiresAt: pgtype.Timestamptz{Time: tokens.RefreshExpiresAt, Valid: true},
+	})
+
+	if err != nil {
+		switch err {
+		case store.ErrTokenReused:
+			a.logger.Warnw("refresh token reused, revoked its family", "user_id", user.ID.String(), "family_id", familyID.String())
+			// the family is the session, whoever holds its access tokens is cut off as well
+			err := a.storage.Sessions.Revoke(c.Request().Context(), queries.RevokeSessionParams{
+				ID:     familyID,
+				UserID: user.ID,
+			})
+			if err != nil && err != store.ErrNotFound {
+				a.logger.Errorw("couldn't revoke session of reused refresh token", "session_id", familyID.String(), "error", err.Error())
+			}
+			a.closeWebsocketSessions(user.ID, familyID)
+			a.clearRefreshCookie(c)
+			return echo.NewHTTPError(http.StatusUnauthorized, "refresh token has already been used, log in again")
+		case store.ErrNotFound:
+			a.unauthorizedLog(c.Request().Method, c.Path(), err)
+			a.clearRefreshCookie(c)
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	if err := a.storage.Sessions.Touch(c.Request().Context(), queries.TouchSessionParams{
+		ID:        familyID,
+		IpAddress: optionalText(c.RealIP()),
+	}); err != nil {
+		a.logger.Errorw("couldn't update session", "session_id", familyID.String(), "error", err.Error())
+	}
+
+	a.setRefreshCookie(c, tokens.RefreshToken, tokens.RefreshExpiresAt)
+
 	return c.JSON(http.StatusOK, &tokenEnvelope{
-		Token: accessToken,
+		Token: tokens.AccessToken,
 		User:  &user,
 	})
 }
 
+func (a *api) refreshTokenIDs(token *jwt.Token) (uuid.UUID, uuid.UUID, error) {
+	tokenID, familyID, err := a.auth.ExtractRefreshTokenIDs(token)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, err
+	}
+
+	validTokenID, err := uuid.Parse(tokenID)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, err
+	}
+
+	validFamilyID, err := uuid.Parse(familyID)
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, err
+	}
+
+	return validTokenID, validFamilyID, nil
+}
+
+// logoutHandler revokes the refresh token of this device, it works without an access token
+// so a client whose access token expired can still log out
+func (a *api) logoutHandler(c echo.Context) error {
+	defer a.clearRefreshCookie(c)
+
+	cookie, err := c.Cookie(refreshCookieName)
+	if err != nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	// an invalid or expired token can't be used anymore anyway
+	token, err := a.auth.ValidateRefreshToken(cookie.Value)
+	if err != nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	userID, err := a.auth.ExtractUserID(token)
+	if err != nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	validUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	_, familyID, err := a.refreshTokenIDs(token)
+	if err != nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if err := a.revokeSession(c.Request().Context(), validUUID, familyID); err != nil && err != store.ErrNotFound {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// logoutAllHandler ends the sessions of every device of the user, this one included
+func (a *api) logoutAllHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+
+	sessionIDs, err := a.storage.Sessions.RevokeAll(c.Request().Context(), user.ID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	for _, sessionID := range sessionIDs {
+		a.closeWebsocketSessions(user.ID, sessionID)
+	}
+
+	a.clearRefreshCookie(c)
+	return c.NoContent(http.StatusNoContent)
+}
+
 func (a *api) createTokenHandler(c echo.Context) error {
 	var payload loginPayload
 
@@ -107,31 +263,56 @@ func (a *api) createTokenHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized)
 	}
 
-	tokens, err := a.auth.GenerateTokenPair(user.ID.String(), make(map[string]any))
-
+	accessToken, err := a.startSession(c, user, payload.DeviceName)
 	if err != nil {
 		a.internalErrLog(c.Request().Method, c.Path(), err)
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 
-	c.SetCookie(&http.Cookie{
-		Name:     "refresh_token",
-		Value:    tokens.RefreshToken,
-		Path:     "/",
-		// Try getting rid of it.
-		Domain:   "localhost",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteNoneMode,
-		MaxAge:   7 * 24 * 60 * 60, // 7 days
-	})
-
 	return c.JSON(http.StatusOK, &tokenEnvelope{
-		Token: tokens.AccessToken,
+		Token: accessToken,
 		User:  &user,
 	})
 }
 
+// startSession records the login of a device and hands out its first token pair,
+// the refresh token goes into the cookie
+func (a *api) startSession(c echo.Context, user queries.User, deviceName string) (string, error) {
+	// the session id doubles as the family of its refresh tokens
+	sessionID := uuid.New()
+	tokens, err := a.auth.GenerateTokenPair(user.ID.String(), sessionID.String(), sessionClaims(sessionID))
+	if err != nil {
+		return "", err
+	}
+
+	err = a.storage.Sessions.Create(c.Request().Context(), queries.CreateSessionParams{
+		ID:         sessionID,
+		UserID:     user.ID,
+		DeviceName: optionalText(deviceName),
+		UserAgent:  optionalText(c.Request().UserAgent()),
+		IpAddress:  optionalText(c.RealIP()),
+	}, queries.CreateRefreshTokenParams{
+		ID:        uuid.MustParse(tokens.RefreshTokenID),
+		UserID:    user.ID,
+		FamilyID:  sessionID,
+		ExpiresAt: pgtype.Timestamptz{Time: tokens.RefreshExpiresAt, Valid: true},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	a.setRefreshCookie(c, tokens.RefreshToken, tokens.RefreshExpiresAt)
+	return tokens.AccessToken, nil
+}
+
+func sessionClaims(sessionID uuid.UUID) map[string]any {
+	return map[string]any{sessionIDClaim: sessionID.String()}
+}
+
+func optionalText(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
+
 func (a *api) createUserHandler(c echo.Context) error {
 	var payload userPayload
 	if err := c.Bind(&payload); err != nil {
@@ -172,7 +353,7 @@ func (a *api) createUserHandler(c echo.Context) error {
 
 	}
 
-	tokens, err := a.auth.GenerateTokenPair(dbUser.ID.String(), make(map[string]any))
+	accessToken, err := a.startSession(c, dbUser, payload.DeviceName)
 
 	if err != nil {
 		a.internalErrLog(c.Request().Method, c.Path(), err)
@@ -181,6 +362,6 @@ func (a *api) createUserHandler(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, tokenEnvelope{
 		User:  &dbUser,
-		Token: tokens.AccessToken,
+		Token: accessToken,
 	})
 }
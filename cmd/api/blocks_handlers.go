@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+)
+
+func (a *api) getBlockedUsersHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+
+	blocked, err := a.storage.Blocks.GetByUserID(c.Request().Context(), user.ID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, blocked)
+}
+
+// blockUserHandler stops the user from starting conversations with the caller
+// and from messaging them in the one they already have
+func (a *api) blockUserHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	blockedID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	if blockedID == user.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "you can't block yourself")
+	}
+
+	err = a.storage.Blocks.Block(c.Request().Context(), queries.BlockUserParams{
+		BlockerID: user.ID,
+		BlockedID: blockedID,
+	})
+
+	if err != nil {
+		switch err {
+		case store.ErrConstraintMessage:
+			// the user doesn't exist
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, store.ErrNotFound.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *api) unblockUserHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	blockedID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	err = a.storage.Blocks.Unblock(c.Request().Context(), queries.UnblockUserParams{
+		BlockerID: user.ID,
+		BlockedID: blockedID,
+	})
+
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/olahol/melody"
+)
+
+const (
+	// events for the websocket sessions of users, whichever node they're connected to
+	deliveriesChannel = "chatrix_deliveries"
+	// revoked logins whose websockets have to be closed
+	revocationsChannel = "chatrix_revocations"
+)
+
+// delivery is an event on its way to every node, each one writes it
+// to the sessions of the recipients it holds
+type delivery struct {
+	UserIDs []uuid.UUID `json:"user_ids"`
+	// the device the event came from, it has it already
+	Except *deviceRef `json:"except,omitempty"`
+	// sequence number of every recipient's copy, durable events only
+	Seqs map[uuid.UUID]int64 `json:"seqs,omitempty"`
+	// recipients who muted the conversation the event is about
+	Muted   []uuid.UUID     `json:"muted,omitempty"`
+	Type    string          `json:"type"`
+	Message json.RawMessage `json:"message"`
+}
+
+type deviceRef struct {
+	UserID   uuid.UUID `json:"user_id"`
+	DeviceID string    `json:"device_id"`
+}
+
+type revocation struct {
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID uuid.UUID `json:"session_id"`
+}
+
+func (a *api) subscribe() {
+	a.broker.Subscribe(deliveriesChannel, a.handleDelivery)
+	a.broker.Subscribe(revocationsChannel, a.handleRevocation)
+}
+
+// deliver sends msg to every device of the users across the cluster, except the given session
+func (a *api) deliver(userIDs []uuid.UUID, except *melody.Session, msg Wrapper, seqs map[uuid.UUID]int64) {
+	a.deliverMuted(userIDs, except, msg, seqs, nil)
+}
+
+// deliverMuted is deliver with the event flagged as muted for some of the users
+func (a *api) deliverMuted(userIDs []uuid.UUID, except *melody.Session, msg Wrapper, seqs map[uuid.UUID]int64, muted []uuid.UUID) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	message, err := json.Marshal(msg.Message)
+	if err != nil {
+		a.logger.Errorw("couldn't marshal event", "type", msg.MsgType, "error", err.Error())
+		return
+	}
+
+	d := delivery{
+		UserIDs: userIDs,
+		Seqs:    seqs,
+		Muted:   muted,
+		Type:    msg.MsgType,
+		Message: message,
+	}
+
+	if except != nil {
+		userID, _ := sessionUserID(except)
+		deviceID, _ := except.Get(deviceIDSessionKey)
+		d.Except = &deviceRef{UserID: userID, DeviceID: deviceID.(string)}
+	}
+
+	payload, _ := json.Marshal(d)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.broker.Publish(ctx, deliveriesChannel, payload); err != nil {
+		a.logger.Errorw("couldn't publish event", "type", msg.MsgType, "error", err.Error())
+	}
+}
+
+func (a *api) handleDelivery(payload []byte) {
+	var d delivery
+	if err := json.Unmarshal(payload, &d); err != nil {
+		a.logger.Errorw("invalid delivery", "error", err.Error())
+		return
+	}
+
+	for _, userID := range d.UserIDs {
+		sessions := a.clients.sessions(userID)
+		if len(sessions) == 0 {
+			continue
+		}
+
+		jsonData, _ := json.Marshal(Wrapper{
+			MsgType: d.Type,
+			Message: replayedMessage(d.Message),
+			Seq:     d.Seqs[userID],
+			Muted:   slices.Contains(d.Muted, userID),
+		})
+
+		for _, s := range sessions {
+			if d.Except != nil && d.Except.UserID == userID {
+				if deviceID, _ := s.Get(deviceIDSessionKey); deviceID == d.Except.DeviceID {
+					continue
+				}
+			}
+
+			// the session is replaying what it missed, this goes out right after
+			if state, ok := sessionSyncState(s); ok && state.hold(d.Seqs[userID], jsonData) {
+				continue
+			}
+			s.Write(jsonData)
+		}
+	}
+}
+
+func (a *api) handleRevocation(payload []byte) {
+	var r revocation
+	if err := json.Unmarshal(payload, &r); err != nil {
+		a.logger.Errorw("invalid revocation", "error", err.Error())
+		return
+	}
+
+	a.closeLocalWebsocketSessions(r.UserID, r.SessionID)
+}
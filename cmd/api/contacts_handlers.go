@@ -1 +1,249 @@
 package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+)
+
+type contactResponse struct {
+	queries.GetContactsByUserIDRow
+	IsOnline bool `json:"is_online"`
+}
+
+type addContactPayload struct {
+	UserID   string `json:"user_id" validate:"required,uuid"`
+	Nickname string `json:"nickname" validate:"max=50"`
+}
+
+type renameContactPayload struct {
+	// Empty goes back to the username
+	Nickname string `json:"nickname" validate:"max=50"`
+}
+
+func newContactResponse(contact queries.GetContactsByUserIDRow, online map[uuid.UUID]bool) contactResponse {
+	return contactResponse{
+		GetContactsByUserIDRow: contact,
+		IsOnline:               online[contact.ID],
+	}
+}
+
+func nicknameText(nickname string) pgtype.Text {
+	nickname = strings.TrimSpace(nickname)
+	return pgtype.Text{String: nickname, Valid: nickname != ""}
+}
+
+func (a *api) getContactsHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+
+	contacts, err := a.storage.Contacts.GetByUserID(c.Request().Context(), user.ID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	ids := make([]uuid.UUID, len(contacts))
+	for i, contact := range contacts {
+		ids[i] = contact.ID
+	}
+	online := a.onlineUsers(c.Request().Context(), ids)
+
+	resp := make([]contactResponse, len(contacts))
+	for i, contact := range contacts {
+		resp[i] = newContactResponse(contact, online)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *api) searchContactsHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	query := strings.TrimSpace(c.QueryParam("q"))
+	if query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "q is required")
+	}
+
+	contacts, err := a.storage.Contacts.Search(c.Request().Context(), queries.SearchContactsParams{
+		UserID: user.ID,
+		Query:  query,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	ids := make([]uuid.UUID, len(contacts))
+	for i, contact := range contacts {
+		ids[i] = contact.ID
+	}
+	online := a.onlineUsers(c.Request().Context(), ids)
+
+	resp := make([]contactResponse, len(contacts))
+	for i, contact := range contacts {
+		resp[i] = newContactResponse(queries.GetContactsByUserIDRow(contact), online)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *api) addContactHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	var payload addContactPayload
+	if err := c.Bind(&payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := a.validator.Struct(payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	contactID := uuid.MustParse(payload.UserID)
+	if contactID == user.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "you can't add yourself as a contact")
+	}
+
+	// a contact lets them past contacts-only privacy and tells them they were added
+	blocked, err := a.storage.Blocks.IsBlockedBetween(c.Request().Context(), user.ID, contactID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	if blocked {
+		return echo.NewHTTPError(http.StatusForbidden, errUserBlocked.Error())
+	}
+
+	err = a.storage.Contacts.Add(c.Request().Context(), queries.AddContactParams{
+		UserID:        user.ID,
+		ContactUserID: contactID,
+		Nickname:      nicknameText(payload.Nickname),
+	})
+
+	if err != nil {
+		switch err {
+		case store.ErrAlreadyExists:
+			a.conflictLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusConflict, "already in your contacts")
+		case store.ErrConstraintMessage:
+			// the user doesn't exist
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, store.ErrNotFound.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	contact, err := a.storage.Contacts.Get(c.Request().Context(), queries.GetContactParams{
+		UserID:        user.ID,
+		ContactUserID: contactID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	go a.notifyContactAdded(contactID, user, contact.AddedAt.Time)
+
+	online := a.onlineUsers(c.Request().Context(), []uuid.UUID{contactID})
+	return c.JSON(http.StatusCreated, newContactResponse(queries.GetContactsByUserIDRow(contact), online))
+}
+
+func (a *api) renameContactHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	contactID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	var payload renameContactPayload
+	if err := c.Bind(&payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := a.validator.Struct(payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	err = a.storage.Contacts.Rename(c.Request().Context(), queries.UpdateContactNicknameParams{
+		Nickname:      nicknameText(payload.Nickname),
+		UserID:        user.ID,
+		ContactUserID: contactID,
+	})
+
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	contact, err := a.storage.Contacts.Get(c.Request().Context(), queries.GetContactParams{
+		UserID:        user.ID,
+		ContactUserID: contactID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	online := a.onlineUsers(c.Request().Context(), []uuid.UUID{contactID})
+	return c.JSON(http.StatusOK, newContactResponse(queries.GetContactsByUserIDRow(contact), online))
+}
+
+func (a *api) deleteContactHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	contactID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	err = a.storage.Contacts.Delete(c.Request().Context(), queries.DeleteContactParams{
+		ContactUserID: contactID,
+		UserID:        user.ID,
+	})
+
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// notifyContactAdded lets the user know who added them, the nickname stays private
+func (a *api) notifyContactAdded(contactID uuid.UUID, addedBy queries.User, addedAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a.publish(ctx, []uuid.UUID{contactID}, nil, Wrapper{
+		MsgType: CONTACT_ADDED,
+		Message: &ContactAdded{
+			UserID:   addedBy.ID,
+			Username: addedBy.Username,
+			AddedAt:  addedAt,
+		},
+	})
+}
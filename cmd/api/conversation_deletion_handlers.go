@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/config"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+)
+
+var errDeleteForBothDisabled = errors.New("conversations can only be deleted for yourself")
+
+// clearConversationHandler hides the messages of the conversation so far from the user,
+// the other members keep their history
+func (a *api) clearConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.ConversationSettings.Clear(c.Request().Context(), queries.ClearConversationParams{
+		ConversationID: conversationID,
+		UserID:         user.ID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	go a.notifyUsers([]uuid.UUID{user.ID}, Wrapper{
+		MsgType: CONVO_CLEARED,
+		Message: &ConvoCleared{
+			ConversationID: conversationID,
+			ClearedAt:      time.Now(),
+		},
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// deleteConversationHandler deletes the conversation for the user, deleting a group is leaving it.
+// for=everyone deletes a direct conversation for both members, as far as the policy allows
+func (a *api) deleteConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	conversation, err := a.storage.Conversations.GetByID(c.Request().Context(), conversationID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	switch c.QueryParam("for") {
+	case "", "me":
+		if conversation.IsGroup {
+			return a.leaveGroup(c, user.ID, conversation.ID)
+		}
+		return a.deleteForUser(c, user.ID, conversation.ID)
+	case "everyone":
+		if conversation.IsGroup {
+			return echo.NewHTTPError(http.StatusBadRequest, "groups can't be deleted for everyone, leave them instead")
+		}
+		return a.deleteForBoth(c, user.ID, conversation)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "for must be me or everyone")
+	}
+}
+
+// deleteForUser takes the conversation off the user's list and clears it for them,
+// it comes back with the next message
+func (a *api) deleteForUser(c echo.Context, userID, conversationID uuid.UUID) error {
+	err := a.storage.ConversationSettings.DeleteForUser(c.Request().Context(), queries.DeleteConversationForUserParams{
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	go a.notifyUsers([]uuid.UUID{userID}, Wrapper{
+		MsgType: CONVO_DELETED,
+		Message: &ConvoDeleted{
+			ConversationID: conversationID,
+			UserID:         userID,
+			DeletedAt:      time.Now(),
+		},
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *api) leaveGroup(c echo.Context, userID, conversationID uuid.UUID) error {
+	keys, err := a.storage.Conversations.Leave(c.Request().Context(), conversationID, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	// they were the last member, the group is gone
+	if len(keys) > 0 {
+		go a.deleteBlobs(keys...)
+	}
+
+	now := time.Now()
+	go a.notifyUsers([]uuid.UUID{userID}, Wrapper{
+		MsgType: CONVO_DELETED,
+		Message: &ConvoDeleted{
+			ConversationID: conversationID,
+			UserID:         userID,
+			DeletedAt:      now,
+		},
+	})
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		a.publishToMembers(ctx, conversationID, userID, nil, Wrapper{
+			MsgType: MEMBER_LEFT,
+			Message: &MemberLeft{
+				ConversationID: conversationID,
+				UserID:         userID,
+				LeftAt:         now,
+			},
+		})
+	}()
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// deleteForBoth deletes the direct conversation along with its messages for both members. Under
+// the consent policy the first member to ask only records the request, the conversation is
+// deleted once the other one asks too
+func (a *api) deleteForBoth(c echo.Context, userID uuid.UUID, conversation queries.Conversation) error {
+	memberIDs := []uuid.UUID{conversation.User1.Bytes, conversation.User2.Bytes}
+
+	switch a.messageConfig.deleteForBoth {
+	case config.DeleteForBothNever:
+		return echo.NewHTTPError(http.StatusForbidden, errDeleteForBothDisabled.Error())
+	case config.DeleteForBothAnyone:
+		keys, err := a.storage.Conversations.Delete(c.Request().Context(), conversation.ID)
+		if err != nil {
+			return a.deleteForBothErr(c, err)
+		}
+		if len(keys) > 0 {
+			go a.deleteBlobs(keys...)
+		}
+		go a.notifyConversationDeleted(memberIDs, conversation.ID, userID)
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	requested, deleted, keys, err := a.storage.Conversations.RequestDeletion(c.Request().Context(), queries.RequestConversationDeletionParams{
+		ID:     conversation.ID,
+		UserID: userID,
+	})
+	if err != nil {
+		return a.deleteForBothErr(c, err)
+	}
+
+	if deleted {
+		if len(keys) > 0 {
+			go a.deleteBlobs(keys...)
+		}
+		go a.notifyConversationDeleted(memberIDs, conversation.ID, userID)
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	go a.notifyUsers(memberIDs, Wrapper{
+		MsgType: CONVO_DELETE_REQUESTED,
+		Message: &ConvoDeleteRequested{
+			ConversationID: conversation.ID,
+			RequestedBy:    userID,
+			RequestedAt:    requested.DeleteRequestedAt.Time,
+		},
+	})
+
+	return c.JSON(http.StatusAccepted, requested)
+}
+
+func (a *api) deleteForBothErr(c echo.Context, err error) error {
+	switch err {
+	case store.ErrNotFound:
+		// the other member deleted it in the meantime
+		a.notFoundLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	default:
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+}
+
+// cancelDeletionRequestHandler withdraws the request to delete the conversation for both members,
+// or declines it when the other member made it
+func (a *api) cancelDeletionRequestHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	conversation, err := a.storage.Conversations.CancelDeletion(c.Request().Context(), conversationID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, "there is no request to delete this conversation")
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	go a.notifyUsers([]uuid.UUID{conversation.User1.Bytes, conversation.User2.Bytes}, Wrapper{
+		MsgType: CONVO_DELETE_CANCELED,
+		Message: &ConvoDeleteCanceled{
+			ConversationID: conversation.ID,
+			UserID:         user.ID,
+		},
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *api) notifyConversationDeleted(memberIDs []uuid.UUID, conversationID, userID uuid.UUID) {
+	a.notifyUsers(memberIDs, Wrapper{
+		MsgType: CONVO_DELETED,
+		Message: &ConvoDeleted{
+			ConversationID: conversationID,
+			UserID:         userID,
+			ForEveryone:    true,
+			DeletedAt:      time.Now(),
+		},
+	})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+)
+
+// maxPinnedConversations caps how many conversations a user can pin
+const maxPinnedConversations = 5
+
+// What the user did with a conversation, only they see it
+type conversationSettingsResponse struct {
+	// Until the conversation has a new message
+	Archived bool `json:"archived"`
+	// 1 is the top, left out when the conversation isn't pinned
+	PinPosition *int32 `json:"pin_position,omitempty"`
+	Muted       bool   `json:"muted"`
+	// Left out when muted indefinitely
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+type reorderPinsPayload struct {
+	// Every pinned conversation, top first
+	ConversationIDs []string `json:"conversation_ids" validate:"required,dive,uuid"`
+}
+
+type mutePayload struct {
+	// Muted indefinitely when left out
+	Until *time.Time `json:"until"`
+}
+
+func newConversationSettings(s queries.GetConversationSettingsRow) conversationSettingsResponse {
+	resp := conversationSettingsResponse{
+		Archived: s.Archived,
+		Muted:    s.MutedAt.Valid && (!s.MutedUntil.Valid || s.MutedUntil.Time.After(time.Now())),
+	}
+
+	if s.PinPosition.Valid {
+		resp.PinPosition = &s.PinPosition.Int32
+	}
+
+	if resp.Muted && s.MutedUntil.Valid {
+		resp.MutedUntil = &s.MutedUntil.Time
+	}
+	return resp
+}
+
+// pinRank orders pinned conversations, one that got unpinned in the meantime goes last
+func (s conversationSettingsResponse) pinRank() int32 {
+	if s.PinPosition == nil {
+		return math.MaxInt32
+	}
+	return *s.PinPosition
+}
+
+// settingsConversation parses the conversation of the request, which the user has to be a member of
+func (a *api) settingsConversation(c echo.Context, userID uuid.UUID) (uuid.UUID, error) {
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return uuid.UUID{}, echo.NewHTTPError(http.StatusBadRequest, "invalid conversation id")
+	}
+
+	isMember, err := a.storage.Conversations.IsMember(c.Request().Context(), conversationID, userID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return uuid.UUID{}, echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if !isMember {
+		return uuid.UUID{}, echo.NewHTTPError(http.StatusNotFound, store.ErrNotFound.Error())
+	}
+
+	return conversationID, nil
+}
+
+// settingsResponse sends back the settings of the conversation after they were changed
+func (a *api) settingsResponse(c echo.Context, userID, conversationID uuid.UUID) error {
+	settings, err := a.storage.ConversationSettings.Get(c.Request().Context(), queries.GetConversationSettingsParams{
+		UserID:          userID,
+		ConversationIds: []uuid.UUID{conversationID},
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if len(settings) == 0 {
+		return c.JSON(http.StatusOK, conversationSettingsResponse{})
+	}
+	return c.JSON(http.StatusOK, newConversationSettings(settings[0]))
+}
+
+// archiveConversationHandler hides the conversation from the main list until it has a new message
+func (a *api) archiveConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.ConversationSettings.Archive(c.Request().Context(), queries.ArchiveConversationParams{
+		ConversationID: conversationID,
+		UserID:         user.ID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return a.settingsResponse(c, user.ID, conversationID)
+}
+
+func (a *api) unarchiveConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.ConversationSettings.Unarchive(c.Request().Context(), queries.UnarchiveConversationParams{
+		ConversationID: conversationID,
+		UserID:         user.ID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return a.settingsResponse(c, user.ID, conversationID)
+}
+
+func (a *api) pinConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.ConversationSettings.Pin(c.Request().Context(), queries.PinConversationParams{
+		ConversationID: conversationID,
+		UserID:         user.ID,
+	}, maxPinnedConversations)
+
+	if err != nil {
+		switch err {
+		case store.ErrLimitReached:
+			a.conflictLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("at most %d conversations can be pinned", maxPinnedConversations))
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	return a.settingsResponse(c, user.ID, conversationID)
+}
+
+func (a *api) unpinConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.ConversationSettings.Unpin(c.Request().Context(), queries.UnpinConversationParams{
+		ConversationID: conversationID,
+		UserID:         user.ID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return a.settingsResponse(c, user.ID, conversationID)
+}
+
+// reorderPinsHandler takes every pinned conversation in the new order
+func (a *api) reorderPinsHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	var payload reorderPinsPayload
+	if err := c.Bind(&payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := a.validator.Struct(payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	ids := make([]uuid.UUID, len(payload.ConversationIDs))
+	for i, id := range payload.ConversationIDs {
+		ids[i] = uuid.MustParse(id)
+	}
+
+	pinned, err := a.storage.ConversationSettings.GetPinnedIDs(c.Request().Context(), user.ID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	sorted := slices.Clone(ids)
+	slices.SortFunc(sorted, compareUUIDs)
+	slices.SortFunc(pinned, compareUUIDs)
+	if !slices.Equal(sorted, pinned) {
+		return echo.NewHTTPError(http.StatusBadRequest, "conversation_ids must list every pinned conversation once")
+	}
+
+	err = a.storage.ConversationSettings.Reorder(c.Request().Context(), queries.ReorderPinnedConversationsParams{
+		ConversationIds: ids,
+		UserID:          user.ID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// muteConversationHandler flags the events of the conversation as muted for the user,
+// their devices don't notify about them
+func (a *api) muteConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	var payload mutePayload
+	if err := c.Bind(&payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var until pgtype.Timestamptz
+	if payload.Until != nil {
+		if !payload.Until.After(time.Now()) {
+			return echo.NewHTTPError(http.StatusBadRequest, "until must be in the future")
+		}
+		until = pgtype.Timestamptz{Time: *payload.Until, Valid: true}
+	}
+
+	err = a.storage.ConversationSettings.Mute(c.Request().Context(), queries.MuteConversationParams{
+		ConversationID: conversationID,
+		UserID:         user.ID,
+		MutedUntil:     until,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return a.settingsResponse(c, user.ID, conversationID)
+}
+
+func (a *api) unmuteConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := a.settingsConversation(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.ConversationSettings.Unmute(c.Request().Context(), queries.UnmuteConversationParams{
+		ConversationID: conversationID,
+		UserID:         user.ID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return a.settingsResponse(c, user.ID, conversationID)
+}
+
+func compareUUIDs(x, y uuid.UUID) int {
+	return bytes.Compare(x[:], y[:])
+}
@@ -1,55 +1,331 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 	"github.com/myselfBZ/chatrix-v2/internal/queries"
 	"github.com/myselfBZ/chatrix-v2/internal/store"
 )
 
+// The caller always starts the conversation, UserID is the other member
 type createConversationPayload struct {
-	// User1 is the intiator
-	User1 string `json:"user1" validate:"required"`
-	User2 string `json:"user2" validate:"required"`
+	UserID string `json:"user_id" validate:"omitempty,uuid"`
+	// Older clients send both members, User1 has to be the caller then
+	User1 string `json:"user1" validate:"omitempty,uuid"`
+	User2 string `json:"user2" validate:"omitempty,uuid"`
 }
 
 
+type createGroupPayload struct {
+	Title string `json:"title" validate:"required,max=100"`
+	// The creator is always added, no need to include them
+	MemberIDs []string `json:"member_ids" validate:"required,min=1,dive,uuid"`
+}
+
+type conversationListConfig struct {
+	defaultPageSize int
+	maxPageSize     int
+}
+
+// UserData is set for direct conversations, Group for group conversations
 type conversationResponse struct {
-	UserData queries.GetConversationsByUserIDRow `json:"user_data"`
-	UserIsOnline bool `json:"is_online"`
+	UserData     *queries.GetConversationsByUserIDRow `json:"user_data,omitempty"`
+	UserIsOnline bool                                 `json:"is_online"`
+	Group        *groupConversation                   `json:"group,omitempty"`
+	// Left out until the conversation has a message the user can see
+	LastMessage *LastMessage                 `json:"last_message,omitempty"`
+	Settings    conversationSettingsResponse `json:"settings"`
+}
+
+type conversationListResponse struct {
+	// Pinned conversations come first on the first page, in the user's order
+	Conversations []conversationResponse `json:"conversations"`
+	// Pass as before= to load the next page, empty when there isn't one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// conversationCursor is a position in the conversation list, ordered by (last_activity_at, id).
+// It's encoded the same way as a message cursor
+type conversationCursor struct {
+	LastActivityAt time.Time
+	ID             uuid.UUID
+}
+
+func (c conversationCursor) String() string {
+	return messageCursor{CreatedAt: c.LastActivityAt, ID: c.ID}.String()
+}
+
+func (c conversationCursor) compare(other conversationCursor) int {
+	if n := c.LastActivityAt.Compare(other.LastActivityAt); n != 0 {
+		return n
+	}
+	return bytes.Compare(c.ID[:], other.ID[:])
+}
+
+func decodeConversationCursor(s string) (conversationCursor, error) {
+	cursor, err := decodeMessageCursor(s)
+	if err != nil {
+		return conversationCursor{}, err
+	}
+	return conversationCursor{LastActivityAt: cursor.CreatedAt, ID: cursor.ID}, nil
+}
+
+func (r conversationResponse) position() conversationCursor {
+	if r.UserData != nil {
+		return conversationCursor{LastActivityAt: r.UserData.LastActivityAt.Time, ID: r.UserData.ConversationID}
+	}
+	return conversationCursor{LastActivityAt: r.Group.LastActivityAt.Time, ID: r.Group.ConversationID}
+}
+
+type groupConversation struct {
+	queries.GetGroupConversationsByUserIDRow
+	OnlineMemberCount int `json:"online_member_count"`
+}
+
+type conversationMemberResponse struct {
+	queries.GetConversationMembersRow
+	IsOnline bool `json:"is_online"`
 }
 
 // WELL, WELL, we gotta fix this ASAP
 func (m *conversationResponse) message() {}
 
 
+// getConversationsHandler lists direct conversations and groups together, most recently active first.
+// archived=true lists the archived ones instead
 func (a *api) getConversationsHandler(c echo.Context) error {
 	user := c.Get(userCtxValKey).(queries.User)
-	conversationsDB, err := a.storage.Conversations.GetByUserID(c.Request().Context(), user.ID)
+	ctx := c.Request().Context()
+
+	limit, err := a.conversationPageSize(c.QueryParam("limit"))
 	if err != nil {
-		switch err {
-		case store.ErrNotFound:
-			a.notFoundLog(c.Request().Method, c.Path(), err)
-			return echo.NewHTTPError(http.StatusNotFound, err.Error())
-		default:
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	page := conversationPage{
+		// one extra row tells whether there is anything left
+		pageSize: int32(limit + 1),
+		archived: c.QueryParam("archived") == "true",
+	}
+
+	before := c.QueryParam("before")
+	if before != "" {
+		cursor, err := decodeConversationCursor(before)
+		if err != nil {
+			a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		page.beforeActivity = pgtype.Timestamptz{Time: cursor.LastActivityAt, Valid: true}
+		page.beforeID = pgtype.UUID{Bytes: cursor.ID, Valid: true}
+	}
+
+	convaersations, err := a.listConversations(ctx, user.ID, page)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	var resp conversationListResponse
+	if len(convaersations) > limit {
+		convaersations = convaersations[:limit]
+		resp.NextCursor = convaersations[limit-1].position().String()
+	}
+
+	// pinned conversations aren't paged, they all go on top of the first page
+	var pinned []conversationResponse
+	if before == "" && !page.archived {
+		pinned, err = a.listConversations(ctx, user.ID, conversationPage{
+			pageSize: maxPinnedConversations,
+			pinned:   true,
+		})
+		if err != nil {
 			a.internalErrLog(c.Request().Method, c.Path(), err)
 			return echo.NewHTTPError(http.StatusInternalServerError)
 		}
 	}
+	resp.Conversations = append(pinned, convaersations...)
+
+	if err := a.decorateConversations(ctx, user.ID, resp.Conversations); err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	// the pin positions are only known once decorated
+	slices.SortStableFunc(resp.Conversations[:len(pinned)], func(x, y conversationResponse) int {
+		return cmp.Compare(x.Settings.pinRank(), y.Settings.pinRank())
+	})
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// conversationPage is which part of the conversation list to load
+type conversationPage struct {
+	beforeActivity pgtype.Timestamptz
+	beforeID       pgtype.UUID
+	pageSize       int32
+	pinned         bool
+	archived       bool
+}
+
+// listConversations loads a page of each kind and merges them, most recently active first.
+// The merged page can't go past either of them
+func (a *api) listConversations(ctx context.Context, userID uuid.UUID, page conversationPage) ([]conversationResponse, error) {
+	conversationsDB, err := a.storage.Conversations.GetByUserID(ctx, queries.GetConversationsByUserIDParams{
+		UserID:         userID,
+		Pinned:         page.pinned,
+		Archived:       page.archived,
+		BeforeActivity: page.beforeActivity,
+		BeforeID:       page.beforeID,
+		PageSize:       page.pageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groupsDB, err := a.storage.Conversations.GetGroupsByUserID(ctx, queries.GetGroupConversationsByUserIDParams{
+		UserID:         userID,
+		Pinned:         page.pinned,
+		Archived:       page.archived,
+		BeforeActivity: page.beforeActivity,
+		BeforeID:       page.beforeID,
+		PageSize:       page.pageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	convaersations := make([]conversationResponse, 0, len(conversationsDB)+len(groupsDB))
+	for _, c := range conversationsDB {
+		convaersations = append(convaersations, conversationResponse{
+			UserData: &c,
+		})
+	}
+
+	for _, g := range groupsDB {
+		convaersations = append(convaersations, conversationResponse{
+			Group: &groupConversation{
+				GetGroupConversationsByUserIDRow: g,
+			},
+		})
+	}
+
+	slices.SortFunc(convaersations, func(x, y conversationResponse) int {
+		return y.position().compare(x.position())
+	})
+
+	return convaersations, nil
+}
+
+func (a *api) conversationPageSize(param string) (int, error) {
+	if param == "" {
+		return a.conversationListConfig.defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive number")
+	}
+
+	return min(limit, a.conversationListConfig.maxPageSize), nil
+}
+
+// decorateConversations fills in presence, the last message and the user's settings of a page of conversations
+func (a *api) decorateConversations(ctx context.Context, viewerID uuid.UUID, conversations []conversationResponse) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(conversations))
+	var userIDs, groupIDs []uuid.UUID
+	for i, c := range conversations {
+		ids[i] = c.position().ID
+		if c.UserData != nil {
+			userIDs = append(userIDs, c.UserData.ID)
+		} else {
+			groupIDs = append(groupIDs, c.Group.ConversationID)
+		}
+	}
+
+	groupMembers := make(map[uuid.UUID][]uuid.UUID, len(groupIDs))
+	if len(groupIDs) > 0 {
+		members, err := a.storage.Conversations.GetMembersOfMany(ctx, groupIDs)
+		if err != nil {
+			return err
+		}
+
+		// the viewer doesn't count towards the members online, they know they are
+		for _, m := range members {
+			if m.UserID == viewerID {
+				continue
+			}
+			groupMembers[m.ConversationID] = append(groupMembers[m.ConversationID], m.UserID)
+			userIDs = append(userIDs, m.UserID)
+		}
+	}
+
+	slices.SortFunc(userIDs, compareUUIDs)
+	online := a.onlineUsers(ctx, slices.Compact(userIDs))
+
+	lastMessages, err := a.storage.Messages.GetLast(ctx, queries.GetLastMessagesParams{
+		ConversationIds: ids,
+		ViewerID:        viewerID,
+	})
+	if err != nil {
+		return err
+	}
+
+	settingsDB, err := a.storage.ConversationSettings.Get(ctx, queries.GetConversationSettingsParams{
+		UserID:          viewerID,
+		ConversationIds: ids,
+	})
+	if err != nil {
+		return err
+	}
 
- 	convaersations := make([]conversationResponse, len(conversationsDB))
+	settings := make(map[uuid.UUID]conversationSettingsResponse, len(settingsDB))
+	for _, s := range settingsDB {
+		settings[s.ConversationID] = newConversationSettings(s)
+	}
+
+	byConversation := make(map[uuid.UUID]*LastMessage, len(lastMessages))
+	for _, m := range lastMessages {
+		byConversation[m.ConversationID] = &LastMessage{
+			ID:              m.ID,
+			SenderID:        m.SenderID,
+			Preview:         m.Preview,
+			AttachmentCount: m.AttachmentCount,
+			Deleted:         m.DeletedAt.Valid,
+			Status:          messageStage(m.RecipientCount, m.DeliveredCount, m.ReadCount),
+			CreatedAt:       m.CreatedAt.Time,
+		}
+	}
 
-	for i, c := range conversationsDB {
-		_, isOnline := a.clients.Load(c.ID.String())
-		convaersations[i] = conversationResponse{
-			UserData: c,
-			UserIsOnline: isOnline,
+	for i, c := range conversations {
+		conversations[i].LastMessage = byConversation[c.position().ID]
+		conversations[i].Settings = settings[c.position().ID]
+		if c.UserData != nil {
+			conversations[i].UserIsOnline = online[c.UserData.ID]
+		} else {
+			for _, memberID := range groupMembers[c.Group.ConversationID] {
+				if online[memberID] {
+					conversations[i].Group.OnlineMemberCount++
+				}
+			}
 		}
 	}
 
-	return c.JSON(http.StatusOK, convaersations)
+	return nil
 }
 
 func (a *api) createConversationHandler(c echo.Context) error {
@@ -65,12 +341,49 @@ func (a *api) createConversationHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err)
 	}
 
-	user1ValidID, _ := uuid.Parse(payload.User1)
-	user2ValidID, _ := uuid.Parse(payload.User2)
+	if payload.User1 != "" && payload.User1 != user.ID.String() {
+		return echo.NewHTTPError(http.StatusForbidden, "conversations can only be started by the caller")
+	}
+
+	peerIDText := payload.UserID
+	if peerIDText == "" {
+		peerIDText = payload.User2
+	}
+
+	if peerIDText == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "user_id is required")
+	}
+
+	peerID, err := uuid.Parse(peerIDText)
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+	}
+
+	if peerID == user.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "you can't start a conversation with yourself")
+	}
+
+	requestPending, err := a.canStartConversation(c.Request().Context(), user.ID, peerID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		case errUserBlocked, errContactsOnly:
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		case errRequestDeclined:
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
 
 	conversation, err := a.storage.Conversations.Create(c.Request().Context(), queries.CreateConversationParams{
-		User1: user1ValidID,
-		User2: user2ValidID,
+		User1:          user.ID,
+		User2:          peerID,
+		RequestPending: requestPending,
 	})
 
 	if err != nil {
@@ -87,17 +400,163 @@ func (a *api) createConversationHandler(c echo.Context) error {
 		}
 	}
 
-	_, isOnline := a.clients.Load(user1ValidID.String())
+	isOnline := a.isOnline(c.Request().Context(), user.ID)
+
+	if requestPending {
+		go a.notifyMessageRequest(peerID, &MessageRequest{
+			GetMessageRequestsRow: queries.GetMessageRequestsRow{
+				ConversationID: conversation.ID,
+				ID:             user.ID,
+				Username:       user.Username,
+				LastSeen:       user.LastSeen,
+				CreatedAt:      conversation.CreatedAt,
+			},
+			IsOnline: isOnline,
+		})
+		return c.JSON(http.StatusOK, conversation)
+	}
 
-	go a.notifyConversationCreation(user2ValidID, conversationResponse{
-		UserData: queries.GetConversationsByUserIDRow{
-			ID: user1ValidID,
-			LastSeen: user.LastSeen,
+	go a.notifyConversationCreation(peerID, conversationResponse{
+		UserData: &queries.GetConversationsByUserIDRow{
+			ID:             user.ID,
+			LastSeen:       user.LastSeen,
 			ConversationID: conversation.ID,
-			Username: user.Username,
+			Username:       user.Username,
+			LastActivityAt: conversation.LastActivityAt,
 		},
 		UserIsOnline: isOnline,
 	})
 
 	return c.JSON(http.StatusOK, conversation)
 }
+
+func (a *api) createGroupConversationHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	var payload createGroupPayload
+	if err := c.Bind(&payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := a.validator.Struct(payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	memberIDs := []uuid.UUID{user.ID}
+	seen := map[uuid.UUID]bool{user.ID: true}
+
+	for _, id := range payload.MemberIDs {
+		validID, err := uuid.Parse(id)
+		if err != nil {
+			a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+		}
+
+		if seen[validID] {
+			continue
+		}
+		seen[validID] = true
+		memberIDs = append(memberIDs, validID)
+	}
+
+	if len(memberIDs) < 2 {
+		return echo.NewHTTPError(http.StatusBadRequest, "a group needs at least one other member")
+	}
+
+	// adding someone to a group messages them as much as a direct conversation does
+	for _, memberID := range memberIDs[1:] {
+		requestPending, err := a.canStartConversation(c.Request().Context(), user.ID, memberID)
+		if err == nil && requestPending {
+			err = errGroupNeedsRequest
+		}
+		if err != nil {
+			switch err {
+			case store.ErrNotFound:
+				a.notFoundLog(c.Request().Method, c.Path(), err)
+				return echo.NewHTTPError(http.StatusNotFound, err.Error())
+			case errUserBlocked, errContactsOnly, errRequestDeclined, errGroupNeedsRequest:
+				return echo.NewHTTPError(http.StatusForbidden, err.Error())
+			default:
+				a.internalErrLog(c.Request().Method, c.Path(), err)
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+		}
+	}
+
+	conversation, err := a.storage.Conversations.CreateGroup(c.Request().Context(), queries.CreateGroupConversationParams{
+		Title:     payload.Title,
+		CreatedBy: user.ID,
+	}, memberIDs)
+
+	if err != nil {
+		switch err {
+		case store.ErrConstraintMessage:
+			a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid user id")
+		default:
+			a.internalErrLog(c.Request().RequestURI, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	group := conversationResponse{
+		Group: &groupConversation{
+			GetGroupConversationsByUserIDRow: queries.GetGroupConversationsByUserIDRow{
+				ConversationID: conversation.ID,
+				Title:          conversation.Title,
+				CreatedBy:      conversation.CreatedBy,
+				CreatedAt:      conversation.CreatedAt,
+				LastActivityAt: conversation.LastActivityAt,
+				MemberCount:    int64(len(memberIDs)),
+			},
+		},
+	}
+
+	for _, memberID := range memberIDs[1:] {
+		go a.notifyConversationCreation(memberID, group)
+	}
+
+	return c.JSON(http.StatusOK, conversation)
+}
+
+func (a *api) getConversationMembersHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid conversation id")
+	}
+
+	isMember, err := a.storage.Conversations.IsMember(c.Request().Context(), conversationID, user.ID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if !isMember {
+		return echo.NewHTTPError(http.StatusNotFound, store.ErrNotFound.Error())
+	}
+
+	membersDB, err := a.storage.Conversations.GetMembers(c.Request().Context(), conversationID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	memberIDs := make([]uuid.UUID, len(membersDB))
+	for i, m := range membersDB {
+		memberIDs[i] = m.ID
+	}
+	online := a.onlineUsers(c.Request().Context(), memberIDs)
+
+	members := make([]conversationMemberResponse, len(membersDB))
+	for i, m := range membersDB {
+		members[i] = conversationMemberResponse{
+			GetConversationMembersRow: m,
+			IsOnline:                  online[m.ID],
+		}
+	}
+
+	return c.JSON(http.StatusOK, members)
+}
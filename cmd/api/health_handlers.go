@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type healthConfig struct {
+	// guards /debug/status and /metrics, without one they're only served outside of production
+	debugToken string
+	production bool
+	broker     string
+}
+
+type readinessResponse struct {
+	Ready bool `json:"ready"`
+	// "ok" or what is wrong, per check
+	Checks map[string]string `json:"checks"`
+}
+
+type debugStatusResponse struct {
+	NodeID     uuid.UUID         `json:"node_id"`
+	StartedAt  time.Time         `json:"started_at"`
+	Uptime     string            `json:"uptime"`
+	Build      buildInfo         `json:"build"`
+	Database   databaseStatus    `json:"database"`
+	WebSocket  websocketStatus   `json:"websocket"`
+	Broker     string            `json:"broker"`
+	Goroutines int               `json:"goroutines"`
+	Memory     map[string]uint64 `json:"memory"`
+}
+
+type buildInfo struct {
+	GoVersion    string `json:"go_version"`
+	Revision     string `json:"revision,omitempty"`
+	RevisionTime string `json:"revision_time,omitempty"`
+	// built with uncommitted changes
+	Modified bool `json:"modified"`
+}
+
+type databaseStatus struct {
+	MaxConns             int32  `json:"max_conns"`
+	TotalConns           int32  `json:"total_conns"`
+	IdleConns            int32  `json:"idle_conns"`
+	AcquiredConns        int32  `json:"acquired_conns"`
+	AcquireCount         int64  `json:"acquire_count"`
+	EmptyAcquireCount    int64  `json:"empty_acquire_count"`
+	CanceledAcquireCount int64  `json:"canceled_acquire_count"`
+	AcquireDuration      string `json:"acquire_duration"`
+}
+
+type websocketStatus struct {
+	// every open connection, authenticated or not
+	Connections int `json:"connections"`
+	Users       int `json:"users"`
+	Devices     int `json:"devices"`
+}
+
+// healthzHandler only tells the process is up, it doesn't look at anything it depends on
+func (a *api) healthzHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler tells whether this node should get traffic
+func (a *api) readyzHandler(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 2*time.Second)
+	defer cancel()
+
+	resp := readinessResponse{
+		Ready: true,
+		Checks: map[string]string{
+			"database":   "ok",
+			"migrations": "ok",
+			"websocket":  "ok",
+		},
+	}
+
+	fail := func(check, reason string) {
+		resp.Ready = false
+		resp.Checks[check] = reason
+	}
+
+	if err := a.db.Ping(ctx); err != nil {
+		fail("database", err.Error())
+	}
+
+	if pending, err := a.migrator.Pending(ctx); err != nil {
+		fail("migrations", err.Error())
+	} else if len(pending) > 0 {
+		fail("migrations", fmt.Sprintf("%d pending, the schema is behind version %d", len(pending), a.migrator.Latest()))
+	}
+
+	if a.shuttingDown.Load() {
+		fail("websocket", "shutting down")
+	} else if a.mel.IsClosed() {
+		fail("websocket", "not accepting connections")
+	}
+
+	if !resp.Ready {
+		return c.JSON(http.StatusServiceUnavailable, resp)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *api) debugStatusHandler(c echo.Context) error {
+	if !a.debugAllowed(c) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	stat := a.db.Stat()
+	users, devices := a.clients.counts()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return c.JSON(http.StatusOK, debugStatusResponse{
+		NodeID:    a.nodeID,
+		StartedAt: a.startedAt,
+		Uptime:    time.Since(a.startedAt).Truncate(time.Second).String(),
+		Build:     readBuildInfo(),
+		Database: databaseStatus{
+			MaxConns:             stat.MaxConns(),
+			TotalConns:           stat.TotalConns(),
+			IdleConns:            stat.IdleConns(),
+			AcquiredConns:        stat.AcquiredConns(),
+			AcquireCount:         stat.AcquireCount(),
+			EmptyAcquireCount:    stat.EmptyAcquireCount(),
+			CanceledAcquireCount: stat.CanceledAcquireCount(),
+			AcquireDuration:      stat.AcquireDuration().String(),
+		},
+		WebSocket: websocketStatus{
+			Connections: a.mel.Len(),
+			Users:       users,
+			Devices:     devices,
+		},
+		Broker:     a.healthConfig.broker,
+		Goroutines: runtime.NumGoroutine(),
+		Memory: map[string]uint64{
+			"heap_alloc": mem.HeapAlloc,
+			"heap_sys":   mem.HeapSys,
+			"sys":        mem.Sys,
+			"num_gc":     uint64(mem.NumGC),
+		},
+	})
+}
+
+// debugAllowed checks the bearer token when there is one configured
+func (a *api) debugAllowed(c echo.Context) bool {
+	if a.healthConfig.debugToken == "" {
+		return !a.healthConfig.production
+	}
+
+	token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.healthConfig.debugToken)) == 1
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{GoVersion: runtime.Version()}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.RevisionTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
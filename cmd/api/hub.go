@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/olahol/melody"
+)
+
+// hub keeps track of every authenticated websocket session.
+// A user can be connected from several devices at once, each device
+// holds exactly one session.
+type hub struct {
+	mu sync.RWMutex
+	// user id -> device id -> session
+	clients map[uuid.UUID]map[string]*melody.Session
+}
+
+func newHub() *hub {
+	return &hub{
+		clients: make(map[uuid.UUID]map[string]*melody.Session),
+	}
+}
+
+// add registers the session of a device, first reports whether
+// this is the only device the user is connected from.
+// If the device was already connected its old session is returned so it can be closed.
+func (h *hub) add(userID uuid.UUID, deviceID string, s *melody.Session) (first bool, replaced *melody.Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	devices, ok := h.clients[userID]
+	if !ok {
+		devices = make(map[string]*melody.Session)
+		h.clients[userID] = devices
+	}
+
+	replaced = devices[deviceID]
+	devices[deviceID] = s
+
+	return len(devices) == 1 && replaced == nil, replaced
+}
+
+// remove unregisters the session of a device, last reports whether
+// the user doesn't have any devices connected anymore.
+// Nothing happens if the device has already been taken over by a newer session.
+func (h *hub) remove(userID uuid.UUID, deviceID string, s *melody.Session) (last bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	devices, ok := h.clients[userID]
+	if !ok || devices[deviceID] != s {
+		return false
+	}
+
+	delete(devices, deviceID)
+	if len(devices) == 0 {
+		delete(h.clients, userID)
+		return true
+	}
+
+	return false
+}
+
+// sessions returns a snapshot of the sessions of every device of the user
+func (h *hub) sessions(userID uuid.UUID) []*melody.Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	devices := h.clients[userID]
+	sessions := make([]*melody.Session, 0, len(devices))
+	for _, s := range devices {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (h *hub) isOnline(userID uuid.UUID) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.clients[userID]
+	return ok
+}
+
+// counts returns how many users and sessions are connected
+func (h *hub) counts() (users, sessions int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, devices := range h.clients {
+		sessions += len(devices)
+	}
+	return len(h.clients), sessions
+}
@@ -1,20 +1,32 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/myselfBZ/chatrix-v2/cmd/migrate/migrations"
 	"github.com/myselfBZ/chatrix-v2/internal/auth"
+	"github.com/myselfBZ/chatrix-v2/internal/blob"
+	"github.com/myselfBZ/chatrix-v2/internal/broker"
+	"github.com/myselfBZ/chatrix-v2/internal/config"
 	"github.com/myselfBZ/chatrix-v2/internal/db"
+	"github.com/myselfBZ/chatrix-v2/internal/migrate"
 	"github.com/myselfBZ/chatrix-v2/internal/store"
 	"github.com/olahol/melody"
 )
@@ -23,22 +35,60 @@ const (
 	userCtxValKey = "user"
 )
 
-func newApi(port int) *api {
+func newApi(cfg *config.Config) *api {
 	m := melody.New()
-	m.Upgrader.ReadBufferSize = 1024 * 10
-	m.Upgrader.WriteBufferSize = 1024 * 10
-	m.Config.MaxMessageSize = 1024 * 10
+	m.Upgrader.ReadBufferSize = cfg.WebSocket.ReadBufferSize
+	m.Upgrader.WriteBufferSize = cfg.WebSocket.WriteBufferSize
+	m.Config.MaxMessageSize = cfg.WebSocket.MaxMessageSize
 	a := &api{
 		authConfig: authConfig{
-			accessSecret:  "something",
-			refreshSecret: "something",
-			iss:           "chatrix",
-			aud:           "chatrix",
+			accessSecret:  cfg.Auth.AccessSecret,
+			refreshSecret: cfg.Auth.RefreshSecret,
+			iss:           cfg.Auth.Issuer,
+			aud:           cfg.Auth.Audience,
+			cookieDomain:  cfg.Auth.CookieDomain,
 		},
-		port: port,
-		mel:  m,
+		historyConfig: historyConfig{
+			defaultPageSize: cfg.History.DefaultPageSize,
+			maxPageSize:     cfg.History.MaxPageSize,
+		},
+		messageConfig: messageConfig{
+			editWindow:    cfg.Messages.EditWindow,
+			deleteForBoth: cfg.Messages.DeleteForBoth,
+		},
+		searchConfig: searchConfig{
+			defaultPageSize: cfg.Search.DefaultPageSize,
+			maxPageSize:     cfg.Search.MaxPageSize,
+		},
+		conversationListConfig: conversationListConfig{
+			defaultPageSize: cfg.Conversations.DefaultPageSize,
+			maxPageSize:     cfg.Conversations.MaxPageSize,
+		},
+		syncConfig: syncConfig{
+			pageSize:  cfg.Sync.PageSize,
+			retention: cfg.Sync.Retention,
+		},
+		attachmentConfig: attachmentConfig{
+			maxImageSize:  cfg.Attachments.MaxImageSize,
+			maxVideoSize:  cfg.Attachments.MaxVideoSize,
+			maxAudioSize:  cfg.Attachments.MaxAudioSize,
+			maxFileSize:   cfg.Attachments.MaxFileSize,
+			urlTTL:        cfg.Attachments.URLTTL,
+			signingSecret: cfg.Attachments.SigningSecret,
+		},
+		healthConfig: healthConfig{
+			debugToken: cfg.Server.DebugToken,
+			production: cfg.IsProduction(),
+			broker:     cfg.Broker.Backend,
+		},
+		port:        cfg.Server.Port,
+		corsOrigins: cfg.Server.Origins(),
+		mel:         m,
+		clients:     newHub(),
+		nodeID:      uuid.New(),
+		startedAt:   time.Now(),
 	}
-	logger := zap.Must(zap.NewProduction(zap.AddCaller())).Sugar()
+	logger := zap.Must(newLogger(cfg)).Sugar()
 	defer logger.Sync()
 
 	a.logger = logger
@@ -51,25 +101,53 @@ func newApi(port int) *api {
 		a.authConfig.iss,
 	)
 
-	dbUrl := os.Getenv("DB")
-
-	if dbUrl == "" {
-		panic("DB url is not set")
-	}
-
 	db, err := db.New(db.Config{
-		Addr:        dbUrl,
-		MaxConns:    15,
-		MinConns:    15,
-		MaxIdleTime: "15m",
+		Addr:        cfg.DB.URL,
+		MaxConns:    cfg.DB.MaxConns,
+		MinConns:    cfg.DB.MinConns,
+		MaxIdleTime: cfg.DB.MaxIdleTime.String(),
 	})
 
 	if err != nil {
 		panic(err)
 	}
 
+	a.db = db
+	a.migrator, err = migrate.New(db, migrations.FS)
+	if err != nil {
+		panic(err)
+	}
+
+	if cfg.DB.AutoMigrate {
+		if err := a.migrate(); err != nil {
+			panic(err)
+		}
+	}
+
 	a.storage = *store.NewStorage(db)
 
+	switch cfg.Broker.Backend {
+	case "postgres":
+		a.broker = broker.NewPostgresBroker(db, logger)
+	default:
+		a.broker = broker.NewMemoryBroker()
+	}
+	a.subscribe()
+	a.registerMetrics()
+
+	// the node has to be registered before anyone connects to it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.storage.Presence.Heartbeat(ctx, a.nodeID); err != nil {
+		panic(err)
+	}
+
+	blobs, err := newBlobStore(cfg.Blob)
+	if err != nil {
+		panic(err)
+	}
+	a.blobs = blobs
+
 	m.HandleMessage(a.handleMessage)
 	m.HandleConnect(a.handleConnect)
 	m.HandleDisconnect(a.handleDisconnect)
@@ -77,31 +155,82 @@ func newApi(port int) *api {
 }
 
 type api struct {
-	auth       auth.Authenticator
-	authConfig authConfig
-	port       int
-	mel        *melody.Melody
-	validator  *validator.Validate
-	storage    store.Storage
-	clients    sync.Map
-	logger     *zap.SugaredLogger
+	auth                   auth.Authenticator
+	authConfig             authConfig
+	historyConfig          historyConfig
+	messageConfig          messageConfig
+	searchConfig           searchConfig
+	conversationListConfig conversationListConfig
+	syncConfig             syncConfig
+	attachmentConfig       attachmentConfig
+	healthConfig           healthConfig
+	port                   int
+	corsOrigins            []string
+	mel                    *melody.Melody
+	validator              *validator.Validate
+	storage                store.Storage
+	db                     *pgxpool.Pool
+	migrator               *migrate.Migrator
+	blobs                  blob.BlobStore
+	clients                *hub
+	broker                 broker.Broker
+	logger                 *zap.SugaredLogger
+	// identifies this process in the presence registry
+	nodeID        uuid.UUID
+	presenceLocks presenceLocks
+	startedAt     time.Time
+	// set once the process starts shutting down
+	shuttingDown atomic.Bool
+	// websocket connections that haven't run their disconnect handler yet
+	openConnections atomic.Int64
 }
 
-// handlers
+// newBlobStore picks the storage backend for attachments
+func newBlobStore(cfg config.BlobConfig) (blob.BlobStore, error) {
+	switch cfg.Backend {
+	case "local":
+		return blob.NewLocalStore(cfg.Dir)
+	case "s3":
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Bucket:    cfg.S3.Bucket,
+			Region:    cfg.S3.Region,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.Backend)
+	}
+}
 
-func (a *api) serve() error {
-	e := echo.New()
-	prodFrontEnd := os.Getenv("FRONTEND_URL")
-	if prodFrontEnd == "" {
-		panic("production front end is not set!")
+func (a *api) migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	ran, err := a.migrator.Up(ctx)
+	for _, migration := range ran {
+		a.logger.Infow("applied migration", "version", migration.Version, "name", migration.Name)
 	}
-	localFrotnEndUrls := []string{
-		"http://localhost:5173",
-		"http://localhost:5174",
+	return err
+}
+
+// newLogger logs JSON in production and readable lines while developing
+func newLogger(cfg *config.Config) (*zap.Logger, error) {
+	if cfg.IsProduction() {
+		return zap.NewProduction(zap.AddCaller())
 	}
+	return zap.NewDevelopment(zap.AddCaller())
+}
+
+// handlers
+
+func (a *api) routes() *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
 	e.Use(middleware.RequestLogger())
+	e.Use(metricsMiddleware)
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{localFrotnEndUrls[0], localFrotnEndUrls[1], prodFrontEnd},
+		AllowOrigins: a.corsOrigins,
 		AllowMethods: []string{echo.GET, echo.POST, echo.PUT, echo.DELETE, echo.OPTIONS},
 		AllowHeaders: []string{
 			echo.HeaderOrigin,
@@ -112,10 +241,17 @@ func (a *api) serve() error {
 		AllowCredentials: true,
 	}))
 
+	e.GET("/healthz", a.healthzHandler)
+	e.GET("/readyz", a.readyzHandler)
+	e.GET("/debug/status", a.debugStatusHandler)
+	e.GET("/metrics", a.metricsHandler)
+
 	e.GET("/ws", a.handleWebSocket)
 	e.POST("/auth/token", a.createTokenHandler)
 	e.POST("/auth/users", a.createUserHandler)
 	e.POST("/auth/refresh", a.refreshTokenHandler)
+	e.POST("/auth/logout", a.logoutHandler)
+	e.GET("/attachments/:id/download", a.downloadAttachmentHandler)
 
 	e.GET("/protected", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]string{
@@ -125,16 +261,92 @@ func (a *api) serve() error {
 
 	authenticatedRoutes := e.Group("/authenticated", a.AuthMiddleware)
 
+	authenticatedRoutes.POST("/auth/logout-all", a.logoutAllHandler)
+	authenticatedRoutes.GET("/sessions", a.getSessionsHandler)
+	authenticatedRoutes.DELETE("/sessions/:id", a.revokeSessionHandler)
+
 	authenticatedRoutes.POST("/conversations", a.createConversationHandler)
+	authenticatedRoutes.POST("/conversations/groups", a.createGroupConversationHandler)
+	authenticatedRoutes.GET("/conversations/:id/members", a.getConversationMembersHandler)
 	authenticatedRoutes.GET("/conversations/mine", a.getConversationsHandler)
+	authenticatedRoutes.GET("/conversations/requests", a.getMessageRequestsHandler)
+	authenticatedRoutes.POST("/conversations/requests/:id/accept", a.acceptMessageRequestHandler)
+	authenticatedRoutes.DELETE("/conversations/requests/:id", a.declineMessageRequestHandler)
+	authenticatedRoutes.PUT("/conversations/pins", a.reorderPinsHandler)
+	authenticatedRoutes.PUT("/conversations/:id/archive", a.archiveConversationHandler)
+	authenticatedRoutes.DELETE("/conversations/:id/archive", a.unarchiveConversationHandler)
+	authenticatedRoutes.PUT("/conversations/:id/pin", a.pinConversationHandler)
+	authenticatedRoutes.DELETE("/conversations/:id/pin", a.unpinConversationHandler)
+	authenticatedRoutes.PUT("/conversations/:id/mute", a.muteConversationHandler)
+	authenticatedRoutes.DELETE("/conversations/:id/mute", a.unmuteConversationHandler)
+	authenticatedRoutes.POST("/conversations/:id/clear", a.clearConversationHandler)
+	authenticatedRoutes.DELETE("/conversations/:id", a.deleteConversationHandler)
+	authenticatedRoutes.DELETE("/conversations/:id/deletion-request", a.cancelDeletionRequestHandler)
 	authenticatedRoutes.GET("/messages", a.getMessageHistoryHandler)
+	authenticatedRoutes.GET("/messages/search", a.searchMessagesHandler)
+	authenticatedRoutes.PUT("/messages/:id", a.editMessageHandler)
+	authenticatedRoutes.DELETE("/messages/:id", a.deleteMessageHandler)
+	authenticatedRoutes.GET("/messages/:id/edits", a.getMessageEditsHandler)
+	authenticatedRoutes.GET("/messages/:id/receipts", a.getMessageReceiptsHandler)
+	authenticatedRoutes.POST("/attachments", a.uploadAttachmentHandler)
+	authenticatedRoutes.GET("/attachments/:id", a.getAttachmentHandler)
+	authenticatedRoutes.GET("/contacts", a.getContactsHandler)
+	authenticatedRoutes.POST("/contacts", a.addContactHandler)
+	authenticatedRoutes.GET("/contacts/search", a.searchContactsHandler)
+	authenticatedRoutes.PUT("/contacts/:user_id", a.renameContactHandler)
+	authenticatedRoutes.DELETE("/contacts/:user_id", a.deleteContactHandler)
 	authenticatedRoutes.POST("/users/search", a.searchUserHandler)
+	authenticatedRoutes.PUT("/users/me/privacy", a.updateMessagePrivacyHandler)
+	authenticatedRoutes.GET("/blocks", a.getBlockedUsersHandler)
+	authenticatedRoutes.PUT("/blocks/:user_id", a.blockUserHandler)
+	authenticatedRoutes.DELETE("/blocks/:user_id", a.unblockUserHandler)
 
-	return e.Start(fmt.Sprintf(":%d", a.port))
+	return e
 }
 
 func main() {
-	a := newApi(8080)
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file, optional")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		slog.Error("invalid config", "error", err)
+		os.Exit(1)
+	}
+
+	a := newApi(cfg)
+
+	// background work stops on the first signal, a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go a.pruneEvents(ctx)
+	go a.heartbeat(ctx)
+	go func() {
+		if err := a.broker.Run(ctx); err != nil {
+			a.logger.Errorw("broker stopped", "error", err.Error())
+		}
+	}()
+
+	e := a.routes()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- e.Start(fmt.Sprintf(":%d", a.port))
+	}()
 	slog.Info("Runnin'...")
-	a.serve()
+
+	select {
+	case err := <-serveErr:
+		a.logger.Errorw("server stopped", "error", err.Error())
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
+
+	if err := a.shutdown(e, cfg.Server.ShutdownTimeout); err != nil {
+		a.logger.Errorw("shutdown wasn't clean", "error", err.Error())
+		a.logger.Sync()
+		os.Exit(1)
+	}
+	a.logger.Sync()
 }
@@ -1,27 +1,155 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/myselfBZ/chatrix-v2/internal/queries"
 	"github.com/myselfBZ/chatrix-v2/internal/store"
 )
 
-func (a *api) getMessageHistoryHandler(c echo.Context) error {
-	id := c.QueryParam("with_id")
+type historyConfig struct {
+	defaultPageSize int
+	maxPageSize     int
+}
+
+// Stages of a message, for groups a stage is only reached once every recipient got there
+const (
+	messageSent      = "sent"
+	messageDelivered = "delivered"
+	messageRead      = "read"
+)
+
+type messageResponse struct {
+	queries.Message
+	Status      string               `json:"status"`
+	Quoted      *QuotedMsg           `json:"quoted,omitempty"`
+	Reactions   []reactionSummary    `json:"reactions"`
+	Attachments []attachmentResponse `json:"attachments"`
+}
+
+type reactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int64  `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// Messages are always in chronological order, whichever direction the page was loaded in
+type messageHistoryResponse struct {
+	Messages []messageResponse `json:"messages"`
+	// Continues in the direction the page was requested in,
+	// older messages unless after= was used
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Pass as before= to load older messages, empty when there aren't any
+	OlderCursor string `json:"older_cursor,omitempty"`
+	// Pass as after= to load newer messages, empty when there aren't any
+	NewerCursor string `json:"newer_cursor,omitempty"`
+}
+
+// messageCursor is a position in a conversation, messages are ordered by (created_at, id)
+type messageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func cursorOf(m queries.Message) messageCursor {
+	return messageCursor{
+		CreatedAt: m.CreatedAt.Time,
+		ID:        m.ID,
+	}
+}
+
+func (c messageCursor) String() string {
+	raw := fmt.Sprintf("%d:%s", c.CreatedAt.UnixMicro(), c.ID.String())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeMessageCursor(s string) (messageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return messageCursor{}, errInvalidCursor
+	}
+
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return messageCursor{}, errInvalidCursor
+	}
+
+	unixMicro, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return messageCursor{}, errInvalidCursor
+	}
+
 	validUUID, err := uuid.Parse(id)
 	if err != nil {
-		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		return messageCursor{}, errInvalidCursor
 	}
 
+	return messageCursor{
+		CreatedAt: time.UnixMicro(unixMicro),
+		ID:        validUUID,
+	}, nil
+}
+
+func (a *api) getMessageHistoryHandler(c echo.Context) error {
 	user := c.Get(userCtxValKey).(queries.User)
 
-	conversation, err := a.storage.Conversations.GetByMembers(c.Request().Context(), queries.GetConversationByMembersParams{
-		User1: user.ID,
-		User2: validUUID,
-	})
+	conversationID, err := a.resolveHistoryConversation(c, user)
+	if err != nil {
+		return err
+	}
+
+	limit, err := a.historyPageSize(c.QueryParam("limit"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	var resp messageHistoryResponse
+
+	switch {
+	case c.QueryParam("around") != "":
+		messageID, parseErr := uuid.Parse(c.QueryParam("around"))
+		if parseErr != nil {
+			a.badRequestLog(c.Request().RequestURI, c.Path(), parseErr)
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+		}
+		resp, err = a.messagesAround(ctx, user.ID, conversationID, messageID, limit)
+	case c.QueryParam("after") != "":
+		cursor, parseErr := decodeMessageCursor(c.QueryParam("after"))
+		if parseErr != nil {
+			a.badRequestLog(c.Request().RequestURI, c.Path(), parseErr)
+			return echo.NewHTTPError(http.StatusBadRequest, parseErr.Error())
+		}
+		resp, err = a.messagesAfter(ctx, user.ID, conversationID, cursor, limit)
+	default:
+		var cursor *messageCursor
+		if before := c.QueryParam("before"); before != "" {
+			decoded, parseErr := decodeMessageCursor(before)
+			if parseErr != nil {
+				a.badRequestLog(c.Request().RequestURI, c.Path(), parseErr)
+				return echo.NewHTTPError(http.StatusBadRequest, parseErr.Error())
+			}
+			cursor = &decoded
+		}
+		resp, err = a.messagesBefore(ctx, user.ID, conversationID, cursor, limit)
+	}
+
+	if err == nil {
+		err = a.decorateMessages(ctx, user.ID, resp.Messages)
+	}
 
 	if err != nil {
 		switch err {
@@ -34,23 +162,377 @@ func (a *api) getMessageHistoryHandler(c echo.Context) error {
 		}
 	}
 
-	msgs, err := a.storage.Messages.GetByConversationID(c.Request().Context(), queries.GetMessagesByConversationIDParams{
-		// TODO, fix the pagination
-		ConversationID: conversation.ID,
-		Offset: 0,
-		Limit: 1000,
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *api) historyPageSize(param string) (int, error) {
+	if param == "" {
+		return a.historyConfig.defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive number")
+	}
+
+	return min(limit, a.historyConfig.maxPageSize), nil
+}
+
+// messagesBefore loads the page older than the cursor, or the newest page if there is no cursor
+func (a *api) messagesBefore(ctx context.Context, viewerID, conversationID uuid.UUID, cursor *messageCursor, limit int) (messageHistoryResponse, error) {
+	var (
+		msgs []queries.Message
+		err  error
+	)
+
+	// one extra row tells whether there is anything left
+	if cursor == nil {
+		msgs, err = a.storage.Messages.GetLatest(ctx, queries.GetLatestMessagesParams{
+			ConversationID: conversationID,
+			ViewerID:       viewerID,
+			PageSize:       int32(limit + 1),
+		})
+	} else {
+		msgs, err = a.storage.Messages.GetBefore(ctx, queries.GetMessagesBeforeParams{
+			ConversationID: conversationID,
+			CreatedAt:      cursor.CreatedAt,
+			ID:             cursor.ID,
+			ViewerID:       viewerID,
+			PageSize:       int32(limit + 1),
+		})
+	}
+
+	if err != nil {
+		return messageHistoryResponse{}, err
+	}
+
+	hasOlder := len(msgs) > limit
+	if hasOlder {
+		msgs = msgs[:limit]
+	}
+	slices.Reverse(msgs)
+
+	resp := newHistoryResponse(msgs, hasOlder, cursor != nil)
+	if len(msgs) == 0 && cursor != nil {
+		resp.NewerCursor = cursor.String()
+	}
+	resp.NextCursor = resp.OlderCursor
+	return resp, nil
+}
+
+func (a *api) messagesAfter(ctx context.Context, viewerID, conversationID uuid.UUID, cursor messageCursor, limit int) (messageHistoryResponse, error) {
+	msgs, err := a.storage.Messages.GetAfter(ctx, queries.GetMessagesAfterParams{
+		ConversationID: conversationID,
+		CreatedAt:      cursor.CreatedAt,
+		ID:             cursor.ID,
+		ViewerID:       viewerID,
+		PageSize:       int32(limit + 1),
+	})
+
+	if err != nil {
+		return messageHistoryResponse{}, err
+	}
+
+	hasNewer := len(msgs) > limit
+	if hasNewer {
+		msgs = msgs[:limit]
+	}
+
+	resp := newHistoryResponse(msgs, true, hasNewer)
+	if len(msgs) == 0 {
+		resp.OlderCursor = cursor.String()
+	}
+	resp.NextCursor = resp.NewerCursor
+	return resp, nil
+}
+
+// messagesAround loads a page centered on a message, used to jump to it
+func (a *api) messagesAround(ctx context.Context, viewerID, conversationID, messageID uuid.UUID, limit int) (messageHistoryResponse, error) {
+	target, err := a.storage.Messages.GetVisibleByID(ctx, queries.GetVisibleMessageByIDParams{
+		ID:       messageID,
+		ViewerID: viewerID,
+	})
+	if err != nil {
+		return messageHistoryResponse{}, err
+	}
+
+	if target.ConversationID != conversationID {
+		return messageHistoryResponse{}, store.ErrNotFound
+	}
+
+	cursor := cursorOf(target)
+	olderLimit := (limit - 1) / 2
+	newerLimit := limit - 1 - olderLimit
+
+	older, err := a.storage.Messages.GetBefore(ctx, queries.GetMessagesBeforeParams{
+		ConversationID: conversationID,
+		CreatedAt:      cursor.CreatedAt,
+		ID:             cursor.ID,
+		ViewerID:       viewerID,
+		PageSize:       int32(olderLimit + 1),
+	})
+	if err != nil {
+		return messageHistoryResponse{}, err
+	}
+
+	newer, err := a.storage.Messages.GetAfter(ctx, queries.GetMessagesAfterParams{
+		ConversationID: conversationID,
+		CreatedAt:      cursor.CreatedAt,
+		ID:             cursor.ID,
+		ViewerID:       viewerID,
+		PageSize:       int32(newerLimit + 1),
+	})
+	if err != nil {
+		return messageHistoryResponse{}, err
+	}
+
+	hasOlder := len(older) > olderLimit
+	if hasOlder {
+		older = older[:olderLimit]
+	}
+	slices.Reverse(older)
+
+	hasNewer := len(newer) > newerLimit
+	if hasNewer {
+		newer = newer[:newerLimit]
+	}
+
+	msgs := make([]queries.Message, 0, len(older)+1+len(newer))
+	msgs = append(msgs, older...)
+	msgs = append(msgs, target)
+	msgs = append(msgs, newer...)
+
+	resp := newHistoryResponse(msgs, hasOlder, hasNewer)
+	resp.NextCursor = resp.OlderCursor
+	return resp, nil
+}
+
+func newHistoryResponse(msgs []queries.Message, hasOlder, hasNewer bool) messageHistoryResponse {
+	resp := messageHistoryResponse{
+		Messages: make([]messageResponse, len(msgs)),
+	}
+
+	for i, m := range msgs {
+		resp.Messages[i] = messageResponse{Message: m}
+	}
+
+	if len(msgs) == 0 {
+		return resp
+	}
+
+	if hasOlder {
+		resp.OlderCursor = cursorOf(msgs[0]).String()
+	}
+
+	if hasNewer {
+		resp.NewerCursor = cursorOf(msgs[len(msgs)-1]).String()
+	}
+
+	return resp
+}
+
+// decorateMessages fills in everything a history page shows next to the messages themselves
+func (a *api) decorateMessages(ctx context.Context, viewerID uuid.UUID, msgs []messageResponse) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	if err := a.attachQuotes(ctx, msgs); err != nil {
+		return err
+	}
+
+	if err := a.attachReactions(ctx, viewerID, msgs); err != nil {
+		return err
+	}
+
+	if err := a.attachStatuses(ctx, msgs); err != nil {
+		return err
+	}
+
+	return a.attachAttachments(ctx, msgs)
+}
+
+func (a *api) attachQuotes(ctx context.Context, msgs []messageResponse) error {
+	var replyToIDs []uuid.UUID
+	for _, m := range msgs {
+		if m.ReplyToID.Valid {
+			replyToIDs = append(replyToIDs, m.ReplyToID.Bytes)
+		}
+	}
+
+	if len(replyToIDs) == 0 {
+		return nil
+	}
+
+	quotedRows, err := a.storage.Messages.GetQuoted(ctx, replyToIDs)
+	if err != nil {
+		return err
+	}
+
+	quoted := make(map[uuid.UUID]*QuotedMsg, len(quotedRows))
+	for _, q := range quotedRows {
+		quoted[q.ID] = newQuotedMsg(q)
+	}
+
+	for i, m := range msgs {
+		if m.ReplyToID.Valid {
+			msgs[i].Quoted = quoted[m.ReplyToID.Bytes]
+		}
+	}
+
+	return nil
+}
+
+func (a *api) attachReactions(ctx context.Context, viewerID uuid.UUID, msgs []messageResponse) error {
+	ids := make([]uuid.UUID, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+
+	summaries, err := a.storage.Reactions.GetSummaries(ctx, queries.GetReactionSummariesParams{
+		ViewerID:   viewerID,
+		MessageIds: ids,
+	})
+	if err != nil {
+		return err
+	}
+
+	reactions := make(map[uuid.UUID][]reactionSummary)
+	for _, r := range summaries {
+		reactions[r.MessageID] = append(reactions[r.MessageID], reactionSummary{
+			Emoji:       r.Emoji,
+			Count:       r.Count,
+			ReactedByMe: r.ReactedByMe,
+		})
+	}
+
+	for i, m := range msgs {
+		if r, ok := reactions[m.ID]; ok {
+			msgs[i].Reactions = r
+		} else {
+			msgs[i].Reactions = []reactionSummary{}
+		}
+	}
+
+	return nil
+}
+
+// attachStatuses works out the stage of every message of the page, they all belong to the same conversation
+func (a *api) attachStatuses(ctx context.Context, msgs []messageResponse) error {
+	memberIDs, err := a.storage.Conversations.GetMemberIDs(ctx, msgs[0].ConversationID)
+	if err != nil {
+		return err
+	}
+	recipients := int64(len(memberIDs) - 1)
+
+	ids := make([]uuid.UUID, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+
+	rows, err := a.storage.Receipts.GetCounts(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[uuid.UUID]queries.GetReceiptCountsRow, len(rows))
+	for _, r := range rows {
+		counts[r.MessageID] = r
+	}
+
+	for i, m := range msgs {
+		c := counts[m.ID]
+		msgs[i].Status = messageStage(recipients, c.DeliveredCount, c.ReadCount)
+	}
+
+	return nil
+}
+
+// messageStage tells how far a message got from how many of its recipients received and read it
+func messageStage(recipients, delivered, read int64) string {
+	switch {
+	case recipients > 0 && read >= recipients:
+		return messageRead
+	case recipients > 0 && delivered >= recipients:
+		return messageDelivered
+	default:
+		return messageSent
+	}
+}
+
+func (a *api) attachAttachments(ctx context.Context, msgs []messageResponse) error {
+	ids := make([]uuid.UUID, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+
+	rows, err := a.storage.Attachments.GetByMessageIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	attachments := make(map[uuid.UUID][]attachmentResponse)
+	for _, r := range rows {
+		attachments[r.MessageID.Bytes] = append(attachments[r.MessageID.Bytes], a.newAttachmentResponse(r))
+	}
+
+	for i, m := range msgs {
+		if r, ok := attachments[m.ID]; ok {
+			msgs[i].Attachments = r
+		} else {
+			msgs[i].Attachments = []attachmentResponse{}
+		}
+	}
+
+	return nil
+}
+
+// resolveHistoryConversation finds the conversation either by its id (groups)
+// or by the other member of a direct conversation
+func (a *api) resolveHistoryConversation(c echo.Context, user queries.User) (uuid.UUID, error) {
+	if id := c.QueryParam("conversation_id"); id != "" {
+		conversationID, err := uuid.Parse(id)
+		if err != nil {
+			a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+			return uuid.UUID{}, echo.NewHTTPError(http.StatusBadRequest, err)
+		}
+
+		isMember, err := a.storage.Conversations.IsMember(c.Request().Context(), conversationID, user.ID)
+		if err != nil {
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return uuid.UUID{}, echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		if !isMember {
+			a.notFoundLog(c.Request().Method, c.Path(), store.ErrNotFound)
+			return uuid.UUID{}, echo.NewHTTPError(http.StatusNotFound, store.ErrNotFound.Error())
+		}
+
+		return conversationID, nil
+	}
+
+	id := c.QueryParam("with_id")
+	validUUID, err := uuid.Parse(id)
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return uuid.UUID{}, echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	conversation, err := a.storage.Conversations.GetByMembers(c.Request().Context(), queries.GetConversationByMembersParams{
+		User1: user.ID,
+		User2: validUUID,
 	})
 
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
 			a.notFoundLog(c.Request().Method, c.Path(), err)
-			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+			return uuid.UUID{}, echo.NewHTTPError(http.StatusNotFound, err.Error())
 		default:
 			a.internalErrLog(c.Request().Method, c.Path(), err)
-			return echo.NewHTTPError(http.StatusInternalServerError)
+			return uuid.UUID{}, echo.NewHTTPError(http.StatusInternalServerError)
 		}
 	}
 
-	return c.JSON(http.StatusOK, msgs)
+	return conversation.ID, nil
 }
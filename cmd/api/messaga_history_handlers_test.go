@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMessageCursorRoundTrip(t *testing.T) {
+	id := uuid.MustParse("0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90")
+
+	tests := []struct {
+		name   string
+		cursor messageCursor
+	}{
+		{name: "now", cursor: messageCursor{CreatedAt: time.Now().Truncate(time.Microsecond), ID: id}},
+		{name: "before the epoch", cursor: messageCursor{CreatedAt: time.UnixMicro(-1_234_567), ID: id}},
+		{name: "zero id", cursor: messageCursor{CreatedAt: time.UnixMicro(1_700_000_000_000_000)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeMessageCursor(tt.cursor.String())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.CreatedAt.Equal(tt.cursor.CreatedAt) || got.ID != tt.cursor.ID {
+				t.Errorf("got %+v, want %+v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeMessageCursorMalformed(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "empty", cursor: ""},
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "padded base64", cursor: base64.URLEncoding.EncodeToString([]byte("1:0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90"))},
+		{name: "no separator", cursor: encode("1700000000000000")},
+		{name: "time isn't a number", cursor: encode("yesterday:0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90")},
+		{name: "bad id", cursor: encode("1700000000000000:not-a-uuid")},
+		{name: "extra part", cursor: encode("1700000000000000:0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90:1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeMessageCursor(tt.cursor); err != errInvalidCursor {
+				t.Errorf("got error %v, want %v", err, errInvalidCursor)
+			}
+		})
+	}
+}
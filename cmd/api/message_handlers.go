@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+)
+
+type messageConfig struct {
+	// how long after sending a message the sender can still edit it
+	editWindow time.Duration
+	// who decides when a direct conversation is deleted for both members, see config.DeleteForBothConsent
+	deleteForBoth string
+}
+
+var (
+	errNotMessageSender  = errors.New("only the sender can change this message")
+	errEditWindowExpired = errors.New("the message can no longer be edited")
+	errEmptyContent      = errors.New("content can't be empty")
+	errMessageDeleted    = errors.New("the message has been deleted")
+	errInvalidScope      = errors.New("scope must be either me or everyone")
+	errInvalidEmoji      = errors.New("invalid emoji")
+)
+
+// maxEmojiRunes leaves room for skin tones and ZWJ sequences
+const maxEmojiRunes = 8
+
+type editMessagePayload struct {
+	Content string `json:"content" validate:"required"`
+}
+
+// messageErrStatus maps the errors of message actions to http status codes,
+// they are shared by the REST handlers and the websocket events
+func messageErrStatus(err error) int {
+	switch err {
+	case store.ErrNotFound:
+		return http.StatusNotFound
+	case errNotMessageSender, errEditWindowExpired:
+		return http.StatusForbidden
+	case errMessageDeleted:
+		return http.StatusConflict
+	case errEmptyContent, errInvalidScope, errInvalidEmoji:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (a *api) messageActionErr(c echo.Context, err error) error {
+	status := messageErrStatus(err)
+	switch status {
+	case http.StatusInternalServerError:
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(status)
+	case http.StatusNotFound:
+		a.notFoundLog(c.Request().Method, c.Path(), err)
+	default:
+		a.badRequestLog(c.Request().Method, c.Path(), err)
+	}
+	return echo.NewHTTPError(status, err.Error())
+}
+
+// editMessage edits a message on behalf of userID and lets every member of the conversation know
+func (a *api) editMessage(ctx context.Context, userID, messageID uuid.UUID, content string) (queries.Message, error) {
+	if content == "" {
+		return queries.Message{}, errEmptyContent
+	}
+
+	msg, err := a.storage.Messages.GetByID(ctx, messageID)
+	if err != nil {
+		return queries.Message{}, err
+	}
+
+	if msg.SenderID != userID {
+		return queries.Message{}, errNotMessageSender
+	}
+
+	if msg.DeletedAt.Valid {
+		return queries.Message{}, errMessageDeleted
+	}
+
+	if time.Since(msg.CreatedAt.Time) > a.messageConfig.editWindow {
+		return queries.Message{}, errEditWindowExpired
+	}
+
+	edited, err := a.storage.Messages.Edit(ctx, queries.EditMessageParams{
+		ID:              messageID,
+		SenderID:        userID,
+		Content:         content,
+		EditWindowStart: time.Now().Add(-a.messageConfig.editWindow),
+	})
+	if err != nil {
+		return queries.Message{}, err
+	}
+
+	// the sender's devices get it too
+	a.publishToMembers(ctx, edited.ConversationID, uuid.Nil, nil, Wrapper{
+		MsgType: MSG_EDITED,
+		Message: &MsgEdited{
+			ID:             edited.ID,
+			ConversationID: edited.ConversationID,
+			Content:        edited.Content,
+			EditedAt:       edited.EditedAt.Time,
+		},
+	})
+
+	return edited, nil
+}
+
+// deleteMessage deletes a message either for everyone, which only the sender can do,
+// or just from userID's own history
+func (a *api) deleteMessage(ctx context.Context, userID, messageID uuid.UUID, forEveryone bool) error {
+	msg, err := a.storage.Messages.GetVisibleByID(ctx, queries.GetVisibleMessageByIDParams{
+		ID:       messageID,
+		ViewerID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	isMember, err := a.storage.Conversations.IsMember(ctx, msg.ConversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	if !isMember {
+		return store.ErrNotFound
+	}
+
+	if !forEveryone {
+		if err := a.storage.Messages.DeleteForUser(ctx, queries.HideMessageParams{
+			MessageID: messageID,
+			UserID:    userID,
+		}); err != nil {
+			return err
+		}
+
+		// only the user's own devices care
+		a.publish(ctx, []uuid.UUID{userID}, nil, Wrapper{
+			MsgType: MSG_DELETED,
+			Message: &MsgDeleted{
+				ID:             msg.ID,
+				ConversationID: msg.ConversationID,
+				DeletedAt:      time.Now(),
+			},
+		})
+		return nil
+	}
+
+	if msg.SenderID != userID {
+		return errNotMessageSender
+	}
+
+	if msg.DeletedAt.Valid {
+		return errMessageDeleted
+	}
+
+	deleted, err := a.storage.Messages.DeleteForEveryone(ctx, queries.DeleteMessageForEveryoneParams{
+		ID:       messageID,
+		SenderID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	a.publishToMembers(ctx, deleted.ConversationID, uuid.Nil, nil, Wrapper{
+		MsgType: MSG_DELETED,
+		Message: &MsgDeleted{
+			ID:             deleted.ID,
+			ConversationID: deleted.ConversationID,
+			ForEveryone:    true,
+			DeletedAt:      deleted.DeletedAt.Time,
+		},
+	})
+
+	// the message is already gone for everyone, leftover files are only logged
+	keys, err := a.storage.Attachments.DeleteByMessageID(ctx, deleted.ID)
+	if err != nil {
+		a.logger.Errorw("couldn't delete attachments", "message_id", deleted.ID, "error", err.Error())
+		return nil
+	}
+	if len(keys) > 0 {
+		go a.deleteBlobs(keys...)
+	}
+	return nil
+}
+
+// reactToMessage adds or takes back userID's reaction and lets the conversation know the new count
+func (a *api) reactToMessage(ctx context.Context, userID, messageID uuid.UUID, emoji string, reacted bool) error {
+	if emoji == "" || len(emoji) > 32 || utf8.RuneCountInString(emoji) > maxEmojiRunes {
+		return errInvalidEmoji
+	}
+
+	msg, err := a.storage.Messages.GetByID(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	isMember, err := a.storage.Conversations.IsMember(ctx, msg.ConversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	if !isMember {
+		return store.ErrNotFound
+	}
+
+	if msg.DeletedAt.Valid {
+		return errMessageDeleted
+	}
+
+	if reacted {
+		err = a.storage.Reactions.Add(ctx, queries.AddReactionParams{
+			MessageID: messageID,
+			UserID:    userID,
+			Emoji:     emoji,
+		})
+	} else {
+		err = a.storage.Reactions.Remove(ctx, queries.RemoveReactionParams{
+			MessageID: messageID,
+			UserID:    userID,
+			Emoji:     emoji,
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	count, err := a.storage.Reactions.Count(ctx, queries.CountReactionsParams{
+		MessageID: messageID,
+		Emoji:     emoji,
+	})
+	if err != nil {
+		return err
+	}
+
+	a.notifyMembers(ctx, msg.ConversationID, uuid.Nil, Wrapper{
+		MsgType: REACTION_UPDATED,
+		Message: &ReactionUpdated{
+			MessageID:      messageID,
+			ConversationID: msg.ConversationID,
+			Emoji:          emoji,
+			Count:          count,
+			UserID:         userID,
+			Reacted:        reacted,
+		},
+	})
+	return nil
+}
+
+func (a *api) editMessageHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	messageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+	}
+
+	var payload editMessagePayload
+	if err := c.Bind(&payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := a.validator.Struct(payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	msg, err := a.editMessage(c.Request().Context(), user.ID, messageID, payload.Content)
+	if err != nil {
+		return a.messageActionErr(c, err)
+	}
+
+	return c.JSON(http.StatusOK, msg)
+}
+
+// deleteMessageHandler deletes for the caller only unless ?scope=everyone
+func (a *api) deleteMessageHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	messageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+	}
+
+	var forEveryone bool
+	switch c.QueryParam("scope") {
+	case "", "me":
+	case "everyone":
+		forEveryone = true
+	default:
+		return a.messageActionErr(c, errInvalidScope)
+	}
+
+	if err := a.deleteMessage(c.Request().Context(), user.ID, messageID, forEveryone); err != nil {
+		return a.messageActionErr(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *api) getMessageEditsHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	messageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+	}
+
+	msg, err := a.storage.Messages.GetByID(c.Request().Context(), messageID)
+	if err != nil {
+		return a.messageActionErr(c, err)
+	}
+
+	isMember, err := a.storage.Conversations.IsMember(c.Request().Context(), msg.ConversationID, user.ID)
+	if err != nil {
+		return a.messageActionErr(c, err)
+	}
+
+	if !isMember {
+		return a.messageActionErr(c, store.ErrNotFound)
+	}
+
+	edits, err := a.storage.Messages.GetEdits(c.Request().Context(), messageID)
+	if err != nil {
+		return a.messageActionErr(c, err)
+	}
+
+	return c.JSON(http.StatusOK, edits)
+}
+
+// getMessageReceiptsHandler lists who the message was delivered to and who read it,
+// like the ticks it's only there for the sender
+func (a *api) getMessageReceiptsHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	messageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+	}
+
+	msg, err := a.storage.Messages.GetByID(c.Request().Context(), messageID)
+	if err != nil {
+		return a.messageActionErr(c, err)
+	}
+
+	if msg.SenderID != user.ID {
+		return a.messageActionErr(c, errNotMessageSender)
+	}
+
+	receipts, err := a.storage.Receipts.GetByMessageID(c.Request().Context(), messageID)
+	if err != nil {
+		return a.messageActionErr(c, err)
+	}
+
+	return c.JSON(http.StatusOK, receipts)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+)
+
+var (
+	errUserBlocked     = errors.New("you can't message this user")
+	errContactsOnly    = errors.New("this user only accepts messages from their contacts")
+	errRequestDeclined = errors.New("this user declined your message request, try again later")
+	// Groups have no invites, someone only reachable through a message request can't be added
+	errGroupNeedsRequest = errors.New("this user only accepts message requests, start a conversation with them first")
+)
+
+// messageRequestCooldown is how long a requester waits before asking someone who declined again
+const messageRequestCooldown = 30 * 24 * time.Hour
+
+// canStartConversation checks the user may start a direct conversation with the peer,
+// it tells whether the conversation has to go through a message request first
+func (a *api) canStartConversation(ctx context.Context, userID, peerID uuid.UUID) (bool, error) {
+	peer, err := a.storage.Users.GetByID(ctx, peerID)
+	if err != nil {
+		return false, err
+	}
+
+	blocked, err := a.storage.Blocks.IsBlockedBetween(ctx, userID, peerID)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, errUserBlocked
+	}
+
+	if peer.MessagePrivacy == messagePrivacyEveryone {
+		return false, nil
+	}
+
+	// the peer's contacts count, not the other way around
+	isContact, err := a.storage.Contacts.Exists(ctx, queries.IsContactParams{
+		UserID:        peerID,
+		ContactUserID: userID,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case isContact:
+		return false, nil
+	case peer.MessagePrivacy == messagePrivacyContacts:
+		return false, errContactsOnly
+	}
+
+	declined, err := a.storage.Conversations.IsRequestDeclined(ctx, queries.IsMessageRequestDeclinedParams{
+		RequesterID:   userID,
+		RecipientID:   peerID,
+		DeclinedAfter: time.Now().Add(-messageRequestCooldown),
+	})
+	if err != nil {
+		return false, err
+	}
+	if declined {
+		return false, errRequestDeclined
+	}
+
+	return true, nil
+}
+
+func (a *api) getMessageRequestsHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+
+	requestsDB, err := a.storage.Conversations.GetRequests(c.Request().Context(), user.ID)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	senderIDs := make([]uuid.UUID, len(requestsDB))
+	for i, r := range requestsDB {
+		senderIDs[i] = r.ID
+	}
+	online := a.onlineUsers(c.Request().Context(), senderIDs)
+
+	requests := make([]MessageRequest, len(requestsDB))
+	for i, r := range requestsDB {
+		requests[i] = MessageRequest{
+			GetMessageRequestsRow: r,
+			IsOnline:              online[r.ID],
+		}
+	}
+
+	return c.JSON(http.StatusOK, requests)
+}
+
+func (a *api) acceptMessageRequestHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid conversation id")
+	}
+
+	conversation, err := a.storage.Conversations.AcceptRequest(c.Request().Context(), queries.AcceptMessageRequestParams{
+		ID:     conversationID,
+		UserID: user.ID,
+	})
+
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	go a.notifyRequestAccepted(conversation.User1.Bytes, &MessageRequestAccepted{
+		ConversationID: conversation.ID,
+		UserID:         user.ID,
+		AcceptedAt:     time.Now(),
+	})
+
+	return c.JSON(http.StatusOK, conversation)
+}
+
+// declineMessageRequestHandler deletes the request and its messages, the sender isn't told
+// about it but can't send another one for a while
+func (a *api) declineMessageRequestHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid conversation id")
+	}
+
+	_, keys, err := a.storage.Conversations.DeclineRequest(c.Request().Context(), queries.DeclineMessageRequestParams{
+		ID:     conversationID,
+		UserID: user.ID,
+	})
+
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	if len(keys) > 0 {
+		go a.deleteBlobs(keys...)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *api) notifyMessageRequest(recipientID uuid.UUID, request *MessageRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a.publish(ctx, []uuid.UUID{recipientID}, nil, Wrapper{
+		MsgType: CONVO_REQUEST,
+		Message: request,
+	})
+}
+
+func (a *api) notifyRequestAccepted(senderID uuid.UUID, accepted *MessageRequestAccepted) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a.publish(ctx, []uuid.UUID{senderID}, nil, Wrapper{
+		MsgType: REQUEST_ACCEPTED,
+		Message: accepted,
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
 )
 
 const (
@@ -20,6 +21,29 @@ const (
 	MARK_READ         = "MARK_READ"
 	MSG_READ          = "MSG_READ"
 	CLIENT_CONN       = "CLIENT_CONN"
+	EDIT_MSG          = "EDIT_MSG"
+	MSG_EDITED        = "MSG_EDITED"
+	DELETE_MSG        = "DELETE_MSG"
+	MSG_DELETED       = "MSG_DELETED"
+	REACT             = "REACT"
+	UNREACT           = "UNREACT"
+	REACTION_UPDATED  = "REACTION_UPDATED"
+	SYNC              = "SYNC"
+	SYNC_EVENTS       = "SYNC_EVENTS"
+	SYNC_DONE         = "SYNC_DONE"
+	ACK_RECEIVED      = "ACK_RECEIVED"
+	MSG_DELIVERED     = "MSG_DELIVERED"
+	CONTACT_ADDED     = "CONTACT_ADDED"
+	SERVER_SHUTDOWN   = "SERVER_SHUTDOWN"
+	CONVO_REQUEST     = "CONVO_REQUEST"
+	REQUEST_ACCEPTED  = "REQUEST_ACCEPTED"
+	CONVO_UPDATED     = "CONVO_UPDATED"
+	CONVO_CLEARED     = "CONVO_CLEARED"
+	CONVO_DELETED     = "CONVO_DELETED"
+	// CONVO_DELETE_REQUESTED and CONVO_DELETE_CANCELED are about deleting a direct conversation for both members
+	CONVO_DELETE_REQUESTED = "CONVO_DELETE_REQUESTED"
+	CONVO_DELETE_CANCELED  = "CONVO_DELETE_CANCELED"
+	MEMBER_LEFT            = "MEMBER_LEFT"
 
 	MESSAGE_ERR = "MESSAGE_ERR"
 )
@@ -36,6 +60,11 @@ type Message interface {
 type Wrapper struct {
 	MsgType string  `json:"type"`
 	Message Message `json:"message"`
+	// Position of the event in the recipient's event log, only set on events
+	// that are replayed to devices that were offline (see SYNC)
+	Seq int64 `json:"seq,omitempty"`
+	// Set for recipients who muted the conversation, their devices shouldn't notify about it
+	Muted bool `json:"muted,omitempty"`
 }
 
 type InitialServerMsg struct {
@@ -46,18 +75,62 @@ type InitialServerMsg struct {
 func (m *InitialServerMsg) message() {}
 
 type ChatMsg struct {
-	// User ids
+	// User ids, From is always set by the server to the authenticated sender
 	To   string `json:"to"`
 	From string `json:"from"`
+	// Set instead of To when sending to a group,
+	// always set by the server on outgoing messages
+	ConversationID string `json:"conversation_id"`
 
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"created_at"`
 	TempID    string    `json:"temp_id"`
 	ID        uuid.UUID `json:"id"`
+
+	// Optional id of the message being replied to,
+	// the server fills in Quoted on outgoing messages
+	ReplyTo string     `json:"reply_to,omitempty"`
+	Quoted  *QuotedMsg `json:"quoted,omitempty"`
+
+	// Ids of files uploaded beforehand to /authenticated/attachments,
+	// the server fills in Attachments on outgoing messages
+	AttachmentIDs []string             `json:"attachment_ids,omitempty"`
+	Attachments   []attachmentResponse `json:"attachments,omitempty"`
+
+	// Set by the server while the conversation is a message request the recipient
+	// hasn't accepted, it belongs with the requests rather than the conversations
+	RequestPending bool `json:"request_pending,omitempty"`
 }
 
 func (m *ChatMsg) message() {}
 
+// maxQuotedLength is how many characters of the replied message are kept in the snapshot
+const maxQuotedLength = 100
+
+// QuotedMsg is a compact snapshot of the message a reply points to
+type QuotedMsg struct {
+	ID             uuid.UUID `json:"id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	SenderUsername string    `json:"sender_username"`
+	Content        string    `json:"content"`
+	IsDeleted      bool      `json:"is_deleted"`
+}
+
+func newQuotedMsg(row queries.GetQuotedMessagesRow) *QuotedMsg {
+	content := row.Content
+	if runes := []rune(content); len(runes) > maxQuotedLength {
+		content = string(runes[:maxQuotedLength]) + "…"
+	}
+
+	return &QuotedMsg{
+		ID:             row.ID,
+		SenderID:       row.SenderID,
+		SenderUsername: row.SenderUsername,
+		Content:        content,
+		IsDeleted:      row.DeletedAt.Valid,
+	}
+}
+
 type ClientDisconnected struct {
 	Name string `json:"name"`
 }
@@ -77,7 +150,12 @@ type Err struct {
 
 func (m *Err) message() {}
 
-type Welcome struct{}
+type Welcome struct {
+	DeviceID string `json:"device_id"`
+	// Sequence number of the user's newest event, a device without any
+	// local state can start syncing from here once it has fetched history
+	LastSeq int64 `json:"last_seq"`
+}
 
 func (m *Welcome) message() {}
 
@@ -95,39 +173,69 @@ type OfflineStatus struct {
 
 func (m *OfflineStatus) message() {}
 
+// AcknowledgementMsgDelivered tells the sender the message has been stored,
+// the "sent" stage. MSG_DELIVERED and MSG_READ follow per recipient.
 type AcknowledgementMsgDelivered struct {
-	RecieverID string    `json:"reciever_id"`
-	TempID     string    `json:"temp_id"`
-	CreatedAt  time.Time `json:"created_at"`
-	ID         string    `json:"id"`
+	RecieverID     string    `json:"reciever_id"`
+	ConversationID string    `json:"conversation_id"`
+	TempID         string    `json:"temp_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ID             string    `json:"id"`
 }
 
 func (m *AcknowledgementMsgDelivered) message() {}
 
+// Every message of the conversation the reader didn't send gets marked as read
 type MarkMsgRead struct {
 	ConversationID string `json:"conversation_id"`
-	MsgOwnerID     string `json:"msg_owner_id"`
+	// Ignored, older clients still send it
+	MsgOwnerID string `json:"msg_owner_id,omitempty"`
 }
 
 func (m *MarkMsgRead) message() {}
 
+// MsgRead goes to the sender of the messages
 type MsgRead struct {
 	ConversationID string      `json:"conversation_id"`
 	MessageIDs     []uuid.UUID `json:"message_ids"`
+	ReaderID       uuid.UUID   `json:"reader_id"`
+	ReadAt         time.Time   `json:"read_at"`
 }
 
 func (m *MsgRead) message() {}
 
+// Sent by the client once messages reached the device, whether live or replayed
+type AckReceived struct {
+	MessageIDs []string `json:"message_ids"`
+}
+
+func (m *AckReceived) message() {}
+
+// MsgDelivered goes to the sender of the messages,
+// the first time they reach one of the recipient's devices
+type MsgDelivered struct {
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	MessageIDs     []uuid.UUID `json:"message_ids"`
+	RecipientID    uuid.UUID   `json:"recipient_id"`
+	DeliveredAt    time.Time   `json:"delivered_at"`
+}
+
+func (m *MsgDelivered) message() {}
+
 type Typing struct {
-	To   string `json:"to"`
-	From string `json:"from"`
+	// From is set by the server to the authenticated sender
+	To             string `json:"to"`
+	From           string `json:"from"`
+	ConversationID string `json:"conversation_id,omitempty"`
 }
 
 func (m *Typing) message() {}
 
 type StoppedTyping struct {
-	To   string `json:"to"`
-	From string `json:"from"`
+	// From is set by the server to the authenticated sender
+	To             string `json:"to"`
+	From           string `json:"from"`
+	ConversationID string `json:"conversation_id,omitempty"`
 }
 
 func (m *StoppedTyping) message() {}
@@ -138,3 +246,206 @@ type MessageErr struct {
 }
 
 func (m *MessageErr) message() {}
+
+type EditMsg struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+func (m *EditMsg) message() {}
+
+type MsgEdited struct {
+	ID             uuid.UUID `json:"id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	Content        string    `json:"content"`
+	EditedAt       time.Time `json:"edited_at"`
+}
+
+func (m *MsgEdited) message() {}
+
+type DeleteMsg struct {
+	ID string `json:"id"`
+	// Deletes the message only from the sender's own history when false
+	ForEveryone bool `json:"for_everyone"`
+}
+
+func (m *DeleteMsg) message() {}
+
+type MsgDeleted struct {
+	ID             uuid.UUID `json:"id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	ForEveryone    bool      `json:"for_everyone"`
+	DeletedAt      time.Time `json:"deleted_at"`
+}
+
+func (m *MsgDeleted) message() {}
+
+// React is the payload of both REACT and UNREACT
+type React struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+func (m *React) message() {}
+
+type ReactionUpdated struct {
+	MessageID      uuid.UUID `json:"message_id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	Emoji          string    `json:"emoji"`
+	// How many users reacted with this emoji after the update
+	Count int64 `json:"count"`
+	// Who reacted or took their reaction back
+	UserID  uuid.UUID `json:"user_id"`
+	Reacted bool      `json:"reacted"`
+}
+
+func (m *ReactionUpdated) message() {}
+
+// Sent by the client after WELCOME with the sequence number of the last event it has seen.
+// Missed events come back in SYNC_EVENTS frames, followed by SYNC_DONE, live events
+// are held back until then. An event may show up both live and replayed, clients skip
+// the sequence numbers they have already seen.
+type Sync struct {
+	// Leave out when the device has no local state
+	LastSeq *int64 `json:"last_seq"`
+}
+
+func (m *Sync) message() {}
+
+// SyncEvents is a page of replayed events, each in the same shape as when it was delivered live
+type SyncEvents struct {
+	Events []Wrapper `json:"events"`
+}
+
+func (m *SyncEvents) message() {}
+
+type SyncDone struct {
+	// The device is up to date with this sequence number
+	LastSeq  int64 `json:"last_seq"`
+	Replayed int   `json:"replayed"`
+	// Set when the missed events are no longer available,
+	// the client has to refetch its conversations and history
+	Reset bool `json:"reset"`
+}
+
+func (m *SyncDone) message() {}
+
+// replayedMessage is the payload of an event that is JSON already,
+// as it was stored in the event log or relayed by the broker
+type replayedMessage json.RawMessage
+
+func (m replayedMessage) MarshalJSON() ([]byte, error) {
+	return m, nil
+}
+
+func (m replayedMessage) message() {}
+
+// ContactAdded tells a user someone added them to their contacts
+type ContactAdded struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+func (m *ContactAdded) message() {}
+
+// ServerShutdown tells the client the node is going away, it should reconnect
+// after RetryAfterMs plus a random part of RetryJitterMs, another node picks it up
+type ServerShutdown struct {
+	Reason        string `json:"reason"`
+	RetryAfterMs  int64  `json:"retry_after_ms"`
+	RetryJitterMs int64  `json:"retry_jitter_ms"`
+}
+
+func (m *ServerShutdown) message() {}
+
+// MessageRequest goes to the recipient of a conversation a stranger started,
+// it shows up among their conversations once they accept it
+type MessageRequest struct {
+	queries.GetMessageRequestsRow
+	IsOnline bool `json:"is_online"`
+}
+
+func (m *MessageRequest) message() {}
+
+// MessageRequestAccepted goes to the user who sent the request
+type MessageRequestAccepted struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	// Who accepted it
+	UserID     uuid.UUID `json:"user_id"`
+	AcceptedAt time.Time `json:"accepted_at"`
+}
+
+func (m *MessageRequestAccepted) message() {}
+
+// LastMessage is what the conversation list shows of the newest message
+type LastMessage struct {
+	ID       uuid.UUID `json:"id"`
+	SenderID uuid.UUID `json:"sender_id"`
+	// The start of the content, empty for deleted messages and ones with only attachments
+	Preview         string    `json:"preview"`
+	AttachmentCount int64     `json:"attachment_count"`
+	Deleted         bool      `json:"deleted"`
+	Status          string    `json:"status"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ConvoUpdated goes to every member when there is a new message,
+// the conversation moves to the top of their list
+type ConvoUpdated struct {
+	ConversationID uuid.UUID    `json:"conversation_id"`
+	LastActivityAt time.Time    `json:"last_activity_at"`
+	LastMessage    *LastMessage `json:"last_message"`
+	// The conversation is a message request the recipient hasn't accepted,
+	// it doesn't go in their list of conversations
+	RequestPending bool `json:"request_pending,omitempty"`
+}
+
+func (m *ConvoUpdated) message() {}
+
+// ConvoCleared goes to the user's own devices, they drop the messages up to ClearedAt
+type ConvoCleared struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	ClearedAt      time.Time `json:"cleared_at"`
+}
+
+func (m *ConvoCleared) message() {}
+
+// ConvoDeleted goes to the user's own devices when they deleted or left the conversation,
+// and to both members when it was deleted for both
+type ConvoDeleted struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	// Who deleted it
+	UserID      uuid.UUID `json:"user_id"`
+	ForEveryone bool      `json:"for_everyone"`
+	DeletedAt   time.Time `json:"deleted_at"`
+}
+
+func (m *ConvoDeleted) message() {}
+
+// ConvoDeleteRequested goes to both members, the other one agrees by deleting it for both too
+type ConvoDeleteRequested struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	RequestedBy    uuid.UUID `json:"requested_by"`
+	RequestedAt    time.Time `json:"requested_at"`
+}
+
+func (m *ConvoDeleteRequested) message() {}
+
+// ConvoDeleteCanceled goes to both members when the request was withdrawn or declined
+type ConvoDeleteCanceled struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	// Who canceled it
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (m *ConvoDeleteCanceled) message() {}
+
+// MemberLeft goes to the members left in the group
+type MemberLeft struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	LeftAt         time.Time `json:"left_at"`
+}
+
+func (m *MemberLeft) message() {}
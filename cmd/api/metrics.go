@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// incomingEventTypes are the event types clients may send, anything else is
+// counted as unknown so clients can't blow up the number of series
+var incomingEventTypes = map[string]bool{
+	CHAT:           true,
+	MARK_READ:      true,
+	TYPING:         true,
+	STOPPED_TYPING: true,
+	EDIT_MSG:       true,
+	DELETE_MSG:     true,
+	REACT:          true,
+	UNREACT:        true,
+	SYNC:           true,
+	ACK_RECEIVED:   true,
+}
+
+func (a *api) registerMetrics() {
+	metrics.RegisterGaugeFunc("websocket", "connections", "Open websocket connections, authenticated or not.", func() float64 {
+		return float64(a.mel.Len())
+	})
+	metrics.RegisterGaugeFunc("websocket", "sessions", "Authenticated websocket sessions, one per connected device.", func() float64 {
+		_, sessions := a.clients.counts()
+		return float64(sessions)
+	})
+	metrics.RegisterGaugeFunc("websocket", "users", "Users with at least one device connected to this node.", func() float64 {
+		users, _ := a.clients.counts()
+		return float64(users)
+	})
+	metrics.RegisterPool(a.db)
+}
+
+// metricsMiddleware times every request by its route pattern, not the raw path,
+// so ids in the URL don't turn into series of their own
+func metricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			} else {
+				status = http.StatusInternalServerError
+			}
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		metrics.HTTPRequestDuration.
+			WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func countIncomingEvent(msgType string) {
+	if !incomingEventTypes[msgType] {
+		msgType = "unknown"
+	}
+	metrics.WebSocketEvents.WithLabelValues(msgType).Inc()
+}
+
+// metricsHandler serves the Prometheus metrics, behind the same token as /debug/status
+func (a *api) metricsHandler(c echo.Context) error {
+	if !a.debugAllowed(c) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+
+	promhttp.Handler().ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -48,7 +48,17 @@ func (app *api) AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusUnauthorized, "user not found")
 		}
 
+		sessionID, err := app.tokenSessionID(c.Request().Context(), claims, user.ID)
+		if err != nil {
+			if err == errSessionRevoked {
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+			app.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
 		c.Set(userCtxValKey, user)
+		c.Set(sessionIDCtxKey, sessionID)
 
 		return next(c)
 	}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/olahol/melody"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
 )
 
 
@@ -15,74 +14,51 @@ func (a *api) broadCastOfflineStatus(userID uuid.UUID) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	conversationUsers, err := a.storage.Conversations.GetByUserID(ctx, userID)
+	peerIDs, err := a.storage.Conversations.GetPeerIDs(ctx, userID)
 
 	if err != nil {
 		// TODO, HANDEL YOUR ERRORS
 		return
 	}
 
-	for _, c := range conversationUsers {
-		// if the user is there then tell them that a certain user has gone online
-		// very helpful comment LOL
-		sessionAny, ok := a.clients.Load(c.ID.String())
-		if ok {
-			session := sessionAny.(*melody.Session)
-
-			msg := Wrapper{
-				MsgType: OFFLINE_STATUS,
-				Message: &OfflineStatus{
-					UserID: userID.String(),
-					LastSeen: time.Now(),
-				},
-			}
-
-			jsonData, _ := json.Marshal(msg)
-			session.Write(jsonData)
-		}
-	}
+	// if the users are there then tell them that a certain user has gone offline
+	a.deliver(peerIDs, nil, Wrapper{
+		MsgType: OFFLINE_STATUS,
+		Message: &OfflineStatus{
+			UserID:   userID.String(),
+			LastSeen: time.Now(),
+		},
+	}, nil)
 }
 
 
 func (a *api) broadcaseOnlineStatus(userID uuid.UUID) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
-	conversationUsers, err := a.storage.Conversations.GetByUserID(ctx, userID)
+	peerIDs, err := a.storage.Conversations.GetPeerIDs(ctx, userID)
 
 	if err != nil {
 		// TODO do handler your ERRORS
 		return
 	}
 
-	for _, c := range conversationUsers {
-		// if the user is there then tell them that a certain user has gone online
-		// very helpful comment LOL
-		sessionAny, ok := a.clients.Load(c.ID.String())
-		if ok {
-			session := sessionAny.(*melody.Session)
-
-			msg := Wrapper{
-				MsgType: ONLINE_PRESENCE,
-				Message: &OnlinePresence{
-					UserID: userID.String(),
-				},
-			}
-
-			jsonData, _ := json.Marshal(msg)
-			session.Write(jsonData)
-		}
-	}
+	// if the users are there then tell them that a certain user has gone online
+	a.deliver(peerIDs, nil, Wrapper{
+		MsgType: ONLINE_PRESENCE,
+		Message: &OnlinePresence{
+			UserID: userID.String(),
+		},
+	}, nil)
 }
 
 func (a *api) notifyConversationCreation(userID uuid.UUID, conversation conversationResponse){
-	sessionAny, isOnline := a.clients.Load(userID.String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	if !isOnline {
-		return
-	}
-	session := sessionAny.(*melody.Session)
-	writeJSONMsg(
-		session,
+	a.publish(
+		ctx,
+		[]uuid.UUID{userID},
+		nil,
 		Wrapper{
 			MsgType: CONVO_CREATED,
 			Message: &conversation,
@@ -90,4 +66,74 @@ func (a *api) notifyConversationCreation(userID uuid.UUID, conversation conversa
 	)
 }
 
+// notifyMembers writes msg to every online member of the conversation except the excluded user
+func (a *api) notifyMembers(ctx context.Context, conversationID, exclude uuid.UUID, msg Wrapper) {
+	memberIDs, err := a.storage.Conversations.GetMemberIDs(ctx, conversationID)
+	if err != nil {
+		a.logger.Errorw("couldn't load conversation members", "conversation_id", conversationID.String(), "error", err.Error())
+		return
+	}
+
+	recipients := make([]uuid.UUID, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		if memberID != exclude {
+			recipients = append(recipients, memberID)
+		}
+	}
+
+	a.deliverMuted(recipients, nil, msg, nil, a.mutedMembers(ctx, conversationID))
+}
+
+// mutedMembers returns the members who muted the conversation,
+// nobody is muted when they can't be loaded
+func (a *api) mutedMembers(ctx context.Context, conversationID uuid.UUID) []uuid.UUID {
+	muted, err := a.storage.ConversationSettings.GetMutedMemberIDs(ctx, conversationID)
+	if err != nil {
+		a.logger.Errorw("couldn't load muted members", "conversation_id", conversationID.String(), "error", err.Error())
+		return nil
+	}
+	return muted
+}
 
+// notifyUsers publishes msg to the users, for calling in its own goroutine
+func (a *api) notifyUsers(userIDs []uuid.UUID, msg Wrapper) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a.publish(ctx, userIDs, nil, msg)
+}
+
+// sendToUser writes msg to every device the user is connected from
+func (a *api) sendToUser(userID uuid.UUID, msg Wrapper) {
+	a.deliver([]uuid.UUID{userID}, nil, msg, nil)
+}
+
+// lastMessagePreviewLength matches the preview GetLastMessages cuts, in characters
+const lastMessagePreviewLength = 200
+
+// notifyConversationUpdated tells every member's devices, the sender's included,
+// to move the conversation to the top of the list with msg as its last message.
+// For a pending message request it's the recipient's list of requests instead
+func (a *api) notifyConversationUpdated(ctx context.Context, msg queries.Message, attachmentCount int, requestPending bool) {
+	preview := []rune(msg.Content)
+	if len(preview) > lastMessagePreviewLength {
+		preview = preview[:lastMessagePreviewLength]
+	}
+
+	a.notifyMembers(ctx, msg.ConversationID, uuid.Nil, Wrapper{
+		MsgType: CONVO_UPDATED,
+		Message: &ConvoUpdated{
+			ConversationID: msg.ConversationID,
+			LastActivityAt: msg.CreatedAt.Time,
+			LastMessage: &LastMessage{
+				ID:              msg.ID,
+				SenderID:        msg.SenderID,
+				Preview:         string(preview),
+				AttachmentCount: int64(attachmentCount),
+				Status:          messageSent,
+				CreatedAt:       msg.CreatedAt.Time,
+			},
+			RequestPending: requestPending,
+		},
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+const (
+	nodeHeartbeatInterval = 10 * time.Second
+	// a node that missed this many heartbeats is considered gone, along with the presence of its users
+	nodeTimeout = 3 * nodeHeartbeatInterval
+)
+
+// presenceLocks serializes the presence updates of a user on this node, so a quick
+// reconnect can't have its update overtaken by the one of the disconnect before it
+type presenceLocks [64]sync.Mutex
+
+func (l *presenceLocks) lock(userID uuid.UUID) func() {
+	mu := &l[userID[0]%uint8(len(l))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// heartbeat keeps this node in the presence registry and clears out the ones that crashed,
+// until done is canceled
+func (a *api) heartbeat(done context.Context) {
+	ticker := time.NewTicker(nodeHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(done, 5*time.Second)
+		if err := a.storage.Presence.Heartbeat(ctx, a.nodeID); err != nil {
+			a.logger.Errorw("couldn't send heartbeat", "node_id", a.nodeID.String(), "error", err.Error())
+		}
+
+		orphaned, err := a.storage.Presence.PruneNodes(ctx, time.Now().Add(-nodeTimeout))
+		if err != nil {
+			a.logger.Errorw("couldn't prune nodes", "error", err.Error())
+		} else if len(orphaned) > 0 {
+			a.logger.Infow("pruned nodes that stopped sending heartbeats", "users_offline", len(orphaned))
+		}
+
+		cancel()
+
+		// their only node is gone, nobody else is going to tell their peers
+		for _, userID := range orphaned {
+			a.orphanedUserOffline(done, userID)
+		}
+	}
+}
+
+// orphanedUserOffline is userOffline for a user whose node was pruned,
+// unless they reconnected to this node in the meantime
+func (a *api) orphanedUserOffline(done context.Context, userID uuid.UUID) {
+	unlock := a.presenceLocks.lock(userID)
+	defer unlock()
+
+	if a.clients.isOnline(userID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(done, 5*time.Second)
+	defer cancel()
+
+	a.userOffline(ctx, userID)
+}
+
+// userConnected records the user's first device on this node,
+// peers hear about it if the user wasn't connected to any other node
+func (a *api) userConnected(userID uuid.UUID) {
+	unlock := a.presenceLocks.lock(userID)
+	defer unlock()
+
+	// disconnected again already
+	if !a.clients.isOnline(userID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	nodes, err := a.storage.Presence.Connect(ctx, queries.AddUserPresenceParams{
+		UserID:     userID,
+		NodeID:     a.nodeID,
+		AliveSince: time.Now().Add(-nodeTimeout),
+	})
+	if err != nil {
+		a.logger.Errorw("couldn't record presence", "user_id", userID.String(), "error", err.Error())
+	}
+
+	if nodes <= 1 {
+		a.broadcaseOnlineStatus(userID)
+	}
+}
+
+// userDisconnected is userConnected for the last device on this node
+func (a *api) userDisconnected(userID uuid.UUID) {
+	unlock := a.presenceLocks.lock(userID)
+	defer unlock()
+
+	// reconnected already
+	if a.clients.isOnline(userID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	nodes, err := a.storage.Presence.Disconnect(ctx, queries.RemoveUserPresenceParams{
+		UserID:     userID,
+		NodeID:     a.nodeID,
+		AliveSince: time.Now().Add(-nodeTimeout),
+	})
+	if err != nil {
+		a.logger.Errorw("couldn't record presence", "user_id", userID.String(), "error", err.Error())
+	}
+
+	if nodes == 0 {
+		a.userOffline(ctx, userID)
+	}
+}
+
+// userOffline records when the user was last seen and tells their peers,
+// once the user isn't connected to any node
+func (a *api) userOffline(ctx context.Context, userID uuid.UUID) {
+	if err := a.storage.Users.UpdateLastSeen(ctx, userID); err != nil {
+		a.logger.Errorw("couldn't update last seen", "user_id", userID.String(), "error", err.Error())
+	}
+	a.broadCastOfflineStatus(userID)
+}
+
+// isOnline tells whether the user is connected to any node
+func (a *api) isOnline(ctx context.Context, userID uuid.UUID) bool {
+	return a.onlineUsers(ctx, []uuid.UUID{userID})[userID]
+}
+
+// onlineUsers tells which of the users are connected to any node,
+// the ones connected to this node don't need the registry
+func (a *api) onlineUsers(ctx context.Context, userIDs []uuid.UUID) map[uuid.UUID]bool {
+	online := make(map[uuid.UUID]bool, len(userIDs))
+	remote := make([]uuid.UUID, 0, len(userIDs))
+	for _, id := range userIDs {
+		if a.clients.isOnline(id) {
+			online[id] = true
+		} else {
+			remote = append(remote, id)
+		}
+	}
+
+	if len(remote) == 0 {
+		return online
+	}
+
+	ids, err := a.storage.Presence.GetOnline(ctx, queries.GetOnlineUserIDsParams{
+		UserIds:    remote,
+		AliveSince: time.Now().Add(-nodeTimeout),
+	})
+	if err != nil {
+		a.logger.Errorw("couldn't load presence", "error", err.Error())
+		return online
+	}
+
+	for _, id := range ids {
+		online[id] = true
+	}
+	return online
+}
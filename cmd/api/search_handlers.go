@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+type searchConfig struct {
+	defaultPageSize int
+	maxPageSize     int
+}
+
+type messageSearchResponse struct {
+	Results []queries.SearchMessagesRow `json:"results"`
+	// Pass as cursor= to load the next page, empty when there isn't one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// searchCursor is a position in the search results, ordered by (rank, created_at, id)
+type searchCursor struct {
+	Rank      float32
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func searchCursorOf(r queries.SearchMessagesRow) searchCursor {
+	return searchCursor{
+		Rank:      r.Rank,
+		CreatedAt: r.CreatedAt,
+		ID:        r.ID,
+	}
+}
+
+// the rank is kept as raw bits so the next page compares against exactly the same value
+func (c searchCursor) String() string {
+	raw := fmt.Sprintf("%d:%d:%s", math.Float32bits(c.Rank), c.CreatedAt.UnixMicro(), c.ID.String())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSearchCursor(s string) (searchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return searchCursor{}, errInvalidCursor
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 {
+		return searchCursor{}, errInvalidCursor
+	}
+
+	rankBits, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return searchCursor{}, errInvalidCursor
+	}
+
+	unixMicro, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return searchCursor{}, errInvalidCursor
+	}
+
+	validUUID, err := uuid.Parse(parts[2])
+	if err != nil {
+		return searchCursor{}, errInvalidCursor
+	}
+
+	return searchCursor{
+		Rank:      math.Float32frombits(uint32(rankBits)),
+		CreatedAt: time.UnixMicro(unixMicro),
+		ID:        validUUID,
+	}, nil
+}
+
+// parseSearchTime accepts RFC 3339 timestamps or plain dates,
+// a plain date used as an upper bound covers the whole day
+func parseSearchTime(param string, upperBound bool) (pgtype.Timestamptz, error) {
+	if param == "" {
+		return pgtype.Timestamptz{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, param); err == nil {
+		return pgtype.Timestamptz{Time: t, Valid: true}, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, param)
+	if err != nil {
+		return pgtype.Timestamptz{}, errors.New("dates must be RFC 3339 timestamps or YYYY-MM-DD")
+	}
+
+	if upperBound {
+		t = t.AddDate(0, 0, 1)
+	}
+	return pgtype.Timestamptz{Time: t, Valid: true}, nil
+}
+
+func parseOptionalUUID(param string) (pgtype.UUID, error) {
+	if param == "" {
+		return pgtype.UUID{}, nil
+	}
+
+	id, err := uuid.Parse(param)
+	if err != nil {
+		return pgtype.UUID{}, err
+	}
+	return pgtype.UUID{Bytes: id, Valid: true}, nil
+}
+
+// searchMessagesHandler searches every conversation the user is a member of,
+// q uses web search syntax: "quoted phrases", or, -excluded
+func (a *api) searchMessagesHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+
+	query := strings.TrimSpace(c.QueryParam("q"))
+	if query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "q is required")
+	}
+
+	params := queries.SearchMessagesParams{
+		Query:  query,
+		UserID: user.ID,
+	}
+
+	var err error
+	if params.ConversationID, err = parseOptionalUUID(c.QueryParam("conversation_id")); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid conversation id")
+	}
+
+	if params.SenderID, err = parseOptionalUUID(c.QueryParam("sender_id")); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid sender id")
+	}
+
+	if params.SentAfter, err = parseSearchTime(c.QueryParam("from"), false); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if params.SentBefore, err = parseSearchTime(c.QueryParam("to"), true); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		decoded, err := decodeSearchCursor(cursor)
+		if err != nil {
+			a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		params.AfterRank = pgtype.Float4{Float32: decoded.Rank, Valid: true}
+		params.AfterCreatedAt = decoded.CreatedAt
+		params.AfterID = decoded.ID
+	}
+
+	limit, err := a.searchPageSize(c.QueryParam("limit"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// one extra row tells whether there is anything left
+	params.PageSize = int32(limit + 1)
+
+	results, err := a.storage.Messages.Search(c.Request().Context(), params)
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	resp := messageSearchResponse{Results: results}
+	if len(results) > limit {
+		resp.Results = results[:limit]
+		resp.NextCursor = searchCursorOf(resp.Results[limit-1]).String()
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *api) searchPageSize(param string) (int, error) {
+	if param == "" {
+		return a.searchConfig.defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive number")
+	}
+
+	return min(limit, a.searchConfig.maxPageSize), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/base64"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSearchCursorRoundTrip(t *testing.T) {
+	id := uuid.MustParse("0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90")
+	createdAt := time.Now().Truncate(time.Microsecond)
+
+	tests := []struct {
+		name   string
+		cursor searchCursor
+	}{
+		{name: "typical rank", cursor: searchCursor{Rank: 0.0607927, CreatedAt: createdAt, ID: id}},
+		{name: "zero rank", cursor: searchCursor{Rank: 0, CreatedAt: createdAt, ID: id}},
+		// the raw bits keep ranks a decimal representation would round
+		{name: "smallest rank", cursor: searchCursor{Rank: math.SmallestNonzeroFloat32, CreatedAt: createdAt, ID: id}},
+		{name: "largest rank", cursor: searchCursor{Rank: math.MaxFloat32, CreatedAt: createdAt, ID: id}},
+		{name: "before the epoch", cursor: searchCursor{Rank: 1, CreatedAt: time.UnixMicro(-1_234_567), ID: id}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeSearchCursor(tt.cursor.String())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Rank != tt.cursor.Rank || !got.CreatedAt.Equal(tt.cursor.CreatedAt) || got.ID != tt.cursor.ID {
+				t.Errorf("got %+v, want %+v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeSearchCursorMalformed(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "empty", cursor: ""},
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "message cursor", cursor: messageCursor{CreatedAt: time.Now(), ID: uuid.New()}.String()},
+		{name: "too many parts", cursor: encode("1:2:0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90:3")},
+		{name: "rank isn't a number", cursor: encode("high:1700000000000000:0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90")},
+		{name: "negative rank bits", cursor: encode("-1:1700000000000000:0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90")},
+		{name: "rank bits overflow", cursor: encode("4294967296:1700000000000000:0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90")},
+		{name: "time isn't a number", cursor: encode("1:yesterday:0b7e3c2e-5d0a-4f3e-9a51-6a1f2c7d8e90")},
+		{name: "bad id", cursor: encode("1:1700000000000000:not-a-uuid")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeSearchCursor(tt.cursor); err != errInvalidCursor {
+				t.Errorf("got error %v, want %v", err, errInvalidCursor)
+			}
+		})
+	}
+}
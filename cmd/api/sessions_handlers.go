@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/myselfBZ/chatrix-v2/internal/auth"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+)
+
+const (
+	// access tokens carry the session they were issued for
+	sessionIDClaim  = "sid"
+	sessionIDCtxKey = "session_id"
+)
+
+var errSessionRevoked = errors.New("session has been revoked")
+
+type sessionResponse struct {
+	queries.Session
+	// The session the request was made from
+	Current bool `json:"current"`
+}
+
+// tokenSessionID checks the session an access token was issued for is still going
+func (a *api) tokenSessionID(ctx context.Context, claims jwt.MapClaims, userID uuid.UUID) (uuid.UUID, error) {
+	sid, ok := claims[sessionIDClaim].(string)
+	if !ok {
+		return uuid.UUID{}, errSessionRevoked
+	}
+
+	sessionID, err := uuid.Parse(sid)
+	if err != nil {
+		return uuid.UUID{}, errSessionRevoked
+	}
+
+	active, err := a.storage.Sessions.IsActive(ctx, queries.IsSessionActiveParams{
+		ID:     sessionID,
+		UserID: userID,
+	})
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if !active {
+		return uuid.UUID{}, errSessionRevoked
+	}
+
+	return sessionID, nil
+}
+
+// revokeSession ends the session and drops the websocket connections that were opened with it
+func (a *api) revokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	err := a.storage.Sessions.Revoke(ctx, queries.RevokeSessionParams{
+		ID:     sessionID,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	a.closeWebsocketSessions(userID, sessionID)
+	return nil
+}
+
+// closeWebsocketSessions reaches the connections of the session on every node
+func (a *api) closeWebsocketSessions(userID, sessionID uuid.UUID) {
+	payload, _ := json.Marshal(revocation{UserID: userID, SessionID: sessionID})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.broker.Publish(ctx, revocationsChannel, payload); err != nil {
+		a.logger.Errorw("couldn't publish revocation", "session_id", sessionID.String(), "error", err.Error())
+		// at least the connections on this node go
+		a.closeLocalWebsocketSessions(userID, sessionID)
+	}
+}
+
+func (a *api) closeLocalWebsocketSessions(userID, sessionID uuid.UUID) {
+	// close frames are capped at 125 bytes, this one is well under
+	closeMsg, _ := json.Marshal(Wrapper{
+		MsgType: ERR,
+		Message: &Err{
+			Reason: errSessionRevoked.Error(),
+			Code:   http.StatusUnauthorized,
+		},
+	})
+
+	for _, s := range a.clients.sessions(userID) {
+		if sid, ok := s.Get(sessionIDSessionKey); ok && sid == sessionID.String() {
+			s.CloseWithMsg(closeMsg)
+		}
+	}
+}
+
+func (a *api) getSessionsHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	currentID, _ := c.Get(sessionIDCtxKey).(uuid.UUID)
+
+	sessions, err := a.storage.Sessions.GetActive(c.Request().Context(), queries.GetActiveSessionsByUserIDParams{
+		UserID:      user.ID,
+		ActiveSince: time.Now().Add(-auth.RefreshTokenTTL),
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	resp := make([]sessionResponse, len(sessions))
+	for i, session := range sessions {
+		resp[i] = sessionResponse{
+			Session: session,
+			Current: session.ID == currentID,
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// revokeSessionHandler logs one of the user's devices out, revoking the current session works like logging out
+func (a *api) revokeSessionHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid session id")
+	}
+
+	if err := a.revokeSession(c.Request().Context(), user.ID, sessionID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			a.notFoundLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		default:
+			a.internalErrLog(c.Request().Method, c.Path(), err)
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+	}
+
+	if currentID, _ := c.Get(sessionIDCtxKey).(uuid.UUID); currentID == sessionID {
+		a.clearRefreshCookie(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/olahol/melody"
+)
+
+const (
+	// clients wait at least this long before reconnecting, the load balancer needs
+	// a moment to notice this node is gone
+	shutdownRetryAfter = 2 * time.Second
+	// and up to this much more, so they don't all land on the other nodes at once
+	shutdownRetryJitter = 5 * time.Second
+)
+
+var errShutdownTimeout = errors.New("shutdown deadline exceeded")
+
+// shutdown stops taking requests, tells the websocket clients to reconnect elsewhere and
+// waits for their disconnects to be recorded before closing the database, all within timeout
+func (a *api) shutdown(e *echo.Echo, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	a.logger.Infow("shutting down", "timeout", timeout.String(), "connections", a.mel.Len())
+
+	// readyz fails from now on and new websockets are turned away
+	a.shuttingDown.Store(true)
+
+	a.announceShutdown()
+
+	var errs []error
+	if err := e.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http server: %w", err))
+	}
+
+	// the close frame is queued behind whatever is still being written
+	if err := a.mel.CloseWithMsg(melody.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")); err != nil && !errors.Is(err, melody.ErrClosed) {
+		errs = append(errs, fmt.Errorf("websocket hub: %w", err))
+	}
+
+	if err := a.waitForDisconnects(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	// nobody is connected here anymore, the other nodes don't have to wait for the heartbeat to time out
+	if err := a.storage.Presence.RemoveNode(ctx, a.nodeID); err != nil {
+		errs = append(errs, fmt.Errorf("presence: %w", err))
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		// waits for every acquired connection to be released
+		a.db.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("database: %w", errShutdownTimeout))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	a.logger.Infow("shut down")
+	return nil
+}
+
+// announceShutdown tells every connected client to reconnect, to another node
+func (a *api) announceShutdown() {
+	jsonData, _ := json.Marshal(Wrapper{
+		MsgType: SERVER_SHUTDOWN,
+		Message: &ServerShutdown{
+			Reason:        "server shutting down",
+			RetryAfterMs:  shutdownRetryAfter.Milliseconds(),
+			RetryJitterMs: shutdownRetryJitter.Milliseconds(),
+		},
+	})
+
+	sessions, err := a.mel.Sessions()
+	if err != nil {
+		return
+	}
+
+	for _, s := range sessions {
+		s.Write(jsonData)
+	}
+}
+
+// waitForDisconnects waits until the disconnect handler ran for every connection,
+// which is where last seen and presence are recorded
+func (a *api) waitForDisconnects(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for a.openConnections.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d websocket connections left: %w", a.openConnections.Load(), errShutdownTimeout)
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/olahol/melody"
+)
+
+const syncSessionKey = "sync"
+
+type syncConfig struct {
+	// events per SYNC_EVENTS frame
+	pageSize int
+	// how long events are kept for devices that are offline
+	retention time.Duration
+}
+
+// syncState holds back the events a session receives while it's replaying the ones it missed
+type syncState struct {
+	mu      sync.Mutex
+	syncing bool
+	pending []pendingEvent
+}
+
+type pendingEvent struct {
+	seq  int64
+	data []byte
+}
+
+func sessionSyncState(s *melody.Session) (*syncState, bool) {
+	state, ok := s.Get(syncSessionKey)
+	if !ok {
+		return nil, false
+	}
+	return state.(*syncState), true
+}
+
+// start reports false if the session is already syncing
+func (st *syncState) start() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.syncing {
+		return false
+	}
+	st.syncing = true
+	return true
+}
+
+// hold keeps the event for later if the session is syncing,
+// events without a sequence number are never held back
+func (st *syncState) hold(seq int64, data []byte) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !st.syncing || seq == 0 {
+		return false
+	}
+	st.pending = append(st.pending, pendingEvent{seq: seq, data: data})
+	return true
+}
+
+// finish writes the held back events the replay didn't cover and switches the session to live delivery
+func (st *syncState) finish(s *melody.Session, replayedUpTo int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, event := range st.pending {
+		if event.seq > replayedUpTo {
+			s.Write(event.data)
+		}
+	}
+	st.pending = nil
+	st.syncing = false
+}
+
+func (a *api) handleSync(s *melody.Session, msg *Sync) {
+	userID, ok := sessionUserID(s)
+	if !ok {
+		return
+	}
+
+	state, ok := sessionSyncState(s)
+	if !ok || !state.start() {
+		writeJSONErr(s, &Err{
+			Reason: "already syncing",
+			Code:   http.StatusConflict,
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	done, err := a.replayEvents(ctx, s, userID, msg.LastSeq)
+	if err != nil {
+		a.logger.Errorw("couldn't replay events", "user_id", userID.String(), "error", err.Error())
+		state.finish(s, done.LastSeq)
+		writeJSONErr(s, &Err{
+			Reason: "sync failed",
+			Code:   http.StatusInternalServerError,
+		})
+		return
+	}
+
+	writeJSONMsg(s, Wrapper{
+		MsgType: SYNC_DONE,
+		Message: &done,
+	})
+	state.finish(s, done.LastSeq)
+}
+
+// replayEvents writes every event after lastSeq to the session, page by page
+func (a *api) replayEvents(ctx context.Context, s *melody.Session, userID uuid.UUID, lastSeq *int64) (SyncDone, error) {
+	bounds, err := a.storage.Events.GetBounds(ctx, userID)
+	if err != nil {
+		return SyncDone{}, err
+	}
+
+	// nothing to go on, or the events it missed have been pruned already
+	if lastSeq == nil || *lastSeq > bounds.LastSeq ||
+		(*lastSeq < bounds.LastSeq && (bounds.OldestSeq == 0 || bounds.OldestSeq > *lastSeq+1)) {
+		return SyncDone{LastSeq: bounds.LastSeq, Reset: true}, nil
+	}
+
+	done := SyncDone{LastSeq: *lastSeq}
+	for {
+		events, err := a.storage.Events.GetAfter(ctx, queries.GetUserEventsAfterParams{
+			UserID:   userID,
+			AfterSeq: done.LastSeq,
+			PageSize: int32(a.syncConfig.pageSize),
+		})
+		if err != nil {
+			return done, err
+		}
+
+		if len(events) == 0 {
+			return done, nil
+		}
+
+		page := SyncEvents{Events: make([]Wrapper, len(events))}
+		for i, event := range events {
+			page.Events[i] = Wrapper{
+				MsgType: event.EventType,
+				Message: replayedMessage(event.Payload),
+				Seq:     event.Seq,
+			}
+		}
+
+		if err := writeJSONMsg(s, Wrapper{MsgType: SYNC_EVENTS, Message: &page}); err != nil {
+			return done, err
+		}
+
+		done.LastSeq = events[len(events)-1].Seq
+		done.Replayed += len(events)
+
+		if len(events) < a.syncConfig.pageSize {
+			return done, nil
+		}
+	}
+}
+
+// publish records msg in the event log of every user so devices that are offline can
+// replay it later, then delivers it to every connected device except the given session.
+// It returns the sequence number each user got, the event is still delivered live if recording fails.
+func (a *api) publish(ctx context.Context, userIDs []uuid.UUID, except *melody.Session, msg Wrapper) map[uuid.UUID]int64 {
+	return a.publishMuted(ctx, userIDs, except, msg, nil)
+}
+
+// publishMuted is publish with the event flagged as muted for some of the users
+func (a *api) publishMuted(ctx context.Context, userIDs []uuid.UUID, except *melody.Session, msg Wrapper, muted []uuid.UUID) map[uuid.UUID]int64 {
+	userIDs = uniqueUserIDs(userIDs)
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	payload, _ := json.Marshal(msg.Message)
+	seqs, err := a.storage.Events.Record(ctx, queries.CreateUserEventsParams{
+		EventType: msg.MsgType,
+		Payload:   payload,
+		UserIds:   userIDs,
+	})
+	if err != nil {
+		a.logger.Errorw("couldn't record event", "type", msg.MsgType, "error", err.Error())
+	}
+
+	a.deliverMuted(userIDs, except, msg, seqs, muted)
+	return seqs
+}
+
+// publishToMembers is publish for every member of the conversation except the excluded user
+func (a *api) publishToMembers(ctx context.Context, conversationID, exclude uuid.UUID, except *melody.Session, msg Wrapper) map[uuid.UUID]int64 {
+	memberIDs, err := a.storage.Conversations.GetMemberIDs(ctx, conversationID)
+	if err != nil {
+		a.logger.Errorw("couldn't load conversation members", "conversation_id", conversationID.String(), "error", err.Error())
+		return nil
+	}
+
+	recipients := make([]uuid.UUID, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		if memberID != exclude {
+			recipients = append(recipients, memberID)
+		}
+	}
+
+	return a.publishMuted(ctx, recipients, except, msg, a.mutedMembers(ctx, conversationID))
+}
+
+// pruneEvents drops events nobody can sync anymore, once an hour until ctx is done
+func (a *api) pruneEvents(done context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(done, time.Minute)
+		deleted, err := a.storage.Events.Prune(ctx, time.Now().Add(-a.syncConfig.retention))
+		cancel()
+
+		if err != nil {
+			a.logger.Errorw("couldn't prune events", "error", err.Error())
+			continue
+		}
+		a.logger.Infow("pruned events", "deleted", deleted)
+	}
+}
+
+// uniqueUserIDs drops duplicates and sorts the ids, so concurrent
+// writers bump the event counters in the same order
+func uniqueUserIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	slices.SortFunc(unique, func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return unique
+}
@@ -21,6 +21,18 @@ type searchPayload struct {
 	Query string `json:"query"`
 }
 
+// who can start a direct conversation with a user
+const (
+	messagePrivacyEveryone = "everyone"
+	// strangers have to go through a message request
+	messagePrivacyRequests = "requests"
+	messagePrivacyContacts = "contacts"
+)
+
+type messagePrivacyPayload struct {
+	MessagePrivacy string `json:"message_privacy" validate:"required,oneof=everyone requests contacts"`
+}
+
 func (a *api) searchUserHandler(c echo.Context) error {
 	var payload searchPayload
 	user := c.Get(userCtxValKey).(queries.User)
@@ -43,10 +55,15 @@ func (a *api) searchUserHandler(c echo.Context) error {
 
 	convaersations := make([]searchUserResponse, len(users))
 
+	userIDs := make([]uuid.UUID, len(users))
+	for i, c := range users {
+		userIDs[i] = c.ID
+	}
+	online := a.onlineUsers(c.Request().Context(), userIDs)
+
 	for i, c := range users {
-		_, isOnline := a.clients.Load(c.ID.String())
 		convaersations[i] = searchUserResponse{
-			IsOnline: isOnline,
+			IsOnline: online[c.ID],
 			LastSeen: c.LastSeen.Time,
 			ID: c.ID,
 			Username: c.Username,
@@ -55,3 +72,28 @@ func (a *api) searchUserHandler(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, convaersations)
 }
+
+func (a *api) updateMessagePrivacyHandler(c echo.Context) error {
+	user := c.Get(userCtxValKey).(queries.User)
+	var payload messagePrivacyPayload
+	if err := c.Bind(&payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if err := a.validator.Struct(payload); err != nil {
+		a.badRequestLog(c.Request().RequestURI, c.Path(), err)
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	updated, err := a.storage.Users.UpdateMessagePrivacy(c.Request().Context(), queries.UpdateUserMessagePrivacyParams{
+		MessagePrivacy: payload.MessagePrivacy,
+		ID:             user.ID,
+	})
+	if err != nil {
+		a.internalErrLog(c.Request().Method, c.Path(), err)
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, messagePrivacyPayload{MessagePrivacy: updated.MessagePrivacy})
+}
@@ -4,42 +4,55 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/labstack/echo/v4"
 	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
 	"github.com/olahol/melody"
 )
 
 const (
-	userIDSessionKey = "user_id"
-	authSessionKey   = "authenticated"
+	userIDSessionKey   = "user_id"
+	deviceIDSessionKey = "device_id"
+	authSessionKey     = "authenticated"
+	// the login the connection was authenticated with
+	sessionIDSessionKey = "session_id"
 )
 
 type authPayload struct {
 	Message struct {
 		Token string `json:"token"`
+		// Lets a device take over its previous session when reconnecting,
+		// generated by the server if empty
+		DeviceID string `json:"device_id"`
 	} `json:"message"`
 }
 
 func (a *api) handleDisconnect(s *melody.Session) {
-	userID, ok := s.Get(userIDSessionKey)
+	defer a.openConnections.Add(-1)
+
+	validUUID, ok := sessionUserID(s)
 	if !ok {
 		return
 	}
-	userIDString := userID.(string)
-	a.clients.Delete(userIDString)
-	validUUID, _ := uuid.Parse(userIDString)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	a.storage.Users.UpdateLastSeen(ctx, validUUID)
-	a.broadCastOfflineStatus(validUUID)
+	deviceID, _ := s.Get(deviceIDSessionKey)
+
+	// other devices are still connected, the user stays online
+	if !a.clients.remove(validUUID, deviceID.(string), s) {
+		return
+	}
+
+	a.userDisconnected(validUUID)
 }
 
 func (a *api) handleConnect(s *melody.Session) {
+	a.openConnections.Add(1)
 	go func() {
 		time.Sleep(5 * time.Second)
 		if !a.isSessionAuthenticated(s) {
@@ -53,7 +66,16 @@ func (a *api) handleMessage(s *melody.Session, msg []byte) {
 	if !a.isSessionAuthenticated(s) {
 		// Session not authenticated
 		// should be authenticated
-		user, err := a.authenticateSession(msg)
+		var payload authPayload
+		err := json.Unmarshal(msg, &payload)
+
+		var (
+			user      queries.User
+			sessionID uuid.UUID
+		)
+		if err == nil {
+			user, sessionID, err = a.authenticateSession(payload.Message.Token)
+		}
 
 		if err != nil {
 			// todo better error handling
@@ -67,16 +89,39 @@ func (a *api) handleMessage(s *melody.Session, msg []byte) {
 			s.CloseWithMsg(jsonErr)
 			return
 		}
+		deviceID := payload.Message.DeviceID
+		if deviceID == "" {
+			deviceID = uuid.NewString()
+		}
+
 		s.Set(userIDSessionKey, user.ID.String())
+		s.Set(deviceIDSessionKey, deviceID)
+		s.Set(sessionIDSessionKey, sessionID.String())
+		s.Set(syncSessionKey, &syncState{})
 		s.Set(authSessionKey, true)
 
-		a.clients.Store(user.ID.String(), s)
+		first, replaced := a.clients.add(user.ID, deviceID, s)
+		if replaced != nil {
+			replaced.Close()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// a zero sequence number only costs the device a full refetch
+		bounds, _ := a.storage.Events.GetBounds(ctx, user.ID)
+
 		welcome, _ := json.Marshal(Wrapper{
 			MsgType: WELCOME,
-			Message: &Welcome{},
+			Message: &Welcome{
+				DeviceID: deviceID,
+				LastSeq:  bounds.LastSeq,
+			},
 		})
 		s.Write(welcome)
-		a.broadcaseOnlineStatus(user.ID)
+		if first {
+			a.userConnected(user.ID)
+		}
 		return
 	}
 
@@ -94,160 +139,439 @@ func (a *api) handleMessage(s *melody.Session, msg []byte) {
 }
 
 
-func (a *api) mapIncomingEventToHandler(s *melody.Session, event *IncomingEvent) {
-	switch event.MsgType {
+// newIncomingPayload returns what the message of an incoming event decodes into,
+// nil for event types clients don't send
+func newIncomingPayload(msgType string) Message {
+	switch msgType {
 	case CHAT:
-		var payload ChatMsg
-		if err := json.Unmarshal(event.Message, &payload); err != nil {
-			a.logger.Error("couldn't Unmarshal ChatMsg event", err)
-			writeJSONErr(s, &Err{
-				Reason: "invalid payload",
-				Code: http.StatusUnprocessableEntity,
-			})
-			return
-		}
-
-		a.handleChatMessage(s, &payload)
+		return &ChatMsg{}
 	case MARK_READ:
-		var payload MarkMsgRead
+		return &MarkMsgRead{}
+	case TYPING:
+		return &Typing{}
+	case STOPPED_TYPING:
+		return &StoppedTyping{}
+	case EDIT_MSG:
+		return &EditMsg{}
+	case DELETE_MSG:
+		return &DeleteMsg{}
+	case REACT, UNREACT:
+		return &React{}
+	case SYNC:
+		return &Sync{}
+	case ACK_RECEIVED:
+		return &AckReceived{}
+	}
+	return nil
+}
 
-		if err := json.Unmarshal(event.Message, &payload); err != nil {
-			writeJSONErr(s, &Err{
-				Reason: "invalid payload",
-				Code: http.StatusUnprocessableEntity,
-			})
-		}
+func (a *api) mapIncomingEventToHandler(s *melody.Session, event *IncomingEvent) {
+	countIncomingEvent(event.MsgType)
 
+	payload := newIncomingPayload(event.MsgType)
+	if payload == nil {
+		return
+	}
 
-		a.handleMarkMsgRead(s, &payload)
-	case TYPING:
-		var payload Typing
-		if err := json.Unmarshal(event.Message, &payload); err != nil {
-			writeJSONErr(s, &Err{
-				Reason: "invalid payload",
-				Code: http.StatusUnprocessableEntity,
-			})
-		}
+	if err := json.Unmarshal(event.Message, payload); err != nil {
+		writeJSONErr(s, &Err{
+			Reason: "invalid payload",
+			Code:   http.StatusUnprocessableEntity,
+		})
+		return
+	}
 
-		a.handleTyping(s, &payload)
-	case STOPPED_TYPING:
-		var payload StoppedTyping
-		if err := json.Unmarshal(event.Message, &payload); err != nil {
-			writeJSONErr(s, &Err{
-				Reason: "invalid payload",
-				Code: http.StatusUnprocessableEntity,
-			})
-		}
-		a.handleStoppedTyping(s, &payload)
+	if !a.authorizeEvent(s, event.MsgType, payload) {
+		return
+	}
+
+	switch p := payload.(type) {
+	case *ChatMsg:
+		a.handleChatMessage(s, p)
+	case *MarkMsgRead:
+		a.handleMarkMsgRead(s, p)
+	case *Typing:
+		a.handleTyping(s, p)
+	case *StoppedTyping:
+		a.handleStoppedTyping(s, p)
+	case *EditMsg:
+		a.handleEditMessage(s, p)
+	case *DeleteMsg:
+		a.handleDeleteMessage(s, p)
+	case *React:
+		a.handleReact(s, p, event.MsgType == REACT)
+	case *Sync:
+		a.handleSync(s, p)
+	case *AckReceived:
+		a.handleAckReceived(s, p)
 	}
 }
 
+func (a *api) handleReact(s *melody.Session, msg *React, reacted bool) {
+	userID, ok := sessionUserID(s)
+	if !ok {
+		return
+	}
 
-func (a *api) handleMarkMsgRead(s *melody.Session, msg *MarkMsgRead) {
-	conversationID, err := uuid.Parse(msg.ConversationID)
+	messageID, err := uuid.Parse(msg.MessageID)
 	if err != nil {
+		writeJSONErr(s, &Err{
+			Reason: "invalid message UUID",
+			Code:   http.StatusUnprocessableEntity,
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.reactToMessage(ctx, userID, messageID, msg.Emoji, reacted); err != nil {
+		writeJSONErr(s, &Err{
+			Reason: err.Error(),
+			Code:   messageErrStatus(err),
+		})
+	}
+}
+
+func (a *api) handleDeleteMessage(s *melody.Session, msg *DeleteMsg) {
+	userID, ok := sessionUserID(s)
+	if !ok {
 		return
 	}
 
-	ownerID, err := uuid.Parse(msg.MsgOwnerID)
+	messageID, err := uuid.Parse(msg.ID)
 	if err != nil {
+		writeJSONErr(s, &Err{
+			Reason: "invalid message UUID",
+			Code:   http.StatusUnprocessableEntity,
+		})
 		return
 	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	msgIds ,err := a.storage.Messages.MarkAsRead(ctx, queries.MarkMessagesAsReadParams{
-		ConversationID: conversationID,
-		SenderID: ownerID,
-	}) 
 
+	if err := a.deleteMessage(ctx, userID, messageID, msg.ForEveryone); err != nil {
+		writeJSONErr(s, &Err{
+			Reason: err.Error(),
+			Code:   messageErrStatus(err),
+		})
+	}
+}
+
+func (a *api) handleEditMessage(s *melody.Session, msg *EditMsg) {
+	userID, ok := sessionUserID(s)
+	if !ok {
+		return
+	}
+
+	messageID, err := uuid.Parse(msg.ID)
 	if err != nil {
+		writeJSONErr(s, &Err{
+			Reason: "invalid message UUID",
+			Code:   http.StatusUnprocessableEntity,
+		})
 		return
 	}
 
-	session, ok := a.getSession(ownerID)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := a.editMessage(ctx, userID, messageID, msg.Content); err != nil {
+		writeJSONErr(s, &Err{
+			Reason: err.Error(),
+			Code:   messageErrStatus(err),
+		})
+	}
+}
+
+
+func (a *api) handleMarkMsgRead(s *melody.Session, msg *MarkMsgRead) {
+	readerID, ok := sessionUserID(s)
 	if !ok {
 		return
 	}
 
+	conversationID, err := uuid.Parse(msg.ConversationID)
+	if err != nil {
+		writeJSONErr(s, &Err{
+			Reason: "invalid conversation UUID",
+			Code:   http.StatusUnprocessableEntity,
+		})
+		return
+	}
 
-	writeJSONMsg(session, Wrapper{
-		MsgType: MSG_READ,
-		Message: &MsgRead{
-			ConversationID: msg.ConversationID,
-			MessageIDs: msgIds,
-		},
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// membership was checked by authorizeEvent
+	rows, err := a.storage.Receipts.MarkRead(ctx, queries.MarkConversationReadParams{
+		ConversationID: conversationID,
+		ReaderID:       readerID,
 	})
+
+	if err != nil || len(rows) == 0 {
+		return
+	}
+
+	// every sender only hears about their own messages
+	bySender := make(map[uuid.UUID]*MsgRead)
+	for _, r := range rows {
+		read, ok := bySender[r.SenderID]
+		if !ok {
+			read = &MsgRead{
+				ConversationID: conversationID.String(),
+				ReaderID:       readerID,
+				ReadAt:         r.ReadAt,
+			}
+			bySender[r.SenderID] = read
+		}
+		read.MessageIDs = append(read.MessageIDs, r.MessageID)
+	}
+
+	for senderID, read := range bySender {
+		a.publish(ctx, []uuid.UUID{senderID}, nil, Wrapper{
+			MsgType: MSG_READ,
+			Message: read,
+		})
+	}
+}
+
+// maxAckedMessages caps how many messages a single ACK_RECEIVED can cover
+const maxAckedMessages = 500
+
+func (a *api) handleAckReceived(s *melody.Session, msg *AckReceived) {
+	recipientID, ok := sessionUserID(s)
+	if !ok {
+		return
+	}
+
+	if len(msg.MessageIDs) == 0 {
+		return
+	}
+
+	if len(msg.MessageIDs) > maxAckedMessages {
+		writeJSONErr(s, &Err{
+			Reason: fmt.Sprintf("at most %d messages can be acknowledged at once", maxAckedMessages),
+			Code:   http.StatusUnprocessableEntity,
+		})
+		return
+	}
+
+	messageIDs := make([]uuid.UUID, len(msg.MessageIDs))
+	for i, id := range msg.MessageIDs {
+		messageID, err := uuid.Parse(id)
+		if err != nil {
+			writeJSONErr(s, &Err{
+				Reason: "invalid message UUID",
+				Code:   http.StatusUnprocessableEntity,
+			})
+			return
+		}
+		messageIDs[i] = messageID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// messages of other conversations and repeated acknowledgements are skipped by the store
+	rows, err := a.storage.Receipts.MarkDelivered(ctx, queries.MarkMessagesDeliveredParams{
+		RecipientID: recipientID,
+		MessageIds:  messageIDs,
+	})
+
+	if err != nil {
+		a.logger.Errorw("couldn't mark messages as delivered", "user_id", recipientID.String(), "error", err.Error())
+		return
+	}
+
+	type senderConversation struct {
+		senderID       uuid.UUID
+		conversationID uuid.UUID
+	}
+
+	delivered := make(map[senderConversation]*MsgDelivered)
+	for _, r := range rows {
+		key := senderConversation{senderID: r.SenderID, conversationID: r.ConversationID}
+		d, ok := delivered[key]
+		if !ok {
+			d = &MsgDelivered{
+				ConversationID: r.ConversationID,
+				RecipientID:    recipientID,
+				DeliveredAt:    r.DeliveredAt,
+			}
+			delivered[key] = d
+		}
+		d.MessageIDs = append(d.MessageIDs, r.MessageID)
+	}
+
+	for key, d := range delivered {
+		a.publish(ctx, []uuid.UUID{key.senderID}, nil, Wrapper{
+			MsgType: MSG_DELIVERED,
+			Message: d,
+		})
+	}
 }
 
 
 
 func (a *api) handleChatMessage(s *melody.Session, msg *ChatMsg) {
-	fromUUID, err := uuid.Parse(msg.From)
+	fromUUID, ok := sessionUserID(s)
+	if !ok {
+		return
+	}
 
+	// resolved and checked by authorizeEvent
+	conversationID, err := uuid.Parse(msg.ConversationID)
 	if err != nil {
 		writeJSONErr(s, &MessageErr{
 			TempID: msg.TempID,
-			Reason: "invalid user UUID",
+			Reason: "invalid conversation UUID",
 		})
 		return
 	}
 
-	toUUID, err := uuid.Parse(msg.To)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	if err != nil {
+	var replyToID pgtype.UUID
+	if msg.ReplyTo != "" {
+		quoted, reason := a.resolveReply(ctx, conversationID, msg.ReplyTo)
+		if reason != "" {
+			writeJSONErr(s, &MessageErr{
+				Reason: reason,
+				TempID: msg.TempID,
+			})
+			return
+		}
+
+		replyToID = pgtype.UUID{Bytes: quoted.ID, Valid: true}
+		msg.Quoted = quoted
+	}
+
+	attachmentIDs, reason := parseAttachmentIDs(msg.AttachmentIDs)
+	if reason == "" && msg.Content == "" && len(attachmentIDs) == 0 {
+		reason = "message is empty"
+	}
+	if reason != "" {
 		writeJSONErr(s, &MessageErr{
-			Reason: "invalid UUID",
+			Reason: reason,
 			TempID: msg.TempID,
 		})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	create := a.storage.Messages.Create
+	if msg.RequestPending {
+		create = a.storage.Messages.CreateInRequest
+	}
 
-	dbMsg, err := a.storage.Messages.Create(ctx, queries.CreateMessageParams{
-		SenderID: fromUUID,
-		User2:    toUUID,
-		Content:  msg.Content,
-	})
+	dbMsg, attachments, err := create(ctx, queries.CreateMessageParams{
+		SenderID:       fromUUID,
+		ConversationID: conversationID,
+		Content:        msg.Content,
+		ReplyToID:      replyToID,
+	}, attachmentIDs)
 
 	if err != nil {
+		reason := "message couldn't be created"
+		switch err {
+		case store.ErrConstraintMessage:
+			reason = "invalid attachments"
+		case store.ErrLimitReached:
+			reason = "wait until they accept your message request"
+		}
 		writeJSONErr(s, 
 			&MessageErr{
-				Reason: "message couldn't be created",
+				Reason: reason,
 				TempID: msg.TempID,
 			},
 		)
 		return
-	} else {
-		go writeJSONMsg(s, Wrapper{
-			MsgType: AKC_MSG_DELIVERED,
-			Message: &AcknowledgementMsgDelivered{
-				RecieverID: msg.To,
-				CreatedAt: dbMsg.CreatedAt.Time,
-				TempID:    msg.TempID,
-				ID:        dbMsg.ID.String(),
-			},
-		})
 	}
 
-
 	msg.CreatedAt = dbMsg.CreatedAt.Time
 	msg.ID = dbMsg.ID
+	msg.ConversationID = dbMsg.ConversationID.String()
+	msg.AttachmentIDs = nil
+	msg.Attachments = a.newAttachmentResponses(attachments)
 
-	session, ok := a.getSession(toUUID)
-
-	if !ok {
-		return
-	}
-
-	writeJSONMsg(session, Wrapper{
+	// a direct conversation is just a group of two,
+	// the sender's other devices get it too to keep them in sync
+	seqs := a.publishToMembers(ctx, dbMsg.ConversationID, uuid.Nil, s, Wrapper{
 		MsgType: CHAT,
 		Message: msg,
 	})
+	a.notifyConversationUpdated(ctx, dbMsg, len(attachments), msg.RequestPending)
+
+	// the sending device already has the message, the sequence number
+	// of the sender's copy is handed over with the acknowledgement
+	writeJSONMsg(s, Wrapper{
+		MsgType: AKC_MSG_DELIVERED,
+		Message: &AcknowledgementMsgDelivered{
+			RecieverID:     msg.To,
+			ConversationID: dbMsg.ConversationID.String(),
+			CreatedAt:      dbMsg.CreatedAt.Time,
+			TempID:         msg.TempID,
+			ID:             dbMsg.ID.String(),
+		},
+		Seq: seqs[fromUUID],
+	})
+}
+
+// parseAttachmentIDs validates the attachment ids of a chat message,
+// a non empty reason is sent back to the client when they're invalid
+func parseAttachmentIDs(raw []string) ([]uuid.UUID, string) {
+	if len(raw) > maxAttachmentsPerMessage {
+		return nil, fmt.Sprintf("a message can have at most %d attachments", maxAttachmentsPerMessage)
+	}
+
+	ids := make([]uuid.UUID, 0, len(raw))
+	seen := make(map[uuid.UUID]bool, len(raw))
+	for _, r := range raw {
+		id, err := uuid.Parse(r)
+		if err != nil {
+			return nil, "invalid attachment UUID"
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, ""
+}
+
+// resolveReply loads the snapshot of the message being replied to,
+// which has to be in the same conversation
+func (a *api) resolveReply(ctx context.Context, conversationID uuid.UUID, replyTo string) (*QuotedMsg, string) {
+	replyToID, err := uuid.Parse(replyTo)
+	if err != nil {
+		return nil, "invalid reply UUID"
+	}
+
+	target, err := a.storage.Messages.GetByID(ctx, replyToID)
+	if err != nil || target.ConversationID != conversationID {
+		return nil, "replied message not found in this conversation"
+	}
+
+	quoted, err := a.storage.Messages.GetQuoted(ctx, []uuid.UUID{replyToID})
+	if err != nil || len(quoted) == 0 {
+		return nil, "replied message not found in this conversation"
+	}
+
+	return newQuotedMsg(quoted[0]), ""
 }
 
 func (a *api) handleStoppedTyping(s *melody.Session, msg *StoppedTyping) {
+	if msg.ConversationID != "" {
+		a.notifyTyping(s, msg.ConversationID, Wrapper{
+			MsgType: STOPPED_TYPING,
+			Message: msg,
+		})
+		return
+	}
+
 	toUUID, err := uuid.Parse(msg.To)
 	if err != nil {
 		writeJSONErr(s, &Err{
@@ -257,13 +581,7 @@ func (a *api) handleStoppedTyping(s *melody.Session, msg *StoppedTyping) {
 		return
 	}
 
-	toSession, ok := a.getSession(toUUID)
-
-	if !ok {
-		return
-	}
-
-	writeJSONMsg(toSession, Wrapper{
+	a.sendToUser(toUUID, Wrapper{
 		MsgType: STOPPED_TYPING,
 		Message: msg,
 	})
@@ -271,6 +589,14 @@ func (a *api) handleStoppedTyping(s *melody.Session, msg *StoppedTyping) {
 
 
 func (a *api) handleTyping(s *melody.Session, msg *Typing) {
+	if msg.ConversationID != "" {
+		a.notifyTyping(s, msg.ConversationID, Wrapper{
+			MsgType: TYPING,
+			Message: msg,
+		})
+		return
+	}
+
 	toUUID, err := uuid.Parse(msg.To)
 	if err != nil {
 		writeJSONErr(s, &Err{
@@ -280,50 +606,64 @@ func (a *api) handleTyping(s *melody.Session, msg *Typing) {
 		return
 	}
 
-	toSession, ok := a.getSession(toUUID)
+	a.sendToUser(toUUID, Wrapper{
+		MsgType: TYPING,
+		Message: msg,
+	})
+}
 
+// notifyTyping fans typing events out to the rest of a group
+func (a *api) notifyTyping(s *melody.Session, conversationID string, msg Wrapper) {
+	conversationUUID, err := uuid.Parse(conversationID)
+	if err != nil {
+		writeJSONErr(s, &Err{
+			Reason: "invalid conversation UUID",
+			Code:   http.StatusUnprocessableEntity,
+		})
+		return
+	}
+
+	fromUUID, ok := sessionUserID(s)
 	if !ok {
 		return
 	}
 
-	writeJSONMsg(toSession, Wrapper{
-		MsgType: TYPING,
-		Message: msg,
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a.notifyMembers(ctx, conversationUUID, fromUUID, msg)
 }
 
 func (a *api) handleWebSocket(c echo.Context) error {
+	if a.shuttingDown.Load() {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "server is shutting down")
+	}
 	a.mel.HandleRequest(c.Response().Writer, c.Request())
 	return nil
 }
 
-func (a *api) authenticateSession(msg []byte) (queries.User, error) {
-	var payload authPayload
-	if err := json.Unmarshal(msg, &payload); err != nil {
-		return queries.User{}, err
-	}
-	token := payload.Message.Token
+func (a *api) authenticateSession(token string) (queries.User, uuid.UUID, error) {
 	jwtToken, err := a.auth.ValidateAccessToken(token)
 	if err != nil {
-		return queries.User{}, err
+		return queries.User{}, uuid.UUID{}, err
 	}
 
 	claims, ok := jwtToken.Claims.(jwt.MapClaims)
 
 	if !ok {
-		return queries.User{}, errors.New("not jwt.MapClaims")
+		return queries.User{}, uuid.UUID{}, errors.New("not jwt.MapClaims")
 	}
 
 	userID, ok := claims["sub"].(string)
 
 	if !ok {
-		return queries.User{}, errors.New("invalid user id")
+		return queries.User{}, uuid.UUID{}, errors.New("invalid user id")
 	}
 
 	validUUID, err := uuid.Parse(userID)
 
 	if err != nil {
-		return queries.User{}, err
+		return queries.User{}, uuid.UUID{}, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -331,10 +671,15 @@ func (a *api) authenticateSession(msg []byte) (queries.User, error) {
 	user, err := a.storage.Users.GetByID(ctx, validUUID)
 
 	if err != nil {
-		return queries.User{}, err
+		return queries.User{}, uuid.UUID{}, err
 	}
 
-	return user, nil
+	sessionID, err := a.tokenSessionID(ctx, claims, user.ID)
+	if err != nil {
+		return queries.User{}, uuid.UUID{}, err
+	}
+
+	return user, sessionID, nil
 }
 
 func (a *api) isSessionAuthenticated(s *melody.Session) bool {
@@ -361,11 +706,17 @@ func writeJSONErr(s *melody.Session, err Message) error {
 }
 
 
-func (a *api) getSession(id uuid.UUID) (*melody.Session, bool) {
-	sessionAny, ok := a.clients.Load(id.String())
+// sessionUserID returns the id of the user the session was authenticated as
+func sessionUserID(s *melody.Session) (uuid.UUID, bool) {
+	userID, ok := s.Get(userIDSessionKey)
 	if !ok {
-		return nil, ok
+		return uuid.UUID{}, false
 	}
-	session := sessionAny.(*melody.Session)
-	return session, ok
+
+	validUUID, err := uuid.Parse(userID.(string))
+	if err != nil {
+		return uuid.UUID{}, false
+	}
+
+	return validUUID, true
 }
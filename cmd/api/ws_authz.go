@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"github.com/myselfBZ/chatrix-v2/internal/store"
+	"github.com/olahol/melody"
+)
+
+// eventErr is why an incoming event was refused, it is sent back to the client
+type eventErr struct {
+	reason string
+	code   int
+}
+
+func (e *eventErr) Error() string { return e.reason }
+
+var (
+	errEventNotMember       = &eventErr{reason: "not a member of this conversation", code: http.StatusForbidden}
+	errEventNoConversation  = &eventErr{reason: "no conversation with this user", code: http.StatusForbidden}
+	errEventMessageNotFound = &eventErr{reason: "message not found", code: http.StatusNotFound}
+	errEventNotAllowed      = &eventErr{reason: "event not allowed", code: http.StatusForbidden}
+	errEventBlocked         = &eventErr{reason: errUserBlocked.Error(), code: http.StatusForbidden}
+	errEventRequestPending  = &eventErr{reason: "accept the message request first", code: http.StatusForbidden}
+)
+
+// authorizeEvent is the only place the sender of an incoming event comes from: the user
+// the session was authenticated as replaces whatever the client claims. It then checks the
+// user is a member of the conversation the event refers to, before anything is stored or routed.
+// It tells the client why when the event is refused.
+func (a *api) authorizeEvent(s *melody.Session, msgType string, payload Message) bool {
+	userID, ok := sessionUserID(s)
+	if !ok {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := a.checkEvent(ctx, userID, payload)
+	if err == nil {
+		return true
+	}
+
+	var denied *eventErr
+	if !errors.As(err, &denied) {
+		a.logger.Errorw("couldn't authorize event", "type", msgType, "user_id", userID.String(), "error", err.Error())
+		denied = &eventErr{reason: "couldn't authorize event", code: http.StatusInternalServerError}
+	} else {
+		a.logger.Warnw("event refused", "type", msgType, "user_id", userID.String(), "reason", denied.reason)
+	}
+
+	if chat, ok := payload.(*ChatMsg); ok {
+		writeJSONErr(s, &MessageErr{
+			TempID: chat.TempID,
+			Reason: denied.reason,
+		})
+		return false
+	}
+
+	writeJSONErr(s, &Err{
+		Reason: denied.reason,
+		Code:   denied.code,
+	})
+	return false
+}
+
+// checkEvent sets the sender of the event and checks what it refers to,
+// event types it doesn't know about are refused
+func (a *api) checkEvent(ctx context.Context, userID uuid.UUID, payload Message) error {
+	switch p := payload.(type) {
+	case *ChatMsg:
+		p.From = userID.String()
+		conversationID, err := a.eventConversation(ctx, userID, p.ConversationID, p.To)
+		if err != nil {
+			return err
+		}
+
+		blocked, err := a.storage.Blocks.IsBlockedInConversation(ctx, conversationID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return errEventBlocked
+		}
+
+		conversation, err := a.storage.Conversations.GetByID(ctx, conversationID)
+		if err != nil {
+			return err
+		}
+		// the recipient of a message request answers by accepting it, the requester
+		// gets a single message in until then, which the store makes sure of
+		if conversation.RequestPending && conversation.User2.Bytes == userID {
+			return errEventRequestPending
+		}
+
+		// handleChatMessage only looks at the conversation from here on
+		p.ConversationID = conversationID.String()
+		p.RequestPending = conversation.RequestPending
+		return nil
+	case *Typing:
+		p.From = userID.String()
+		_, err := a.eventConversation(ctx, userID, p.ConversationID, p.To)
+		return err
+	case *StoppedTyping:
+		p.From = userID.String()
+		_, err := a.eventConversation(ctx, userID, p.ConversationID, p.To)
+		return err
+	case *MarkMsgRead:
+		_, err := a.eventConversation(ctx, userID, p.ConversationID, "")
+		return err
+	case *EditMsg:
+		return a.checkMessageMember(ctx, userID, p.ID)
+	case *DeleteMsg:
+		return a.checkMessageMember(ctx, userID, p.ID)
+	case *React:
+		return a.checkMessageMember(ctx, userID, p.MessageID)
+	case *Sync, *AckReceived:
+		// only about the user's own events, the store skips messages of conversations they're not in
+		return nil
+	default:
+		return errEventNotAllowed
+	}
+}
+
+// eventConversation resolves the conversation an event goes to, by id or, for direct
+// conversations, by the other member, and checks the user is a member of it
+func (a *api) eventConversation(ctx context.Context, userID uuid.UUID, conversationID, peerID string) (uuid.UUID, error) {
+	if conversationID != "" {
+		id, err := uuid.Parse(conversationID)
+		if err != nil {
+			return uuid.UUID{}, &eventErr{reason: "invalid conversation UUID", code: http.StatusUnprocessableEntity}
+		}
+
+		isMember, err := a.storage.Conversations.IsMember(ctx, id, userID)
+		if err != nil {
+			return uuid.UUID{}, err
+		}
+		if !isMember {
+			return uuid.UUID{}, errEventNotMember
+		}
+		return id, nil
+	}
+
+	peerUUID, err := uuid.Parse(peerID)
+	if err != nil {
+		return uuid.UUID{}, &eventErr{reason: "invalid UUID", code: http.StatusUnprocessableEntity}
+	}
+
+	conversation, err := a.storage.Conversations.GetByMembers(ctx, queries.GetConversationByMembersParams{
+		User1: userID,
+		User2: peerUUID,
+	})
+	if err == store.ErrNotFound {
+		return uuid.UUID{}, errEventNoConversation
+	}
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return conversation.ID, nil
+}
+
+// checkMessageMember checks the user is a member of the conversation the message is in
+func (a *api) checkMessageMember(ctx context.Context, userID uuid.UUID, messageID string) error {
+	id, err := uuid.Parse(messageID)
+	if err != nil {
+		return &eventErr{reason: "invalid message UUID", code: http.StatusUnprocessableEntity}
+	}
+
+	msg, err := a.storage.Messages.GetByID(ctx, id)
+	if err == store.ErrNotFound {
+		return errEventMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	isMember, err := a.storage.Conversations.IsMember(ctx, msg.ConversationID, userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		// the message isn't theirs to know about
+		return errEventMessageNotFound
+	}
+	return nil
+}
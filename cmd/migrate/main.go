@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+	"github.com/myselfBZ/chatrix-v2/cmd/migrate/migrations"
+	"github.com/myselfBZ/chatrix-v2/internal/db"
+	"github.com/myselfBZ/chatrix-v2/internal/migrate"
+)
+
+const usage = `usage: migrate [flags] <command>
+
+commands:
+  up             apply every pending migration
+  down [N]       revert the last N migrations, 1 by default
+  status         list migrations and whether they were applied
+  goto V         migrate up or down to version V, 0 reverts everything
+  baseline V     mark migrations up to V as applied without running them,
+                 for databases that were migrated by hand
+  create NAME    add empty up and down files for a new migration
+
+flags:
+`
+
+func main() {
+	dbURL := flag.String("database", os.Getenv("DB"), "postgres connection string, $DB by default")
+	dir := flag.String("dir", migrations.Dir, "where create puts new migrations")
+	timeout := flag.Duration("timeout", 10*time.Minute, "give up after this long")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(*dbURL, *dir, *timeout, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dbURL, dir string, timeout time.Duration, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// create only touches files, it doesn't need a database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return fmt.Errorf("create takes the name of the migration")
+		}
+		up, down, err := migrate.Create(dir, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println("created", up)
+		fmt.Println("created", down)
+		return nil
+	}
+
+	if dbURL == "" {
+		return fmt.Errorf("no database, set DB or pass -database")
+	}
+
+	pool, err := db.New(db.Config{
+		Addr:     dbURL,
+		MaxConns: 2,
+	})
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	m, err := migrate.New(pool, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		ran, err := m.Up(ctx)
+		printRan("applied", ran)
+		return err
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return fmt.Errorf("down takes a positive number of migrations")
+			}
+		}
+		ran, err := m.Down(ctx, n)
+		printRan("reverted", ran)
+		return err
+	case "goto":
+		version, err := versionArg(args)
+		if err != nil {
+			return err
+		}
+		ran, err := m.Goto(ctx, version)
+		printRan("ran", ran)
+		return err
+	case "baseline":
+		version, err := versionArg(args)
+		if err != nil {
+			return err
+		}
+		ran, err := m.Baseline(ctx, version)
+		printRan("marked as applied", ran)
+		return err
+	case "status":
+		return printStatus(ctx, m)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func versionArg(args []string) (int64, error) {
+	if len(args) != 2 {
+		return 0, fmt.Errorf("%s takes a version", args[0])
+	}
+
+	version, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid version %q", args[1])
+	}
+	return version, nil
+}
+
+func printRan(verb string, ran []migrate.Migration) {
+	if len(ran) == 0 {
+		fmt.Println("nothing to do")
+		return
+	}
+
+	for _, m := range ran {
+		fmt.Printf("%s %03d_%s\n", verb, m.Version, m.Name)
+	}
+}
+
+func printStatus(ctx context.Context, m *migrate.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\t")
+	for _, s := range statuses {
+		appliedAt := "pending"
+		if s.Applied() {
+			appliedAt = s.AppliedAt.Local().Format(time.DateTime)
+		}
+		if s.Modified {
+			appliedAt += " (edited since)"
+		}
+		fmt.Fprintf(w, "%03d\t%s\t%s\t\n", s.Version, s.Name, appliedAt)
+	}
+	return w.Flush()
+}
@@ -1 +1 @@
-DROPT TABLE IF EXISTS users;
+DROP TABLE IF EXISTS users;
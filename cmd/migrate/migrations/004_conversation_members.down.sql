@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS conversation_members;
+
+DELETE FROM conversations WHERE is_group;
+
+ALTER TABLE conversations
+    DROP CONSTRAINT IF EXISTS direct_conversation_users,
+    DROP COLUMN IF EXISTS created_by,
+    DROP COLUMN IF EXISTS title,
+    DROP COLUMN IF EXISTS is_group,
+    ALTER COLUMN user1 SET NOT NULL,
+    ALTER COLUMN user2 SET NOT NULL;
@@ -0,0 +1,25 @@
+ALTER TABLE conversations
+    ADD COLUMN is_group BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN title VARCHAR(100),
+    ADD COLUMN created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    ALTER COLUMN user1 DROP NOT NULL,
+    ALTER COLUMN user2 DROP NOT NULL;
+
+-- direct conversations keep using user1/user2, groups only live in conversation_members
+ALTER TABLE conversations
+    ADD CONSTRAINT direct_conversation_users CHECK (is_group OR (user1 IS NOT NULL AND user2 IS NOT NULL));
+
+CREATE TABLE IF NOT EXISTS conversation_members (
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX conversation_members_user_idx ON conversation_members (user_id);
+
+INSERT INTO conversation_members (conversation_id, user_id)
+SELECT id, user1 FROM conversations
+UNION
+SELECT id, user2 FROM conversations
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+-- keyset pagination walks messages by (created_at, id) within a conversation
+CREATE INDEX IF NOT EXISTS messages_conversation_created_idx
+ON messages (conversation_id, created_at, id);
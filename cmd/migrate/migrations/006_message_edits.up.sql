@@ -0,0 +1,11 @@
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP WITH TIME ZONE;
+
+-- previous revisions of edited messages, the current one lives in messages.content
+CREATE TABLE IF NOT EXISTS message_edits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    edited_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX message_edits_message_idx ON message_edits (message_id, edited_at);
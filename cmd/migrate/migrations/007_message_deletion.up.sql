@@ -0,0 +1,10 @@
+-- messages deleted for everyone stay as tombstones so the history keeps its order
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- messages a user deleted just for themselves
+CREATE TABLE IF NOT EXISTS hidden_messages (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hidden_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, message_id)
+);
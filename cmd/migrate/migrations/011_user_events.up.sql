@@ -0,0 +1,17 @@
+-- every user has their own gapless sequence, so devices can tell what they missed
+CREATE TABLE IF NOT EXISTS user_event_counters (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    last_seq BIGINT NOT NULL
+);
+
+-- events replayed to devices that were offline when they happened
+CREATE TABLE IF NOT EXISTS user_events (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    event_type VARCHAR(32) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, seq)
+);
+
+CREATE INDEX user_events_created_at_idx ON user_events (created_at);
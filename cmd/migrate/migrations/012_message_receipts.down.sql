@@ -0,0 +1,10 @@
+ALTER TABLE messages ADD COLUMN is_read BOOLEAN DEFAULT FALSE;
+
+UPDATE messages m
+SET is_read = TRUE
+WHERE EXISTS (
+    SELECT 1 FROM message_receipts r
+    WHERE r.message_id = m.id AND r.read_at IS NOT NULL
+);
+
+DROP TABLE IF EXISTS message_receipts;
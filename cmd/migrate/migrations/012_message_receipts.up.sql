@@ -0,0 +1,22 @@
+-- a row per recipient once the message reached one of their devices,
+-- no row means the message is only stored on the server
+CREATE TABLE IF NOT EXISTS message_receipts (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    delivered_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX message_receipts_user_idx ON message_receipts (user_id);
+
+-- is_read only knew about the other side of a direct conversation
+INSERT INTO message_receipts (message_id, user_id, delivered_at, read_at)
+SELECT m.id, cm.user_id, m.created_at, m.created_at
+FROM messages m
+JOIN conversation_members cm
+    ON cm.conversation_id = m.conversation_id AND cm.user_id != m.sender_id
+WHERE m.is_read
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE messages DROP COLUMN is_read;
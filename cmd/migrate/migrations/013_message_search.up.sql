@@ -0,0 +1,5 @@
+-- 'simple' doesn't stem, people chat in more than one language
+ALTER TABLE messages
+    ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED;
+
+CREATE INDEX messages_search_idx ON messages USING GIN (search_vector);
@@ -0,0 +1,26 @@
+-- a session is a login on one device, it shares its id with the family of refresh tokens
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_name VARCHAR(100),
+    user_agent TEXT,
+    ip_address VARCHAR(45),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX sessions_user_idx ON sessions (user_id);
+
+INSERT INTO sessions (id, user_id, created_at, last_used_at, revoked_at)
+SELECT
+    family_id,
+    MIN(user_id::text)::uuid,
+    MIN(created_at),
+    MAX(created_at),
+    CASE WHEN BOOL_AND(revoked_at IS NOT NULL) THEN MAX(revoked_at) END
+FROM refresh_tokens
+GROUP BY family_id;
+
+ALTER TABLE refresh_tokens
+    ADD CONSTRAINT refresh_tokens_family_fk FOREIGN KEY (family_id) REFERENCES sessions(id) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS broker_payloads;
+DROP TABLE IF EXISTS user_presence;
+DROP TABLE IF EXISTS api_nodes;
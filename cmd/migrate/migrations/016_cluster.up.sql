@@ -0,0 +1,24 @@
+-- Every running API process, a node that stops sending heartbeats is considered gone
+CREATE TABLE IF NOT EXISTS api_nodes (
+    id UUID PRIMARY KEY,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Which nodes a user has websocket connections on
+CREATE TABLE IF NOT EXISTS user_presence (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    node_id UUID NOT NULL REFERENCES api_nodes(id) ON DELETE CASCADE,
+    connected_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, node_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_presence_node_idx ON user_presence (node_id);
+
+-- NOTIFY payloads are capped at 8000 bytes, bigger broker messages are parked here
+-- and only their id goes through the channel
+CREATE TABLE IF NOT EXISTS broker_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+ALTER TABLE conversations DROP COLUMN IF EXISTS request_pending;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS message_privacy_values;
+ALTER TABLE users DROP COLUMN IF EXISTS message_privacy;
+DROP TABLE IF EXISTS user_blocks;
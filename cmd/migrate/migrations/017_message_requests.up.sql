@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blocker_id, blocked_id),
+    CONSTRAINT no_self_block CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX user_blocks_blocked_idx ON user_blocks (blocked_id);
+
+-- who can start a direct conversation with the user: everyone, everyone but strangers
+-- go through a message request first, or only the people in their contacts
+ALTER TABLE users
+    ADD COLUMN message_privacy VARCHAR(10) NOT NULL DEFAULT 'everyone',
+    ADD CONSTRAINT message_privacy_values CHECK (message_privacy IN ('everyone', 'requests', 'contacts'));
+
+-- a direct conversation a stranger started, user1, that user2 hasn't accepted yet
+ALTER TABLE conversations
+    ADD COLUMN request_pending BOOLEAN NOT NULL DEFAULT FALSE;
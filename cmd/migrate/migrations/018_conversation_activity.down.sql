@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS conversations_activity_idx;
+ALTER TABLE conversations DROP COLUMN IF EXISTS last_activity_at;
@@ -0,0 +1,13 @@
+-- when the last message was sent, or when the conversation was created before it had any,
+-- the conversation list is sorted by it
+ALTER TABLE conversations
+    ADD COLUMN last_activity_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE conversations c
+SET last_activity_at = COALESCE(
+    (SELECT MAX(m.created_at) FROM messages m WHERE m.conversation_id = c.id),
+    c.created_at,
+    c.last_activity_at
+);
+
+CREATE INDEX conversations_activity_idx ON conversations (last_activity_at DESC, id DESC);
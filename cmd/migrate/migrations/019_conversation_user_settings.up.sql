@@ -0,0 +1,15 @@
+-- what each member did with a conversation, only for the ones they changed anything on
+CREATE TABLE IF NOT EXISTS conversation_user_settings (
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- archived until the conversation has activity after this
+    archived_at TIMESTAMP WITH TIME ZONE,
+    -- pinned conversations come first, 1 is the top
+    pin_position INTEGER,
+    muted_at TIMESTAMP WITH TIME ZONE,
+    -- null while muted means indefinitely
+    muted_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX conversation_user_settings_user_idx ON conversation_user_settings (user_id);
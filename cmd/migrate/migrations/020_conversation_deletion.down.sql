@@ -0,0 +1,5 @@
+ALTER TABLE conversations
+    DROP COLUMN IF EXISTS delete_requested_at,
+    DROP COLUMN IF EXISTS delete_requested_by;
+
+ALTER TABLE conversation_user_settings DROP COLUMN IF EXISTS deleted_at;
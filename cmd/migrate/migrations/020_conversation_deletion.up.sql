@@ -0,0 +1,8 @@
+-- deleted for the user: hidden from their list until the conversation has activity after this,
+-- the messages up to it are hidden for them alone
+ALTER TABLE conversation_user_settings ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- a member asked to delete a direct conversation for both of them, it's deleted once the other one asks too
+ALTER TABLE conversations
+    ADD COLUMN delete_requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN delete_requested_at TIMESTAMP WITH TIME ZONE;
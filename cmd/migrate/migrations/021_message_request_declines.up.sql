@@ -0,0 +1,8 @@
+-- the last time the recipient declined a message request of the requester,
+-- the requester has to wait a while before sending another one
+CREATE TABLE IF NOT EXISTS message_request_declines (
+    requester_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    declined_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (requester_id, recipient_id)
+);
@@ -0,0 +1 @@
+ALTER TABLE conversation_user_settings DROP COLUMN IF EXISTS cleared_at;
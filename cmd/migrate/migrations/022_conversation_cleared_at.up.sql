@@ -0,0 +1,2 @@
+-- cleared for the user: the messages up to this are hidden for them alone
+ALTER TABLE conversation_user_settings ADD COLUMN cleared_at TIMESTAMP WITH TIME ZONE;
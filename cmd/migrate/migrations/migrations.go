@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations so the binaries don't need the files at runtime
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
+
+// Dir is where the migrations live relative to the repository root, new ones are created there
+const Dir = "cmd/migrate/migrations"
@@ -3,10 +3,13 @@ package auth
 import "github.com/golang-jwt/jwt/v5"
 
 type Authenticator interface {
-	GenerateTokenPair(userID string ,customClaims map[string]any) (*TokenPair, error)
+	// familyID ties the refresh token to the login it descends from
+	GenerateTokenPair(userID, familyID string, customClaims map[string]any) (*TokenPair, error)
 	ValidateAccessToken(tokenString string) (*jwt.Token, error)
 	ValidateRefreshToken(tokenString string) (*jwt.Token, error)
 	ExtractUserID(token *jwt.Token) (string, error)
+	// ExtractRefreshTokenIDs returns the jti and the family of a refresh token
+	ExtractRefreshTokenIDs(token *jwt.Token) (tokenID, familyID string, err error)
 	GenerateAccessToken(userID string) (string, error)
 }
 
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type JWTAuthenticator struct {
@@ -27,9 +28,15 @@ func NewJWTAuthenticator(accessSecret, refreshSecret, aud, iss string) Authentic
 type TokenPair struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string	`json:"refresh_token"`
+	// jti of the refresh token, for keeping track of it server side
+	RefreshTokenID   string    `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
 }
 
-func (a *JWTAuthenticator) GenerateTokenPair(userID string, customClaims map[string]any) (*TokenPair, error) {
+// RefreshTokenTTL is how long a refresh token, and so an idle session, lasts
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
+func (a *JWTAuthenticator) GenerateTokenPair(userID, familyID string, customClaims map[string]any) (*TokenPair, error) {
 	accessClaims := jwt.MapClaims{
 		"sub": userID,
 		"aud": a.aud,
@@ -48,13 +55,17 @@ func (a *JWTAuthenticator) GenerateTokenPair(userID string, customClaims map[str
 	}
 	
 	// Refresh token (long-lived: 7 days)
+	refreshTokenID := uuid.NewString()
+	refreshExpiresAt := time.Now().Add(RefreshTokenTTL)
 	refreshClaims := jwt.MapClaims{
 		"sub": userID,
 		"aud": a.aud,
 		"iss": a.iss,
-		"exp": time.Now().Add(7 * 24 * time.Hour).Unix(),
+		"exp": refreshExpiresAt.Unix(),
 		"iat": time.Now().Unix(),
 		"type": "refresh",
+		"jti": refreshTokenID,
+		"fam": familyID,
 	}
 	
 	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
@@ -64,8 +75,10 @@ func (a *JWTAuthenticator) GenerateTokenPair(userID string, customClaims map[str
 	}
 	
 	return &TokenPair{
-		AccessToken:  accessTokenString,
-		RefreshToken: refreshTokenString,
+		AccessToken:      accessTokenString,
+		RefreshToken:     refreshTokenString,
+		RefreshTokenID:   refreshTokenID,
+		RefreshExpiresAt: refreshExpiresAt,
 	}, nil
 }
 
@@ -150,3 +163,21 @@ func (a *JWTAuthenticator) ExtractUserID(token *jwt.Token) (string, error) {
 	return userID, nil
 }
 
+func (a *JWTAuthenticator) ExtractRefreshTokenIDs(token *jwt.Token) (string, string, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", fmt.Errorf("invalid claims")
+	}
+
+	tokenID, ok := claims["jti"].(string)
+	if !ok {
+		return "", "", fmt.Errorf("invalid token ID in token")
+	}
+
+	familyID, ok := claims["fam"].(string)
+	if !ok {
+		return "", "", fmt.Errorf("invalid family ID in token")
+	}
+
+	return tokenID, familyID, nil
+}
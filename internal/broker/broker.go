@@ -0,0 +1,53 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// Handler receives the payload of a message published on a channel it subscribed to
+type Handler func(payload []byte)
+
+// Broker passes messages between API nodes. Every subscriber of a channel,
+// on every node including the one that published, gets every message published on it.
+type Broker interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	// Subscribe has to happen before Run
+	Subscribe(channel string, handler Handler)
+	// Run delivers messages until ctx is done
+	Run(ctx context.Context) error
+}
+
+// MemoryBroker only reaches the node it runs on, for running a single API process
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{handlers: make(map[string][]Handler)}
+}
+
+// Publish hands the payload to the subscribers right away, before returning
+func (b *MemoryBroker) Publish(ctx context.Context, channel string, payload []byte) error {
+	b.mu.RLock()
+	handlers := b.handlers[channel]
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(channel string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[channel] = append(b.handlers[channel], handler)
+}
+
+func (b *MemoryBroker) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,181 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+	"go.uber.org/zap"
+)
+
+const (
+	// NOTIFY payloads have to be shorter than 8000 bytes
+	maxNotifyPayload = 7900
+
+	// the first byte of every notification says what follows
+	inlinePrefix    = 'i'
+	referencePrefix = 'r'
+
+	// parked payloads are fetched right after the notification, they're not needed for long
+	payloadRetention = time.Minute
+)
+
+// PostgresBroker relays messages through LISTEN/NOTIFY, so API nodes that share
+// a database reach each other without any other infrastructure.
+// Messages published while a node is reconnecting its listener are lost to it,
+// durable events are still replayed by SYNC.
+type PostgresBroker struct {
+	pool   *pgxpool.Pool
+	q      *queries.Queries
+	logger *zap.SugaredLogger
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewPostgresBroker(pool *pgxpool.Pool, logger *zap.SugaredLogger) *PostgresBroker {
+	return &PostgresBroker{
+		pool:     pool,
+		q:        queries.New(pool),
+		logger:   logger,
+		handlers: make(map[string][]Handler),
+	}
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, channel string, payload []byte) error {
+	notification := make([]byte, 0, len(payload)+1)
+	notification = append(notification, inlinePrefix)
+	notification = append(notification, payload...)
+
+	if len(notification) > maxNotifyPayload {
+		id, err := b.q.CreateBrokerPayload(ctx, string(payload))
+		if err != nil {
+			return fmt.Errorf("parking broker payload: %w", err)
+		}
+		notification = strconv.AppendInt([]byte{referencePrefix}, id, 10)
+	}
+
+	_, err := b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", channel, string(notification))
+	return err
+}
+
+func (b *PostgresBroker) Subscribe(channel string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[channel] = append(b.handlers[channel], handler)
+}
+
+// Run listens on a connection of its own and reconnects when it drops
+func (b *PostgresBroker) Run(ctx context.Context) error {
+	go b.prunePayloads(ctx)
+
+	backoff := time.Second
+	for {
+		err := b.listen(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		b.logger.Errorw("broker lost its listener connection, reconnecting", "error", err, "in", backoff.String())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (b *PostgresBroker) listen(ctx context.Context) error {
+	// a connection outside of the pool, it's blocked waiting for notifications all the time
+	conn, err := pgx.ConnectConfig(ctx, b.pool.Config().ConnConfig.Copy())
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	b.mu.RLock()
+	channels := make([]string, 0, len(b.handlers))
+	for channel := range b.handlers {
+		channels = append(channels, channel)
+	}
+	b.mu.RUnlock()
+
+	for _, channel := range channels {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return err
+		}
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		b.dispatch(ctx, notification.Channel, notification.Payload)
+	}
+}
+
+func (b *PostgresBroker) dispatch(ctx context.Context, channel, notification string) {
+	if notification == "" {
+		return
+	}
+
+	var payload []byte
+	switch notification[0] {
+	case inlinePrefix:
+		payload = []byte(notification[1:])
+	case referencePrefix:
+		id, err := strconv.ParseInt(notification[1:], 10, 64)
+		if err != nil {
+			b.logger.Errorw("invalid broker payload reference", "channel", channel, "reference", notification)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		parked, err := b.q.GetBrokerPayload(ctx, id)
+		cancel()
+		if err != nil {
+			b.logger.Errorw("couldn't load broker payload", "channel", channel, "id", id, "error", err.Error())
+			return
+		}
+		payload = []byte(parked)
+	default:
+		b.logger.Errorw("unknown broker notification", "channel", channel)
+		return
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers[channel]
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+}
+
+func (b *PostgresBroker) prunePayloads(ctx context.Context) {
+	ticker := time.NewTicker(payloadRetention)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pruneCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		_, err := b.q.DeleteBrokerPayloadsBefore(pruneCtx, time.Now().Add(-payloadRetention))
+		cancel()
+		if err != nil {
+			b.logger.Errorw("couldn't prune broker payloads", "error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// Who decides when a member deletes a direct conversation for both of them
+const (
+	// the other member has to ask for it too
+	DeleteForBothConsent = "consent"
+	// either member deletes it right away
+	DeleteForBothAnyone = "anyone"
+	// it can only be deleted for oneself
+	DeleteForBothNever = "never"
+)
+
+// insecureSecret is what the secrets default to so the API runs locally without any setup,
+// production refuses to start with it
+const insecureSecret = "something"
+
+// minSecretLength is the shortest secret production accepts, 32 bytes matches the HS256 key size
+const minSecretLength = 32
+
+// Config is everything the API can be tuned with. Values come from the defaults,
+// then the optional YAML file, then the environment, the environment wins.
+type Config struct {
+	// development or production, production refuses insecure settings
+	Env           string            `yaml:"env" env:"APP_ENV"`
+	Server        ServerConfig      `yaml:"server"`
+	DB            DBConfig          `yaml:"db"`
+	Auth          AuthConfig        `yaml:"auth"`
+	WebSocket     WebSocketConfig   `yaml:"websocket"`
+	Broker        BrokerConfig      `yaml:"broker"`
+	Blob          BlobConfig        `yaml:"blob"`
+	Attachments   AttachmentsConfig `yaml:"attachments"`
+	History       PagingConfig      `yaml:"history"`
+	Search        PagingConfig      `yaml:"search"`
+	Conversations PagingConfig      `yaml:"conversations"`
+	Messages      MessagesConfig    `yaml:"messages"`
+	Sync          SyncConfig        `yaml:"sync"`
+}
+
+type ServerConfig struct {
+	Port int `yaml:"port" env:"PORT"`
+	// origins allowed to make credentialed requests, comma separated in the environment
+	AllowedOrigins []string `yaml:"allowed_origins" env:"CORS_ORIGINS"`
+	// the deployed front end, added to the allowed origins
+	FrontendURL string `yaml:"frontend_url" env:"FRONTEND_URL"`
+	// how long shutting down may take, websockets are drained within it
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// bearer token for /debug/status and /metrics, without one they're only served outside of production
+	DebugToken string `yaml:"debug_token" env:"DEBUG_TOKEN"`
+}
+
+type DBConfig struct {
+	URL         string        `yaml:"url" env:"DB"`
+	MaxConns    int32         `yaml:"max_conns" env:"DB_MAX_CONNS"`
+	MinConns    int32         `yaml:"min_conns" env:"DB_MIN_CONNS"`
+	MaxIdleTime time.Duration `yaml:"max_idle_time" env:"DB_MAX_IDLE_TIME"`
+	// apply pending migrations on boot, the advisory lock keeps replicas from racing
+	AutoMigrate bool `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE"`
+}
+
+type AuthConfig struct {
+	AccessSecret  string `yaml:"access_secret" env:"JWT_ACCESS_SECRET"`
+	RefreshSecret string `yaml:"refresh_secret" env:"JWT_REFRESH_SECRET"`
+	Issuer        string `yaml:"issuer" env:"JWT_ISSUER"`
+	Audience      string `yaml:"audience" env:"JWT_AUDIENCE"`
+	// domain of the refresh token cookie, empty scopes it to the API host
+	CookieDomain string `yaml:"cookie_domain" env:"COOKIE_DOMAIN"`
+}
+
+type WebSocketConfig struct {
+	ReadBufferSize  int   `yaml:"read_buffer_size" env:"WS_READ_BUFFER_SIZE"`
+	WriteBufferSize int   `yaml:"write_buffer_size" env:"WS_WRITE_BUFFER_SIZE"`
+	MaxMessageSize  int64 `yaml:"max_message_size" env:"WS_MAX_MESSAGE_SIZE"`
+}
+
+type BrokerConfig struct {
+	// memory for a single API process, postgres when running several behind a load balancer
+	Backend string `yaml:"backend" env:"BROKER_BACKEND"`
+}
+
+type BlobConfig struct {
+	// local or s3
+	Backend string   `yaml:"backend" env:"BLOB_BACKEND"`
+	Dir     string   `yaml:"dir" env:"BLOB_DIR"`
+	S3      S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Bucket    string `yaml:"bucket" env:"S3_BUCKET"`
+	Region    string `yaml:"region" env:"S3_REGION"`
+	AccessKey string `yaml:"access_key" env:"S3_ACCESS_KEY"`
+	SecretKey string `yaml:"secret_key" env:"S3_SECRET_KEY"`
+}
+
+type AttachmentsConfig struct {
+	// size limits in bytes, per kind of file
+	MaxImageSize int64 `yaml:"max_image_size" env:"ATTACHMENT_MAX_IMAGE_SIZE"`
+	MaxVideoSize int64 `yaml:"max_video_size" env:"ATTACHMENT_MAX_VIDEO_SIZE"`
+	MaxAudioSize int64 `yaml:"max_audio_size" env:"ATTACHMENT_MAX_AUDIO_SIZE"`
+	MaxFileSize  int64 `yaml:"max_file_size" env:"ATTACHMENT_MAX_FILE_SIZE"`
+	// how long a download URL stays valid
+	URLTTL        time.Duration `yaml:"url_ttl" env:"ATTACHMENT_URL_TTL"`
+	SigningSecret string        `yaml:"signing_secret" env:"ATTACHMENT_SIGNING_SECRET"`
+}
+
+type PagingConfig struct {
+	DefaultPageSize int `yaml:"default_page_size"`
+	MaxPageSize     int `yaml:"max_page_size"`
+}
+
+type MessagesConfig struct {
+	// how long the sender can edit a message for
+	EditWindow time.Duration `yaml:"edit_window" env:"MESSAGE_EDIT_WINDOW"`
+	// consent, anyone or never, see DeleteForBothConsent
+	DeleteForBoth string `yaml:"delete_for_both" env:"MESSAGE_DELETE_FOR_BOTH"`
+}
+
+type SyncConfig struct {
+	// events per SYNC_EVENTS frame
+	PageSize int `yaml:"page_size" env:"SYNC_PAGE_SIZE"`
+	// how long events are kept for devices that are offline
+	Retention time.Duration `yaml:"retention" env:"SYNC_RETENTION"`
+}
+
+func Default() Config {
+	return Config{
+		Env: Development,
+		Server: ServerConfig{
+			Port:            8080,
+			ShutdownTimeout: 30 * time.Second,
+			AllowedOrigins: []string{
+				"http://localhost:5173",
+				"http://localhost:5174",
+			},
+		},
+		DB: DBConfig{
+			MaxConns:    15,
+			MinConns:    15,
+			MaxIdleTime: 15 * time.Minute,
+		},
+		Auth: AuthConfig{
+			AccessSecret:  insecureSecret,
+			RefreshSecret: insecureSecret,
+			Issuer:        "chatrix",
+			Audience:      "chatrix",
+			CookieDomain:  "localhost",
+		},
+		WebSocket: WebSocketConfig{
+			ReadBufferSize:  1024 * 10,
+			WriteBufferSize: 1024 * 10,
+			MaxMessageSize:  1024 * 10,
+		},
+		Broker: BrokerConfig{
+			Backend: "memory",
+		},
+		Blob: BlobConfig{
+			Backend: "local",
+			Dir:     "./uploads",
+		},
+		Attachments: AttachmentsConfig{
+			MaxImageSize:  10 << 20,
+			MaxVideoSize:  100 << 20,
+			MaxAudioSize:  25 << 20,
+			MaxFileSize:   25 << 20,
+			URLTTL:        time.Hour,
+			SigningSecret: insecureSecret,
+		},
+		History: PagingConfig{
+			DefaultPageSize: 50,
+			MaxPageSize:     200,
+		},
+		Search: PagingConfig{
+			DefaultPageSize: 20,
+			MaxPageSize:     50,
+		},
+		Conversations: PagingConfig{
+			DefaultPageSize: 30,
+			MaxPageSize:     100,
+		},
+		Messages: MessagesConfig{
+			EditWindow:    15 * time.Minute,
+			DeleteForBoth: DeleteForBothConsent,
+		},
+		Sync: SyncConfig{
+			PageSize:  200,
+			Retention: 30 * 24 * time.Hour,
+		},
+	}
+}
+
+// Load builds the config from the defaults, the YAML file at path if there is one
+// and the environment, then validates it
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	// unknown keys are most likely typos, better to fail than to silently ignore them
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Origins are the allowed origins plus the front end
+func (c ServerConfig) Origins() []string {
+	if c.FrontendURL == "" {
+		return c.AllowedOrigins
+	}
+	return append(c.AllowedOrigins[:len(c.AllowedOrigins):len(c.AllowedOrigins)], c.FrontendURL)
+}
+
+func (c *Config) IsProduction() bool {
+	return c.Env == Production
+}
+
+// Validate reports every problem with the config at once
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Env == Development || c.Env == Production, "env must be %s or %s, got %q", Development, Production, c.Env)
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(len(c.Server.Origins()) > 0, "server.allowed_origins or server.frontend_url must be set")
+
+	check(c.DB.URL != "", "db.url is required")
+	check(c.DB.MaxConns > 0, "db.max_conns must be positive")
+	check(c.DB.MinConns >= 0 && c.DB.MinConns <= c.DB.MaxConns, "db.min_conns must be between 0 and db.max_conns")
+	check(c.DB.MaxIdleTime >= 0, "db.max_idle_time can't be negative")
+
+	check(c.Auth.AccessSecret != "", "auth.access_secret is required")
+	check(c.Auth.RefreshSecret != "", "auth.refresh_secret is required")
+	check(c.Auth.Issuer != "", "auth.issuer is required")
+	check(c.Auth.Audience != "", "auth.audience is required")
+
+	check(c.WebSocket.ReadBufferSize > 0, "websocket.read_buffer_size must be positive")
+	check(c.WebSocket.WriteBufferSize > 0, "websocket.write_buffer_size must be positive")
+	check(c.WebSocket.MaxMessageSize > 0, "websocket.max_message_size must be positive")
+
+	check(c.Broker.Backend == "memory" || c.Broker.Backend == "postgres", "broker.backend must be memory or postgres, got %q", c.Broker.Backend)
+
+	switch c.Blob.Backend {
+	case "local":
+		check(c.Blob.Dir != "", "blob.dir is required for the local backend")
+	case "s3":
+		s3 := c.Blob.S3
+		check(s3.Endpoint != "" && s3.Bucket != "" && s3.Region != "" && s3.AccessKey != "" && s3.SecretKey != "",
+			"blob.s3 endpoint, bucket, region and credentials are required for the s3 backend")
+	default:
+		check(false, "blob.backend must be local or s3, got %q", c.Blob.Backend)
+	}
+
+	check(c.Attachments.MaxImageSize > 0 && c.Attachments.MaxVideoSize > 0 &&
+		c.Attachments.MaxAudioSize > 0 && c.Attachments.MaxFileSize > 0, "attachments size limits must be positive")
+	check(c.Attachments.URLTTL > 0, "attachments.url_ttl must be positive")
+	check(c.Attachments.SigningSecret != "", "attachments.signing_secret is required")
+
+	check(validPaging(c.History), "history page sizes must be positive and default_page_size at most max_page_size")
+	check(validPaging(c.Search), "search page sizes must be positive and default_page_size at most max_page_size")
+	check(validPaging(c.Conversations), "conversations page sizes must be positive and default_page_size at most max_page_size")
+	check(c.Messages.EditWindow > 0, "messages.edit_window must be positive")
+	check(c.Messages.DeleteForBoth == DeleteForBothConsent || c.Messages.DeleteForBoth == DeleteForBothAnyone || c.Messages.DeleteForBoth == DeleteForBothNever,
+		"messages.delete_for_both must be %s, %s or %s, got %q", DeleteForBothConsent, DeleteForBothAnyone, DeleteForBothNever, c.Messages.DeleteForBoth)
+	check(c.Sync.PageSize > 0, "sync.page_size must be positive")
+	check(c.Sync.Retention > 0, "sync.retention must be positive")
+
+	if c.IsProduction() {
+		secrets := []struct{ name, value string }{
+			{"auth.access_secret", c.Auth.AccessSecret},
+			{"auth.refresh_secret", c.Auth.RefreshSecret},
+			{"attachments.signing_secret", c.Attachments.SigningSecret},
+		}
+		for _, secret := range secrets {
+			check(secret.value != insecureSecret, "%s is still the default, set a real secret in production", secret.name)
+			check(len(secret.value) >= minSecretLength, "%s must be at least %d characters in production", secret.name, minSecretLength)
+		}
+		// a leaked access secret shouldn't be enough to forge refresh tokens
+		check(c.Auth.AccessSecret != c.Auth.RefreshSecret, "auth.access_secret and auth.refresh_secret must differ in production")
+		check(c.Auth.CookieDomain != "localhost", "auth.cookie_domain can't be localhost in production")
+		check(c.Server.FrontendURL != "", "server.frontend_url is required in production")
+	}
+
+	return errors.Join(errs...)
+}
+
+func validPaging(c PagingConfig) bool {
+	return c.DefaultPageSize > 0 && c.MaxPageSize > 0 && c.DefaultPageSize <= c.MaxPageSize
+}
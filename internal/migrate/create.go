@@ -0,0 +1,45 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Create writes empty up and down files for a new migration to dir,
+// numbered after the last one there
+func Create(dir, name string) (upPath, downPath string, err error) {
+	name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"))
+	if !namePattern.MatchString(name) {
+		return "", "", fmt.Errorf("migration names may only contain letters, digits and underscores")
+	}
+
+	migrations, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+
+	var next int64 = 1
+	if len(migrations) > 0 {
+		next = migrations[len(migrations)-1].Version + 1
+	}
+
+	base := fmt.Sprintf("%03d_%s", next, name)
+	upPath = filepath.Join(dir, base+".up.sql")
+	downPath = filepath.Join(dir, base+".down.sql")
+
+	// O_EXCL so a file that somehow exists is never overwritten
+	for _, path := range []string{upPath, downPath} {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return "", "", err
+		}
+		f.Close()
+	}
+
+	return upPath, downPath, nil
+}
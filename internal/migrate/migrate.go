@@ -0,0 +1,419 @@
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockID keys the advisory lock, only one runner migrates a database at a time
+const lockID int64 = 7_254_919_305_117
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+var (
+	ErrNoDownMigration  = errors.New("migration has no down SQL")
+	ErrUnknownVersion   = errors.New("no migration with this version")
+	ErrChecksumMismatch = errors.New("applied migrations were edited")
+)
+
+// Migration is a pair of NNN_name.up.sql and NNN_name.down.sql files
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// sha256 of the up file, an applied migration must not change anymore
+	Checksum string
+}
+
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	// the up file changed since it was applied
+	Modified bool
+}
+
+func (s Status) Applied() bool {
+	return s.AppliedAt != nil
+}
+
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+func New(pool *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		pool:       pool,
+		migrations: migrations,
+	}, nil
+}
+
+// Load reads the migrations at the root of fsys, sorted by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	hasUp := make(map[int64]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			hasUp[version] = true
+			m.Up = string(content)
+			m.Checksum = checksum(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if !hasUp[m.Version] {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Latest is the highest version there is a migration for, 0 if there are none
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down reverts the last n applied migrations
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.withLock(ctx, func(conn *pgx.Conn, applied map[int64]appliedMigration) error {
+		for _, migration := range m.lastApplied(applied, n) {
+			if err := revert(ctx, conn, migration); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Goto migrates up or down until version is the last one applied, 0 reverts everything.
+// It returns the migrations that were applied or reverted, in the order they ran.
+func (m *Migrator) Goto(ctx context.Context, version int64) ([]Migration, error) {
+	if version != 0 && !slices.ContainsFunc(m.migrations, func(mig Migration) bool { return mig.Version == version }) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	var ran []Migration
+	err := m.withLock(ctx, func(conn *pgx.Conn, applied map[int64]appliedMigration) error {
+		toRevert, toApply := m.steps(applied, version)
+		for _, migration := range toRevert {
+			if err := revert(ctx, conn, migration); err != nil {
+				return err
+			}
+			ran = append(ran, migration)
+		}
+
+		for _, migration := range toApply {
+			if err := apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			ran = append(ran, migration)
+		}
+		return nil
+	})
+	return ran, err
+}
+
+// lastApplied is what Down reverts, the n newest applied migrations, newest first
+func (m *Migrator) lastApplied(applied map[int64]appliedMigration, n int) []Migration {
+	var last []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(last) < n; i-- {
+		if _, ok := applied[m.migrations[i].Version]; ok {
+			last = append(last, m.migrations[i])
+		}
+	}
+	return last
+}
+
+// steps is what Goto runs: the applied migrations above version reverted newest first,
+// then the pending ones up to it applied oldest first
+func (m *Migrator) steps(applied map[int64]appliedMigration, version int64) (toRevert, toApply []Migration) {
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			toRevert = append(toRevert, migration)
+		}
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			toApply = append(toApply, migration)
+		}
+	}
+	return toRevert, toApply
+}
+
+// Baseline marks every migration up to version as applied without running it,
+// for databases that were migrated by hand before there was a runner
+func (m *Migrator) Baseline(ctx context.Context, version int64) ([]Migration, error) {
+	var marked []Migration
+	err := m.withLock(ctx, func(conn *pgx.Conn, applied map[int64]appliedMigration) error {
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok || migration.Version > version {
+				continue
+			}
+
+			if err := record(ctx, conn, migration); err != nil {
+				return err
+			}
+			marked = append(marked, migration)
+		}
+		return nil
+	})
+	return marked, err
+}
+
+// Status lists every migration and whether it was applied, edited ones are reported instead of failing
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if err := ensureTable(ctx, conn.Conn()); err != nil {
+		return nil, err
+	}
+
+	applied, err := loadApplied(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration}
+		if a, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &a.appliedAt
+			statuses[i].Modified = a.checksum != migration.Checksum
+		}
+	}
+	return statuses, nil
+}
+
+// Pending lists the migrations that haven't been applied yet, without changing anything
+// in the database, for checking the schema is what the binary expects
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	applied, err := loadApplied(ctx, conn.Conn())
+	if err != nil {
+		// nothing has been migrated by the runner yet
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "42P01" {
+			return nil, err
+		}
+		applied = nil
+	}
+
+	if err := m.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// withLock runs fn on a single connection holding the advisory lock,
+// after making sure none of the applied migrations were edited
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgx.Conn, applied map[int64]appliedMigration) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	// waits for any other runner to finish
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	if err := ensureTable(ctx, conn.Conn()); err != nil {
+		return err
+	}
+
+	applied, err := loadApplied(ctx, conn.Conn())
+	if err != nil {
+		return err
+	}
+
+	if err := m.verify(applied); err != nil {
+		return err
+	}
+
+	return fn(conn.Conn(), applied)
+}
+
+func (m *Migrator) verify(applied map[int64]appliedMigration) error {
+	var edited []string
+	for _, migration := range m.migrations {
+		if a, ok := applied[migration.Version]; ok && a.checksum != migration.Checksum {
+			edited = append(edited, fmt.Sprintf("%d_%s", migration.Version, migration.Name))
+		}
+	}
+
+	if len(edited) > 0 {
+		return fmt.Errorf("%w: %v, add a new migration instead", ErrChecksumMismatch, edited)
+	}
+	return nil
+}
+
+func ensureTable(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	return err
+}
+
+func loadApplied(ctx context.Context, conn *pgx.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.Query(ctx, "SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// apply runs the migration and records it in one transaction, a failed migration leaves nothing behind
+func apply(ctx context.Context, conn *pgx.Conn, migration Migration) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		// no arguments, so the file runs over the simple protocol and may hold several statements
+		if _, err := tx.Exec(ctx, migration.Up); err != nil {
+			return fmt.Errorf("applying %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		return insertVersion(ctx, tx, migration)
+	})
+}
+
+func revert(ctx context.Context, conn *pgx.Conn, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, migration.Version, migration.Name)
+	}
+
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, migration.Down); err != nil {
+			return fmt.Errorf("reverting %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+		return err
+	})
+}
+
+func record(ctx context.Context, conn *pgx.Conn, migration Migration) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		return insertVersion(ctx, tx, migration)
+	})
+}
+
+func insertVersion(ctx context.Context, tx pgx.Tx, migration Migration) error {
+	_, err := tx.Exec(ctx,
+		"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+		migration.Version, migration.Name, migration.Checksum,
+	)
+	return err
+}
@@ -0,0 +1,231 @@
+package migrate
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func versions(migrations []Migration) []int64 {
+	v := make([]int64, len(migrations))
+	for i, m := range migrations {
+		v[i] = m.Version
+	}
+	return v
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []int64
+		err      string
+	}{
+		{
+			name: "sorted by version, not by name",
+			fsys: fstest.MapFS{
+				"10_ten.up.sql":  file("SELECT 10;"),
+				"2_two.up.sql":   file("SELECT 2;"),
+				"2_two.down.sql": file("SELECT -2;"),
+				"001_one.up.sql": file("SELECT 1;"),
+			},
+			versions: []int64{1, 2, 10},
+		},
+		{
+			name: "other files are skipped",
+			fsys: fstest.MapFS{
+				"001_one.up.sql":   file("SELECT 1;"),
+				"migrations.go":    file("package migrations"),
+				"README.md":        file("notes"),
+				"002_Two.up.sql":   file("SELECT 2;"),
+				"sub/003_x.up.sql": file("SELECT 3;"),
+			},
+			versions: []int64{1},
+		},
+		{
+			name:     "empty",
+			fsys:     fstest.MapFS{},
+			versions: []int64{},
+		},
+		{
+			name: "down file without an up file",
+			fsys: fstest.MapFS{
+				"001_one.up.sql":   file("SELECT 1;"),
+				"002_two.down.sql": file("SELECT -2;"),
+			},
+			err: "migration 2_two has no up file",
+		},
+		{
+			name: "version used twice",
+			fsys: fstest.MapFS{
+				"001_one.up.sql":   file("SELECT 1;"),
+				"001_other.up.sql": file("SELECT 1;"),
+			},
+			err: "version 1 is used by both",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.fsys)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := versions(migrations); !slices.Equal(got, tt.versions) {
+				t.Errorf("got versions %v, want %v", got, tt.versions)
+			}
+		})
+	}
+}
+
+func TestLoadContent(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"001_one.up.sql":   file("CREATE TABLE one ();"),
+		"001_one.down.sql": file("DROP TABLE one;"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m := migrations[0]
+	if m.Name != "one" || m.Up != "CREATE TABLE one ();" || m.Down != "DROP TABLE one;" {
+		t.Errorf("got %+v", m)
+	}
+	if m.Checksum != checksum([]byte("CREATE TABLE one ();")) {
+		t.Errorf("checksum %s isn't the one of the up file", m.Checksum)
+	}
+}
+
+func testMigrator(versions ...int64) *Migrator {
+	m := &Migrator{}
+	for _, v := range versions {
+		m.migrations = append(m.migrations, Migration{Version: v, Checksum: "sum"})
+	}
+	return m
+}
+
+func appliedVersions(versions ...int64) map[int64]appliedMigration {
+	applied := make(map[int64]appliedMigration, len(versions))
+	for _, v := range versions {
+		applied[v] = appliedMigration{checksum: "sum"}
+	}
+	return applied
+}
+
+func TestSteps(t *testing.T) {
+	m := testMigrator(1, 2, 3, 4)
+
+	tests := []struct {
+		name     string
+		applied  []int64
+		version  int64
+		toRevert []int64
+		toApply  []int64
+	}{
+		{name: "up from nothing", applied: nil, version: 4, toApply: []int64{1, 2, 3, 4}},
+		{name: "up part of the way", applied: []int64{1}, version: 3, toApply: []int64{2, 3}},
+		{name: "down newest first", applied: []int64{1, 2, 3, 4}, version: 2, toRevert: []int64{4, 3}},
+		{name: "down to nothing", applied: []int64{1, 2, 3}, version: 0, toRevert: []int64{3, 2, 1}},
+		{name: "fills a gap", applied: []int64{1, 3}, version: 4, toApply: []int64{2, 4}},
+		{name: "down past a gap then up", applied: []int64{1, 3, 4}, version: 2, toRevert: []int64{4, 3}, toApply: []int64{2}},
+		{name: "already there", applied: []int64{1, 2}, version: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toRevert, toApply := m.steps(appliedVersions(tt.applied...), tt.version)
+			if got := versions(toRevert); !slices.Equal(got, tt.toRevert) {
+				t.Errorf("reverts %v, want %v", got, tt.toRevert)
+			}
+			if got := versions(toApply); !slices.Equal(got, tt.toApply) {
+				t.Errorf("applies %v, want %v", got, tt.toApply)
+			}
+		})
+	}
+}
+
+func TestStepsDownThenUp(t *testing.T) {
+	m := testMigrator(1, 2, 3)
+	applied := appliedVersions(1, 2, 3)
+
+	toRevert, toApply := m.steps(applied, 1)
+	if got := versions(toRevert); !slices.Equal(got, []int64{3, 2}) || len(toApply) != 0 {
+		t.Fatalf("going down reverts %v and applies %v", got, versions(toApply))
+	}
+	for _, migration := range toRevert {
+		delete(applied, migration.Version)
+	}
+
+	toRevert, toApply = m.steps(applied, 3)
+	if got := versions(toApply); !slices.Equal(got, []int64{2, 3}) || len(toRevert) != 0 {
+		t.Fatalf("going back up applies %v and reverts %v", got, versions(toRevert))
+	}
+}
+
+func TestLastApplied(t *testing.T) {
+	m := testMigrator(1, 2, 3, 4)
+
+	tests := []struct {
+		name    string
+		applied []int64
+		n       int
+		want    []int64
+	}{
+		{name: "newest first", applied: []int64{1, 2, 3, 4}, n: 2, want: []int64{4, 3}},
+		{name: "skips pending ones", applied: []int64{1, 3}, n: 2, want: []int64{3, 1}},
+		{name: "more than applied", applied: []int64{1}, n: 5, want: []int64{1}},
+		{name: "nothing applied", applied: nil, n: 1, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := versions(m.lastApplied(appliedVersions(tt.applied...), tt.n))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := &Migrator{migrations: []Migration{
+		{Version: 1, Name: "one", Checksum: "a"},
+		{Version: 2, Name: "two", Checksum: "b"},
+	}}
+
+	tests := []struct {
+		name    string
+		applied map[int64]appliedMigration
+		err     error
+	}{
+		{name: "nothing applied", applied: nil},
+		{name: "unchanged", applied: map[int64]appliedMigration{1: {checksum: "a"}, 2: {checksum: "b"}}},
+		{name: "pending ones aren't checked", applied: map[int64]appliedMigration{1: {checksum: "a"}}},
+		{name: "edited after it was applied", applied: map[int64]appliedMigration{2: {checksum: "old"}}, err: ErrChecksumMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.verify(tt.applied)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "2_two") {
+				t.Errorf("error %q doesn't name the edited migration", err)
+			}
+		})
+	}
+}
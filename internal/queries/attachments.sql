@@ -0,0 +1,39 @@
+-- name: CreateAttachment :one
+INSERT INTO attachments (
+    uploader_id,
+    conversation_id,
+    storage_key,
+    file_name,
+    mime_type,
+    size_bytes,
+    checksum_sha256,
+    width,
+    height
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9
+) RETURNING *;
+
+-- name: GetAttachmentByID :one
+SELECT * FROM attachments WHERE id = $1;
+
+-- name: LinkAttachmentsToMessage :many
+-- Only the uploader's own, not yet sent attachments of the same conversation can be linked
+UPDATE attachments
+SET message_id = @message_id::uuid
+WHERE id = ANY(@ids::uuid[])
+  AND uploader_id = @uploader_id
+  AND conversation_id = @conversation_id
+  AND message_id IS NULL
+RETURNING *;
+
+-- name: GetAttachmentsByMessageIDs :many
+SELECT * FROM attachments
+WHERE message_id = ANY(@message_ids::uuid[])
+ORDER BY created_at ASC;
+
+-- name: DeleteAttachmentsByMessageID :many
+DELETE FROM attachments WHERE message_id = @message_id::uuid RETURNING storage_key;
+
+-- name: DeleteAttachmentsByConversationID :many
+-- Deleting the conversation would cascade them away, the files have to be deleted too
+DELETE FROM attachments WHERE conversation_id = @conversation_id::uuid RETURNING storage_key;
@@ -0,0 +1,239 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: attachments.sql
+
+package queries
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const createAttachment = `-- name: CreateAttachment :one
+INSERT INTO attachments (
+    uploader_id,
+    conversation_id,
+    storage_key,
+    file_name,
+    mime_type,
+    size_bytes,
+    checksum_sha256,
+    width,
+    height
+) VALUES (
+    $1, $2, $3, $4, $5, $6, $7, $8, $9
+) RETURNING id, uploader_id, conversation_id, message_id, storage_key, file_name, mime_type, size_bytes, checksum_sha256, width, height, created_at
+`
+
+type CreateAttachmentParams struct {
+	UploaderID     uuid.UUID   `json:"uploader_id"`
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	StorageKey     string      `json:"-"`
+	FileName       string      `json:"file_name"`
+	MimeType       string      `json:"mime_type"`
+	SizeBytes      int64       `json:"size_bytes"`
+	ChecksumSha256 string      `json:"checksum_sha256"`
+	Width          pgtype.Int4 `json:"width"`
+	Height         pgtype.Int4 `json:"height"`
+}
+
+func (q *Queries) CreateAttachment(ctx context.Context, arg CreateAttachmentParams) (Attachment, error) {
+	row := q.db.QueryRow(ctx, createAttachment,
+		arg.UploaderID,
+		arg.ConversationID,
+		arg.StorageKey,
+		arg.FileName,
+		arg.MimeType,
+		arg.SizeBytes,
+		arg.ChecksumSha256,
+		arg.Width,
+		arg.Height,
+	)
+	var i Attachment
+	err := row.Scan(
+		&i.ID,
+		&i.UploaderID,
+		&i.ConversationID,
+		&i.MessageID,
+		&i.StorageKey,
+		&i.FileName,
+		&i.MimeType,
+		&i.SizeBytes,
+		&i.ChecksumSha256,
+		&i.Width,
+		&i.Height,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteAttachmentsByConversationID = `-- name: DeleteAttachmentsByConversationID :many
+DELETE FROM attachments WHERE conversation_id = $1::uuid RETURNING storage_key
+`
+
+// Deleting the conversation would cascade them away, the files have to be deleted too
+func (q *Queries) DeleteAttachmentsByConversationID(ctx context.Context, conversationID uuid.UUID) ([]string, error) {
+	rows, err := q.db.Query(ctx, deleteAttachmentsByConversationID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var storage_key string
+		if err := rows.Scan(&storage_key); err != nil {
+			return nil, err
+		}
+		items = append(items, storage_key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteAttachmentsByMessageID = `-- name: DeleteAttachmentsByMessageID :many
+DELETE FROM attachments WHERE message_id = $1::uuid RETURNING storage_key
+`
+
+func (q *Queries) DeleteAttachmentsByMessageID(ctx context.Context, messageID uuid.UUID) ([]string, error) {
+	rows, err := q.db.Query(ctx, deleteAttachmentsByMessageID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var storage_key string
+		if err := rows.Scan(&storage_key); err != nil {
+			return nil, err
+		}
+		items = append(items, storage_key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAttachmentByID = `-- name: GetAttachmentByID :one
+SELECT id, uploader_id, conversation_id, message_id, storage_key, file_name, mime_type, size_bytes, checksum_sha256, width, height, created_at FROM attachments WHERE id = $1
+`
+
+func (q *Queries) GetAttachmentByID(ctx context.Context, id uuid.UUID) (Attachment, error) {
+	row := q.db.QueryRow(ctx, getAttachmentByID, id)
+	var i Attachment
+	err := row.Scan(
+		&i.ID,
+		&i.UploaderID,
+		&i.ConversationID,
+		&i.MessageID,
+		&i.StorageKey,
+		&i.FileName,
+		&i.MimeType,
+		&i.SizeBytes,
+		&i.ChecksumSha256,
+		&i.Width,
+		&i.Height,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getAttachmentsByMessageIDs = `-- name: GetAttachmentsByMessageIDs :many
+SELECT id, uploader_id, conversation_id, message_id, storage_key, file_name, mime_type, size_bytes, checksum_sha256, width, height, created_at FROM attachments
+WHERE message_id = ANY($1::uuid[])
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetAttachmentsByMessageIDs(ctx context.Context, messageIds []uuid.UUID) ([]Attachment, error) {
+	rows, err := q.db.Query(ctx, getAttachmentsByMessageIDs, messageIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attachment
+	for rows.Next() {
+		var i Attachment
+		if err := rows.Scan(
+			&i.ID,
+			&i.UploaderID,
+			&i.ConversationID,
+			&i.MessageID,
+			&i.StorageKey,
+			&i.FileName,
+			&i.MimeType,
+			&i.SizeBytes,
+			&i.ChecksumSha256,
+			&i.Width,
+			&i.Height,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const linkAttachmentsToMessage = `-- name: LinkAttachmentsToMessage :many
+UPDATE attachments
+SET message_id = $1::uuid
+WHERE id = ANY($2::uuid[])
+  AND uploader_id = $3
+  AND conversation_id = $4
+  AND message_id IS NULL
+RETURNING id, uploader_id, conversation_id, message_id, storage_key, file_name, mime_type, size_bytes, checksum_sha256, width, height, created_at
+`
+
+type LinkAttachmentsToMessageParams struct {
+	MessageID      uuid.UUID   `json:"message_id"`
+	Ids            []uuid.UUID `json:"ids"`
+	UploaderID     uuid.UUID   `json:"uploader_id"`
+	ConversationID uuid.UUID   `json:"conversation_id"`
+}
+
+// Only the uploader's own, not yet sent attachments of the same conversation can be linked
+func (q *Queries) LinkAttachmentsToMessage(ctx context.Context, arg LinkAttachmentsToMessageParams) ([]Attachment, error) {
+	rows, err := q.db.Query(ctx, linkAttachmentsToMessage,
+		arg.MessageID,
+		arg.Ids,
+		arg.UploaderID,
+		arg.ConversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attachment
+	for rows.Next() {
+		var i Attachment
+		if err := rows.Scan(
+			&i.ID,
+			&i.UploaderID,
+			&i.ConversationID,
+			&i.MessageID,
+			&i.StorageKey,
+			&i.FileName,
+			&i.MimeType,
+			&i.SizeBytes,
+			&i.ChecksumSha256,
+			&i.Width,
+			&i.Height,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,38 @@
+-- name: BlockUser :exec
+INSERT INTO user_blocks (blocker_id, blocked_id)
+VALUES (@blocker_id, @blocked_id)
+ON CONFLICT DO NOTHING;
+
+-- name: UnblockUser :one
+DELETE FROM user_blocks
+WHERE blocker_id = @blocker_id AND blocked_id = @blocked_id
+RETURNING *;
+
+-- name: GetBlockedUsers :many
+SELECT
+    u.id,
+    u.username,
+    b.created_at AS blocked_at
+FROM user_blocks b
+JOIN users u ON u.id = b.blocked_id
+WHERE b.blocker_id = $1
+ORDER BY b.created_at DESC;
+
+-- name: IsBlockedBetween :one
+-- Either of the users blocked the other
+SELECT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = @user1::uuid AND blocked_id = @user2::uuid)
+       OR (blocker_id = @user2::uuid AND blocked_id = @user1::uuid)
+);
+
+-- name: IsBlockedInConversation :one
+-- The members of a direct conversation blocked one another, groups are never blocked
+SELECT EXISTS (
+    SELECT 1
+    FROM conversations c
+    JOIN user_blocks b
+        ON b.blocker_id IN (c.user1, c.user2)
+       AND b.blocked_id IN (c.user1, c.user2)
+    WHERE c.id = $1 AND NOT c.is_group
+);
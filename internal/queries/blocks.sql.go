@@ -0,0 +1,124 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: blocks.sql
+
+package queries
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const blockUser = `-- name: BlockUser :exec
+INSERT INTO user_blocks (blocker_id, blocked_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING
+`
+
+type BlockUserParams struct {
+	BlockerID uuid.UUID `json:"blocker_id"`
+	BlockedID uuid.UUID `json:"blocked_id"`
+}
+
+func (q *Queries) BlockUser(ctx context.Context, arg BlockUserParams) error {
+	_, err := q.db.Exec(ctx, blockUser, arg.BlockerID, arg.BlockedID)
+	return err
+}
+
+const getBlockedUsers = `-- name: GetBlockedUsers :many
+SELECT
+    u.id,
+    u.username,
+    b.created_at AS blocked_at
+FROM user_blocks b
+JOIN users u ON u.id = b.blocked_id
+WHERE b.blocker_id = $1
+ORDER BY b.created_at DESC
+`
+
+type GetBlockedUsersRow struct {
+	ID        uuid.UUID          `json:"id"`
+	Username  string             `json:"username"`
+	BlockedAt pgtype.Timestamptz `json:"blocked_at"`
+}
+
+func (q *Queries) GetBlockedUsers(ctx context.Context, blockerID uuid.UUID) ([]GetBlockedUsersRow, error) {
+	rows, err := q.db.Query(ctx, getBlockedUsers, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetBlockedUsersRow
+	for rows.Next() {
+		var i GetBlockedUsersRow
+		if err := rows.Scan(&i.ID, &i.Username, &i.BlockedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isBlockedBetween = `-- name: IsBlockedBetween :one
+SELECT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = $1::uuid AND blocked_id = $2::uuid)
+       OR (blocker_id = $2::uuid AND blocked_id = $1::uuid)
+)
+`
+
+type IsBlockedBetweenParams struct {
+	User1 uuid.UUID `json:"user1"`
+	User2 uuid.UUID `json:"user2"`
+}
+
+// Either of the users blocked the other
+func (q *Queries) IsBlockedBetween(ctx context.Context, arg IsBlockedBetweenParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isBlockedBetween, arg.User1, arg.User2)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const isBlockedInConversation = `-- name: IsBlockedInConversation :one
+SELECT EXISTS (
+    SELECT 1
+    FROM conversations c
+    JOIN user_blocks b
+        ON b.blocker_id IN (c.user1, c.user2)
+       AND b.blocked_id IN (c.user1, c.user2)
+    WHERE c.id = $1 AND NOT c.is_group
+)
+`
+
+// The members of a direct conversation blocked one another, groups are never blocked
+func (q *Queries) IsBlockedInConversation(ctx context.Context, id uuid.UUID) (bool, error) {
+	row := q.db.QueryRow(ctx, isBlockedInConversation, id)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const unblockUser = `-- name: UnblockUser :one
+DELETE FROM user_blocks
+WHERE blocker_id = $1 AND blocked_id = $2
+RETURNING blocker_id, blocked_id, created_at
+`
+
+type UnblockUserParams struct {
+	BlockerID uuid.UUID `json:"blocker_id"`
+	BlockedID uuid.UUID `json:"blocked_id"`
+}
+
+func (q *Queries) UnblockUser(ctx context.Context, arg UnblockUserParams) (UserBlock, error) {
+	row := q.db.QueryRow(ctx, unblockUser, arg.BlockerID, arg.BlockedID)
+	var i UserBlock
+	err := row.Scan(&i.BlockerID, &i.BlockedID, &i.CreatedAt)
+	return i, err
+}
@@ -13,14 +13,50 @@ INSERT INTO contacts (
 SELECT 
     u.id, 
     u.username, 
-    u.email, 
-    u.created_at, 
-    u.last_seen
+    u.last_seen,
+    c.nickname,
+    c.created_at AS added_at
 FROM users u
 INNER JOIN contacts c ON u.id = c.contact_user_id
 WHERE c.user_id = $1
-ORDER BY u.username ASC;
+ORDER BY COALESCE(c.nickname, u.username) ASC;
+
+-- name: GetContact :one
+SELECT
+    u.id,
+    u.username,
+    u.last_seen,
+    c.nickname,
+    c.created_at AS added_at
+FROM users u
+INNER JOIN contacts c ON u.id = c.contact_user_id
+WHERE c.user_id = @user_id AND c.contact_user_id = @contact_user_id;
+
+-- name: SearchContacts :many
+-- Matches either the username or the nickname the user picked
+SELECT
+    u.id,
+    u.username,
+    u.last_seen,
+    c.nickname,
+    c.created_at AS added_at
+FROM users u
+INNER JOIN contacts c ON u.id = c.contact_user_id
+WHERE c.user_id = @user_id
+  AND (u.username ILIKE '%' || @query::text || '%' OR c.nickname ILIKE '%' || @query::text || '%')
+ORDER BY COALESCE(c.nickname, u.username) ASC
+LIMIT 50;
+
+-- name: UpdateContactNickname :one
+UPDATE contacts
+SET nickname = @nickname
+WHERE user_id = @user_id AND contact_user_id = @contact_user_id
+RETURNING *;
 
 -- name: DeleteContact :one
 DELETE FROM contacts WHERE contact_user_id = $1 AND user_id = $2 RETURNING *;
 
+-- name: IsContact :one
+SELECT EXISTS (
+    SELECT 1 FROM contacts WHERE user_id = @user_id AND contact_user_id = @contact_user_id
+);
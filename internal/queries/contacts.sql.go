@@ -65,25 +65,63 @@ func (q *Queries) DeleteContact(ctx context.Context, arg DeleteContactParams) (C
 	return i, err
 }
 
+const getContact = `-- name: GetContact :one
+SELECT
+    u.id,
+    u.username,
+    u.last_seen,
+    c.nickname,
+    c.created_at AS added_at
+FROM users u
+INNER JOIN contacts c ON u.id = c.contact_user_id
+WHERE c.user_id = $1 AND c.contact_user_id = $2
+`
+
+type GetContactParams struct {
+	UserID        uuid.UUID `json:"user_id"`
+	ContactUserID uuid.UUID `json:"contact_user_id"`
+}
+
+type GetContactRow struct {
+	ID       uuid.UUID          `json:"id"`
+	Username string             `json:"username"`
+	LastSeen pgtype.Timestamptz `json:"last_seen"`
+	Nickname pgtype.Text        `json:"nickname"`
+	AddedAt  pgtype.Timestamptz `json:"added_at"`
+}
+
+func (q *Queries) GetContact(ctx context.Context, arg GetContactParams) (GetContactRow, error) {
+	row := q.db.QueryRow(ctx, getContact, arg.UserID, arg.ContactUserID)
+	var i GetContactRow
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.LastSeen,
+		&i.Nickname,
+		&i.AddedAt,
+	)
+	return i, err
+}
+
 const getContactsByUserID = `-- name: GetContactsByUserID :many
 SELECT 
     u.id, 
     u.username, 
-    u.email, 
-    u.created_at, 
-    u.last_seen
+    u.last_seen,
+    c.nickname,
+    c.created_at AS added_at
 FROM users u
 INNER JOIN contacts c ON u.id = c.contact_user_id
 WHERE c.user_id = $1
-ORDER BY u.username ASC
+ORDER BY COALESCE(c.nickname, u.username) ASC
 `
 
 type GetContactsByUserIDRow struct {
-	ID        uuid.UUID          `json:"id"`
-	Username  string             `json:"username"`
-	Email     string             `json:"email"`
-	CreatedAt pgtype.Timestamptz `json:"created_at"`
-	LastSeen  pgtype.Timestamptz `json:"last_seen"`
+	ID       uuid.UUID          `json:"id"`
+	Username string             `json:"username"`
+	LastSeen pgtype.Timestamptz `json:"last_seen"`
+	Nickname pgtype.Text        `json:"nickname"`
+	AddedAt  pgtype.Timestamptz `json:"added_at"`
 }
 
 func (q *Queries) GetContactsByUserID(ctx context.Context, userID uuid.UUID) ([]GetContactsByUserIDRow, error) {
@@ -98,9 +136,9 @@ func (q *Queries) GetContactsByUserID(ctx context.Context, userID uuid.UUID) ([]
 		if err := rows.Scan(
 			&i.ID,
 			&i.Username,
-			&i.Email,
-			&i.CreatedAt,
 			&i.LastSeen,
+			&i.Nickname,
+			&i.AddedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -111,3 +149,102 @@ func (q *Queries) GetContactsByUserID(ctx context.Context, userID uuid.UUID) ([]
 	}
 	return items, nil
 }
+
+const isContact = `-- name: IsContact :one
+SELECT EXISTS (
+    SELECT 1 FROM contacts WHERE user_id = $1 AND contact_user_id = $2
+)
+`
+
+type IsContactParams struct {
+	UserID        uuid.UUID `json:"user_id"`
+	ContactUserID uuid.UUID `json:"contact_user_id"`
+}
+
+func (q *Queries) IsContact(ctx context.Context, arg IsContactParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isContact, arg.UserID, arg.ContactUserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const searchContacts = `-- name: SearchContacts :many
+SELECT
+    u.id,
+    u.username,
+    u.last_seen,
+    c.nickname,
+    c.created_at AS added_at
+FROM users u
+INNER JOIN contacts c ON u.id = c.contact_user_id
+WHERE c.user_id = $1
+  AND (u.username ILIKE '%' || $2::text || '%' OR c.nickname ILIKE '%' || $2::text || '%')
+ORDER BY COALESCE(c.nickname, u.username) ASC
+LIMIT 50
+`
+
+type SearchContactsParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Query  string    `json:"query"`
+}
+
+type SearchContactsRow struct {
+	ID       uuid.UUID          `json:"id"`
+	Username string             `json:"username"`
+	LastSeen pgtype.Timestamptz `json:"last_seen"`
+	Nickname pgtype.Text        `json:"nickname"`
+	AddedAt  pgtype.Timestamptz `json:"added_at"`
+}
+
+// Matches either the username or the nickname the user picked
+func (q *Queries) SearchContacts(ctx context.Context, arg SearchContactsParams) ([]SearchContactsRow, error) {
+	rows, err := q.db.Query(ctx, searchContacts, arg.UserID, arg.Query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchContactsRow
+	for rows.Next() {
+		var i SearchContactsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.LastSeen,
+			&i.Nickname,
+			&i.AddedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateContactNickname = `-- name: UpdateContactNickname :one
+UPDATE contacts
+SET nickname = $1
+WHERE user_id = $2 AND contact_user_id = $3
+RETURNING id, user_id, contact_user_id, nickname, created_at
+`
+
+type UpdateContactNicknameParams struct {
+	Nickname      pgtype.Text `json:"nickname"`
+	UserID        uuid.UUID   `json:"user_id"`
+	ContactUserID uuid.UUID   `json:"contact_user_id"`
+}
+
+func (q *Queries) UpdateContactNickname(ctx context.Context, arg UpdateContactNicknameParams) (Contact, error) {
+	row := q.db.QueryRow(ctx, updateContactNickname, arg.Nickname, arg.UserID, arg.ContactUserID)
+	var i Contact
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ContactUserID,
+		&i.Nickname,
+		&i.CreatedAt,
+	)
+	return i, err
+}
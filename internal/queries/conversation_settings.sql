@@ -0,0 +1,97 @@
+-- name: GetConversationSettings :many
+SELECT
+    s.*,
+    (s.archived_at IS NOT NULL AND s.archived_at >= c.last_activity_at)::boolean AS archived
+FROM conversation_user_settings s
+JOIN conversations c ON c.id = s.conversation_id
+WHERE s.user_id = @user_id AND s.conversation_id = ANY(@conversation_ids::uuid[]);
+
+-- name: ArchiveConversation :exec
+-- Archiving unpins
+INSERT INTO conversation_user_settings (conversation_id, user_id, archived_at)
+VALUES (@conversation_id, @user_id, CURRENT_TIMESTAMP)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET archived_at = CURRENT_TIMESTAMP,
+    pin_position = NULL;
+
+-- name: UnarchiveConversation :exec
+UPDATE conversation_user_settings
+SET archived_at = NULL
+WHERE conversation_id = @conversation_id AND user_id = @user_id;
+
+-- name: ClearConversation :exec
+-- Hides the messages so far from the user alone
+INSERT INTO conversation_user_settings (conversation_id, user_id, cleared_at)
+VALUES (@conversation_id, @user_id, CURRENT_TIMESTAMP)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET cleared_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteConversationForUser :exec
+-- Deleting clears, unpins and unarchives, the conversation comes back unpinned with the next message
+INSERT INTO conversation_user_settings (conversation_id, user_id, deleted_at, cleared_at)
+VALUES (@conversation_id, @user_id, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET deleted_at = CURRENT_TIMESTAMP,
+    cleared_at = CURRENT_TIMESTAMP,
+    archived_at = NULL,
+    pin_position = NULL;
+
+-- name: GetPinnedConversationIDs :many
+SELECT conversation_id FROM conversation_user_settings
+WHERE user_id = $1 AND pin_position IS NOT NULL
+ORDER BY pin_position ASC;
+
+-- name: LockUserPins :exec
+-- Serializes the pins of a user until the end of the transaction,
+-- NO KEY leaves the foreign keys pointing at the user alone
+SELECT id FROM users WHERE id = $1 FOR NO KEY UPDATE;
+
+-- name: PinConversation :exec
+-- Goes below the other pinned conversations, pinning unarchives.
+-- Pinning a pinned conversation keeps its position
+INSERT INTO conversation_user_settings (conversation_id, user_id, pin_position)
+VALUES (
+    @conversation_id,
+    @user_id,
+    (SELECT COALESCE(MAX(p.pin_position), 0) + 1 FROM conversation_user_settings p WHERE p.user_id = @user_id)
+)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET pin_position = COALESCE(conversation_user_settings.pin_position, EXCLUDED.pin_position),
+    archived_at = NULL;
+
+-- name: UnpinConversation :exec
+UPDATE conversation_user_settings
+SET pin_position = NULL
+WHERE conversation_id = @conversation_id AND user_id = @user_id;
+
+-- name: ReorderPinnedConversations :exec
+-- Positions follow the order of the ids
+UPDATE conversation_user_settings
+SET pin_position = array_position(@conversation_ids::uuid[], conversation_id)
+WHERE user_id = @user_id
+  AND pin_position IS NOT NULL
+  AND conversation_id = ANY(@conversation_ids::uuid[]);
+
+-- name: MuteConversation :exec
+INSERT INTO conversation_user_settings (conversation_id, user_id, muted_at, muted_until)
+VALUES (@conversation_id, @user_id, CURRENT_TIMESTAMP, sqlc.narg(muted_until))
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET muted_at = CURRENT_TIMESTAMP,
+    muted_until = EXCLUDED.muted_until;
+
+-- name: UnmuteConversation :exec
+UPDATE conversation_user_settings
+SET muted_at = NULL,
+    muted_until = NULL
+WHERE conversation_id = @conversation_id AND user_id = @user_id;
+
+-- name: GetMutedMemberIDs :many
+-- Members who don't want to be notified about the conversation right now
+SELECT user_id FROM conversation_user_settings
+WHERE conversation_id = $1
+  AND muted_at IS NOT NULL
+  AND (muted_until IS NULL OR muted_until > CURRENT_TIMESTAMP);
+
+-- name: DeleteConversationSettings :exec
+DELETE FROM conversation_user_settings
+WHERE conversation_id = @conversation_id AND user_id = @user_id;
@@ -0,0 +1,318 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: conversation_settings.sql
+
+package queries
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const archiveConversation = `-- name: ArchiveConversation :exec
+INSERT INTO conversation_user_settings (conversation_id, user_id, archived_at)
+VALUES ($1, $2, CURRENT_TIMESTAMP)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET archived_at = CURRENT_TIMESTAMP,
+    pin_position = NULL
+`
+
+type ArchiveConversationParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+// Archiving unpins
+func (q *Queries) ArchiveConversation(ctx context.Context, arg ArchiveConversationParams) error {
+	_, err := q.db.Exec(ctx, archiveConversation, arg.ConversationID, arg.UserID)
+	return err
+}
+
+const clearConversation = `-- name: ClearConversation :exec
+INSERT INTO conversation_user_settings (conversation_id, user_id, cleared_at)
+VALUES ($1, $2, CURRENT_TIMESTAMP)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET cleared_at = CURRENT_TIMESTAMP
+`
+
+type ClearConversationParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+// Hides the messages so far from the user alone
+func (q *Queries) ClearConversation(ctx context.Context, arg ClearConversationParams) error {
+	_, err := q.db.Exec(ctx, clearConversation, arg.ConversationID, arg.UserID)
+	return err
+}
+
+const deleteConversationForUser = `-- name: DeleteConversationForUser :exec
+INSERT INTO conversation_user_settings (conversation_id, user_id, deleted_at, cleared_at)
+VALUES ($1, $2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET deleted_at = CURRENT_TIMESTAMP,
+    cleared_at = CURRENT_TIMESTAMP,
+    archived_at = NULL,
+    pin_position = NULL
+`
+
+type DeleteConversationForUserParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+// Deleting clears, unpins and unarchives, the conversation comes back unpinned with the next message
+func (q *Queries) DeleteConversationForUser(ctx context.Context, arg DeleteConversationForUserParams) error {
+	_, err := q.db.Exec(ctx, deleteConversationForUser, arg.ConversationID, arg.UserID)
+	return err
+}
+
+const deleteConversationSettings = `-- name: DeleteConversationSettings :exec
+DELETE FROM conversation_user_settings
+WHERE conversation_id = $1 AND user_id = $2
+`
+
+type DeleteConversationSettingsParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) DeleteConversationSettings(ctx context.Context, arg DeleteConversationSettingsParams) error {
+	_, err := q.db.Exec(ctx, deleteConversationSettings, arg.ConversationID, arg.UserID)
+	return err
+}
+
+const getConversationSettings = `-- name: GetConversationSettings :many
+SELECT
+    s.conversation_id, s.user_id, s.archived_at, s.pin_position, s.muted_at, s.muted_until, s.deleted_at, s.cleared_at,
+    (s.archived_at IS NOT NULL AND s.archived_at >= c.last_activity_at)::boolean AS archived
+FROM conversation_user_settings s
+JOIN conversations c ON c.id = s.conversation_id
+WHERE s.user_id = $1 AND s.conversation_id = ANY($2::uuid[])
+`
+
+type GetConversationSettingsParams struct {
+	UserID          uuid.UUID   `json:"user_id"`
+	ConversationIds []uuid.UUID `json:"conversation_ids"`
+}
+
+type GetConversationSettingsRow struct {
+	ConversationID uuid.UUID          `json:"conversation_id"`
+	UserID         uuid.UUID          `json:"user_id"`
+	ArchivedAt     pgtype.Timestamptz `json:"archived_at"`
+	PinPosition    pgtype.Int4        `json:"pin_position"`
+	MutedAt        pgtype.Timestamptz `json:"muted_at"`
+	MutedUntil     pgtype.Timestamptz `json:"muted_until"`
+	DeletedAt      pgtype.Timestamptz `json:"deleted_at"`
+	ClearedAt      pgtype.Timestamptz `json:"cleared_at"`
+	Archived       bool               `json:"archived"`
+}
+
+func (q *Queries) GetConversationSettings(ctx context.Context, arg GetConversationSettingsParams) ([]GetConversationSettingsRow, error) {
+	rows, err := q.db.Query(ctx, getConversationSettings, arg.UserID, arg.ConversationIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetConversationSettingsRow
+	for rows.Next() {
+		var i GetConversationSettingsRow
+		if err := rows.Scan(
+			&i.ConversationID,
+			&i.UserID,
+			&i.ArchivedAt,
+			&i.PinPosition,
+			&i.MutedAt,
+			&i.MutedUntil,
+			&i.DeletedAt,
+			&i.ClearedAt,
+			&i.Archived,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMutedMemberIDs = `-- name: GetMutedMemberIDs :many
+SELECT user_id FROM conversation_user_settings
+WHERE conversation_id = $1
+  AND muted_at IS NOT NULL
+  AND (muted_until IS NULL OR muted_until > CURRENT_TIMESTAMP)
+`
+
+// Members who don't want to be notified about the conversation right now
+func (q *Queries) GetMutedMemberIDs(ctx context.Context, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, getMutedMemberIDs, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var user_id uuid.UUID
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPinnedConversationIDs = `-- name: GetPinnedConversationIDs :many
+SELECT conversation_id FROM conversation_user_settings
+WHERE user_id = $1 AND pin_position IS NOT NULL
+ORDER BY pin_position ASC
+`
+
+func (q *Queries) GetPinnedConversationIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, getPinnedConversationIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var conversation_id uuid.UUID
+		if err := rows.Scan(&conversation_id); err != nil {
+			return nil, err
+		}
+		items = append(items, conversation_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUserPins = `-- name: LockUserPins :exec
+SELECT id FROM users WHERE id = $1 FOR NO KEY UPDATE
+`
+
+// Serializes the pins of a user until the end of the transaction,
+// NO KEY leaves the foreign keys pointing at the user alone
+func (q *Queries) LockUserPins(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, lockUserPins, id)
+	return err
+}
+
+const muteConversation = `-- name: MuteConversation :exec
+INSERT INTO conversation_user_settings (conversation_id, user_id, muted_at, muted_until)
+VALUES ($1, $2, CURRENT_TIMESTAMP, $3)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET muted_at = CURRENT_TIMESTAMP,
+    muted_until = EXCLUDED.muted_until
+`
+
+type MuteConversationParams struct {
+	ConversationID uuid.UUID          `json:"conversation_id"`
+	UserID         uuid.UUID          `json:"user_id"`
+	MutedUntil     pgtype.Timestamptz `json:"muted_until"`
+}
+
+func (q *Queries) MuteConversation(ctx context.Context, arg MuteConversationParams) error {
+	_, err := q.db.Exec(ctx, muteConversation, arg.ConversationID, arg.UserID, arg.MutedUntil)
+	return err
+}
+
+const pinConversation = `-- name: PinConversation :exec
+INSERT INTO conversation_user_settings (conversation_id, user_id, pin_position)
+VALUES (
+    $1,
+    $2,
+    (SELECT COALESCE(MAX(p.pin_position), 0) + 1 FROM conversation_user_settings p WHERE p.user_id = $2)
+)
+ON CONFLICT (conversation_id, user_id) DO UPDATE
+SET pin_position = COALESCE(conversation_user_settings.pin_position, EXCLUDED.pin_position),
+    archived_at = NULL
+`
+
+type PinConversationParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+// Goes below the other pinned conversations, pinning unarchives.
+// Pinning a pinned conversation keeps its position
+func (q *Queries) PinConversation(ctx context.Context, arg PinConversationParams) error {
+	_, err := q.db.Exec(ctx, pinConversation, arg.ConversationID, arg.UserID)
+	return err
+}
+
+const reorderPinnedConversations = `-- name: ReorderPinnedConversations :exec
+UPDATE conversation_user_settings
+SET pin_position = array_position($1::uuid[], conversation_id)
+WHERE user_id = $2
+  AND pin_position IS NOT NULL
+  AND conversation_id = ANY($1::uuid[])
+`
+
+type ReorderPinnedConversationsParams struct {
+	ConversationIds []uuid.UUID `json:"conversation_ids"`
+	UserID          uuid.UUID   `json:"user_id"`
+}
+
+// Positions follow the order of the ids
+func (q *Queries) ReorderPinnedConversations(ctx context.Context, arg ReorderPinnedConversationsParams) error {
+	_, err := q.db.Exec(ctx, reorderPinnedConversations, arg.ConversationIds, arg.UserID)
+	return err
+}
+
+const unarchiveConversation = `-- name: UnarchiveConversation :exec
+UPDATE conversation_user_settings
+SET archived_at = NULL
+WHERE conversation_id = $1 AND user_id = $2
+`
+
+type UnarchiveConversationParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) UnarchiveConversation(ctx context.Context, arg UnarchiveConversationParams) error {
+	_, err := q.db.Exec(ctx, unarchiveConversation, arg.ConversationID, arg.UserID)
+	return err
+}
+
+const unmuteConversation = `-- name: UnmuteConversation :exec
+UPDATE conversation_user_settings
+SET muted_at = NULL,
+    muted_until = NULL
+WHERE conversation_id = $1 AND user_id = $2
+`
+
+type UnmuteConversationParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) UnmuteConversation(ctx context.Context, arg UnmuteConversationParams) error {
+	_, err := q.db.Exec(ctx, unmuteConversation, arg.ConversationID, arg.UserID)
+	return err
+}
+
+const unpinConversation = `-- name: UnpinConversation :exec
+UPDATE conversation_user_settings
+SET pin_position = NULL
+WHERE conversation_id = $1 AND user_id = $2
+`
+
+type UnpinConversationParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) UnpinConversation(ctx context.Context, arg UnpinConversationParams) error {
+	_, err := q.db.Exec(ctx, unpinConversation, arg.ConversationID, arg.UserID)
+	return err
+}
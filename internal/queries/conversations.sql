@@ -1,31 +1,211 @@
 -- name: GetConversationsByUserID :many
+-- Most recently active first, pass the last row's (last_activity_at, conversation_id) to continue
 SELECT 
     c.id AS conversation_id,
     u.id, 
     u.last_seen, 
     u.username,
+    c.request_pending,
+    c.delete_requested_by,
+    c.last_activity_at,
     (
         SELECT COUNT(m.id) 
         FROM messages m 
-        WHERE m.is_read = FALSE 
-          AND m.conversation_id = c.id
-          AND m.sender_id != $1 
+        WHERE m.conversation_id = c.id
+          AND m.sender_id != @user_id::uuid
+          AND NOT EXISTS (
+              SELECT 1 FROM message_receipts r
+              WHERE r.message_id = m.id AND r.user_id = @user_id::uuid AND r.read_at IS NOT NULL
+          )
+          AND NOT EXISTS (
+              SELECT 1 FROM hidden_messages h
+              WHERE h.message_id = m.id AND h.user_id = @user_id::uuid
+          )
+          AND (s.cleared_at IS NULL OR m.created_at > s.cleared_at)
     ) AS unread_msg_count
 FROM 
     conversations c
 JOIN 
     users u 
     ON u.id IN (c.user1, c.user2)
+LEFT JOIN
+    conversation_user_settings s
+    ON s.conversation_id = c.id AND s.user_id = @user_id::uuid
 WHERE 
-    (c.user1 = $1 OR c.user2 = $1)
-    AND u.id != $1;
+    NOT c.is_group
+    AND (c.user1 = @user_id::uuid OR c.user2 = @user_id::uuid)
+    AND u.id != @user_id::uuid
+    -- requests the user hasn't accepted are listed on their own
+    AND NOT (c.request_pending AND c.user2 = @user_id::uuid)
+    -- pinned conversations are loaded on their own, archived ones only when asked for,
+    -- deleted ones come back with the next message
+    AND (s.pin_position IS NOT NULL) = @pinned::boolean
+    AND (s.archived_at IS NOT NULL AND s.archived_at >= c.last_activity_at) = @archived::boolean
+    AND NOT (s.deleted_at IS NOT NULL AND s.deleted_at >= c.last_activity_at)
+    AND (
+        sqlc.narg(before_activity)::timestamptz IS NULL
+        OR (c.last_activity_at, c.id) < (sqlc.narg(before_activity)::timestamptz, sqlc.narg(before_id)::uuid)
+    )
+ORDER BY c.last_activity_at DESC, c.id DESC
+LIMIT @page_size;
+
+-- name: GetGroupConversationsByUserID :many
+-- Same order and cursor as GetConversationsByUserID
+SELECT
+    c.id AS conversation_id,
+    c.title,
+    c.created_by,
+    c.created_at,
+    c.last_activity_at,
+    (
+        SELECT COUNT(*)
+        FROM conversation_members cm2
+        WHERE cm2.conversation_id = c.id
+    ) AS member_count,
+    (
+        SELECT COUNT(m.id)
+        FROM messages m
+        WHERE m.conversation_id = c.id
+          AND m.sender_id != @user_id::uuid
+          AND NOT EXISTS (
+              SELECT 1 FROM message_receipts r
+              WHERE r.message_id = m.id AND r.user_id = @user_id::uuid AND r.read_at IS NOT NULL
+          )
+          AND NOT EXISTS (
+              SELECT 1 FROM hidden_messages h
+              WHERE h.message_id = m.id AND h.user_id = @user_id::uuid
+          )
+          AND (s.cleared_at IS NULL OR m.created_at > s.cleared_at)
+    ) AS unread_msg_count
+FROM
+    conversations c
+JOIN
+    conversation_members cm
+    ON cm.conversation_id = c.id
+LEFT JOIN
+    conversation_user_settings s
+    ON s.conversation_id = c.id AND s.user_id = @user_id::uuid
+WHERE
+    c.is_group
+    AND cm.user_id = @user_id::uuid
+    AND (s.pin_position IS NOT NULL) = @pinned::boolean
+    AND (s.archived_at IS NOT NULL AND s.archived_at >= c.last_activity_at) = @archived::boolean
+    AND NOT (s.deleted_at IS NOT NULL AND s.deleted_at >= c.last_activity_at)
+    AND (
+        sqlc.narg(before_activity)::timestamptz IS NULL
+        OR (c.last_activity_at, c.id) < (sqlc.narg(before_activity)::timestamptz, sqlc.narg(before_id)::uuid)
+    )
+ORDER BY c.last_activity_at DESC, c.id DESC
+LIMIT @page_size;
 
 
 -- name: GetConversationByMembers :one
-SELECT * FROM conversations WHERE (user1 = $1 AND user2 = $2) OR (user1 = $2 AND user2 = $1);
+SELECT * FROM conversations
+WHERE NOT is_group
+  AND ((user1 = @user1::uuid AND user2 = @user2::uuid) OR (user1 = @user2::uuid AND user2 = @user1::uuid));
+
+-- name: GetConversationByID :one
+SELECT * FROM conversations WHERE id = $1;
+
+-- name: GetConversationByIDForUpdate :one
+SELECT * FROM conversations WHERE id = $1 FOR UPDATE;
 
 -- name: CreateConversation :one
-INSERT INTO conversations(user1, user2) VALUES($1, $2) RETURNING *;
+INSERT INTO conversations(user1, user2, request_pending) VALUES(@user1::uuid, @user2::uuid, @request_pending::boolean) RETURNING *;
+
+-- name: CreateGroupConversation :one
+INSERT INTO conversations(is_group, title, created_by) VALUES(TRUE, @title::text, @created_by::uuid) RETURNING *;
+
+-- name: AddConversationMembers :exec
+INSERT INTO conversation_members (conversation_id, user_id)
+SELECT @conversation_id::uuid, unnest(@user_ids::uuid[]);
+
+-- name: GetConversationMemberIDs :many
+SELECT user_id FROM conversation_members WHERE conversation_id = $1;
+
+-- name: GetMembersOfConversations :many
+SELECT conversation_id, user_id FROM conversation_members
+WHERE conversation_id = ANY(@conversation_ids::uuid[]);
+
+-- name: GetConversationMembers :many
+SELECT
+    u.id,
+    u.username,
+    u.last_seen,
+    cm.joined_at
+FROM conversation_members cm
+JOIN users u ON u.id = cm.user_id
+WHERE cm.conversation_id = $1
+ORDER BY cm.joined_at ASC;
+
+-- name: IsConversationMember :one
+SELECT EXISTS (
+    SELECT 1 FROM conversation_members WHERE conversation_id = $1 AND user_id = $2
+);
+
+-- name: GetConversationPeerIDs :many
+-- Everyone the user shares at least one conversation with
+SELECT DISTINCT peer.user_id
+FROM conversation_members self
+JOIN conversation_members peer ON peer.conversation_id = self.conversation_id
+WHERE self.user_id = $1 AND peer.user_id != $1;
 
 -- name: DeleteConversation :one
 DELETE FROM conversations WHERE id = $1 RETURNING *;
+
+-- name: GetMessageRequests :many
+-- Direct conversations strangers started with the user, waiting to be accepted
+SELECT
+    c.id AS conversation_id,
+    u.id,
+    u.username,
+    u.last_seen,
+    c.created_at
+FROM conversations c
+JOIN users u ON u.id = c.user1
+WHERE c.request_pending AND c.user2 = @user_id::uuid
+ORDER BY c.created_at DESC;
+
+-- name: AcceptMessageRequest :one
+UPDATE conversations
+SET request_pending = FALSE
+WHERE id = @id AND user2 = @user_id::uuid AND request_pending
+RETURNING *;
+
+-- name: DeclineMessageRequest :one
+DELETE FROM conversations
+WHERE id = @id AND user2 = @user_id::uuid AND request_pending
+RETURNING *;
+
+-- name: RecordMessageRequestDecline :exec
+INSERT INTO message_request_declines (requester_id, recipient_id)
+VALUES (@requester_id::uuid, @recipient_id::uuid)
+ON CONFLICT (requester_id, recipient_id) DO UPDATE
+SET declined_at = CURRENT_TIMESTAMP;
+
+-- name: IsMessageRequestDeclined :one
+-- Whether the recipient declined a request of the requester since declined_after
+SELECT EXISTS (
+    SELECT 1 FROM message_request_declines
+    WHERE requester_id = @requester_id::uuid
+      AND recipient_id = @recipient_id::uuid
+      AND declined_at > @declined_after::timestamptz
+);
+
+-- name: RequestConversationDeletion :one
+UPDATE conversations
+SET delete_requested_by = @user_id::uuid,
+    delete_requested_at = CURRENT_TIMESTAMP
+WHERE id = @id AND NOT is_group
+RETURNING *;
+
+-- name: CancelConversationDeletion :one
+UPDATE conversations
+SET delete_requested_by = NULL,
+    delete_requested_at = NULL
+WHERE id = @id AND delete_requested_by IS NOT NULL
+RETURNING *;
+
+-- name: RemoveConversationMember :execrows
+DELETE FROM conversation_members
+WHERE conversation_id = @conversation_id AND user_id = @user_id;
@@ -7,34 +7,174 @@ package queries
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const acceptMessageRequest = `-- name: AcceptMessageRequest :one
+UPDATE conversations
+SET request_pending = FALSE
+WHERE id = $1 AND user2 = $2::uuid AND request_pending
+RETURNING id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at
+`
+
+type AcceptMessageRequestParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) AcceptMessageRequest(ctx context.Context, arg AcceptMessageRequestParams) (Conversation, error) {
+	row := q.db.QueryRow(ctx, acceptMessageRequest, arg.ID, arg.UserID)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.User1,
+		&i.User2,
+		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
+	)
+	return i, err
+}
+
+const addConversationMembers = `-- name: AddConversationMembers :exec
+INSERT INTO conversation_members (conversation_id, user_id)
+SELECT $1::uuid, unnest($2::uuid[])
+`
+
+type AddConversationMembersParams struct {
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	UserIds        []uuid.UUID `json:"user_ids"`
+}
+
+func (q *Queries) AddConversationMembers(ctx context.Context, arg AddConversationMembersParams) error {
+	_, err := q.db.Exec(ctx, addConversationMembers, arg.ConversationID, arg.UserIds)
+	return err
+}
+
+const cancelConversationDeletion = `-- name: CancelConversationDeletion :one
+UPDATE conversations
+SET delete_requested_by = NULL,
+    delete_requested_at = NULL
+WHERE id = $1 AND delete_requested_by IS NOT NULL
+RETURNING id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at
+`
+
+func (q *Queries) CancelConversationDeletion(ctx context.Context, id uuid.UUID) (Conversation, error) {
+	row := q.db.QueryRow(ctx, cancelConversationDeletion, id)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.User1,
+		&i.User2,
+		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
+	)
+	return i, err
+}
+
 const createConversation = `-- name: CreateConversation :one
-INSERT INTO conversations(user1, user2) VALUES($1, $2) RETURNING id, user1, user2, created_at
+INSERT INTO conversations(user1, user2, request_pending) VALUES($1::uuid, $2::uuid, $3::boolean) RETURNING id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at
 `
 
 type CreateConversationParams struct {
-	User1 uuid.UUID `json:"user1"`
-	User2 uuid.UUID `json:"user2"`
+	User1          uuid.UUID `json:"user1"`
+	User2          uuid.UUID `json:"user2"`
+	RequestPending bool      `json:"request_pending"`
 }
 
 func (q *Queries) CreateConversation(ctx context.Context, arg CreateConversationParams) (Conversation, error) {
-	row := q.db.QueryRow(ctx, createConversation, arg.User1, arg.User2)
+	row := q.db.QueryRow(ctx, createConversation, arg.User1, arg.User2, arg.RequestPending)
 	var i Conversation
 	err := row.Scan(
 		&i.ID,
 		&i.User1,
 		&i.User2,
 		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
+	)
+	return i, err
+}
+
+const createGroupConversation = `-- name: CreateGroupConversation :one
+INSERT INTO conversations(is_group, title, created_by) VALUES(TRUE, $1::text, $2::uuid) RETURNING id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at
+`
+
+type CreateGroupConversationParams struct {
+	Title     string    `json:"title"`
+	CreatedBy uuid.UUID `json:"created_by"`
+}
+
+func (q *Queries) CreateGroupConversation(ctx context.Context, arg CreateGroupConversationParams) (Conversation, error) {
+	row := q.db.QueryRow(ctx, createGroupConversation, arg.Title, arg.CreatedBy)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.User1,
+		&i.User2,
+		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
+	)
+	return i, err
+}
+
+const declineMessageRequest = `-- name: DeclineMessageRequest :one
+DELETE FROM conversations
+WHERE id = $1 AND user2 = $2::uuid AND request_pending
+RETURNING id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at
+`
+
+type DeclineMessageRequestParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) DeclineMessageRequest(ctx context.Context, arg DeclineMessageRequestParams) (Conversation, error) {
+	row := q.db.QueryRow(ctx, declineMessageRequest, arg.ID, arg.UserID)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.User1,
+		&i.User2,
+		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
 	)
 	return i, err
 }
 
 const deleteConversation = `-- name: DeleteConversation :one
-DELETE FROM conversations WHERE id = $1 RETURNING id, user1, user2, created_at
+DELETE FROM conversations WHERE id = $1 RETURNING id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at
 `
 
 func (q *Queries) DeleteConversation(ctx context.Context, id uuid.UUID) (Conversation, error) {
@@ -45,12 +185,67 @@ func (q *Queries) DeleteConversation(ctx context.Context, id uuid.UUID) (Convers
 		&i.User1,
 		&i.User2,
 		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
+	)
+	return i, err
+}
+
+const getConversationByID = `-- name: GetConversationByID :one
+SELECT id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at FROM conversations WHERE id = $1
+`
+
+func (q *Queries) GetConversationByID(ctx context.Context, id uuid.UUID) (Conversation, error) {
+	row := q.db.QueryRow(ctx, getConversationByID, id)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.User1,
+		&i.User2,
+		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
+	)
+	return i, err
+}
+
+const getConversationByIDForUpdate = `-- name: GetConversationByIDForUpdate :one
+SELECT id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at FROM conversations WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) GetConversationByIDForUpdate(ctx context.Context, id uuid.UUID) (Conversation, error) {
+	row := q.db.QueryRow(ctx, getConversationByIDForUpdate, id)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.User1,
+		&i.User2,
+		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
 	)
 	return i, err
 }
 
 const getConversationByMembers = `-- name: GetConversationByMembers :one
-SELECT id, user1, user2, created_at FROM conversations WHERE (user1 = $1 AND user2 = $2) OR (user1 = $2 AND user2 = $1)
+SELECT id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at FROM conversations
+WHERE NOT is_group
+  AND ((user1 = $1::uuid AND user2 = $2::uuid) OR (user1 = $2::uuid AND user2 = $1::uuid))
 `
 
 type GetConversationByMembersParams struct {
@@ -66,43 +261,194 @@ func (q *Queries) GetConversationByMembers(ctx context.Context, arg GetConversat
 		&i.User1,
 		&i.User2,
 		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
 	)
 	return i, err
 }
 
+const getConversationMemberIDs = `-- name: GetConversationMemberIDs :many
+SELECT user_id FROM conversation_members WHERE conversation_id = $1
+`
+
+func (q *Queries) GetConversationMemberIDs(ctx context.Context, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, getConversationMemberIDs, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var user_id uuid.UUID
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getConversationMembers = `-- name: GetConversationMembers :many
+SELECT
+    u.id,
+    u.username,
+    u.last_seen,
+    cm.joined_at
+FROM conversation_members cm
+JOIN users u ON u.id = cm.user_id
+WHERE cm.conversation_id = $1
+ORDER BY cm.joined_at ASC
+`
+
+type GetConversationMembersRow struct {
+	ID       uuid.UUID          `json:"id"`
+	Username string             `json:"username"`
+	LastSeen pgtype.Timestamptz `json:"last_seen"`
+	JoinedAt pgtype.Timestamptz `json:"joined_at"`
+}
+
+func (q *Queries) GetConversationMembers(ctx context.Context, conversationID uuid.UUID) ([]GetConversationMembersRow, error) {
+	rows, err := q.db.Query(ctx, getConversationMembers, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetConversationMembersRow
+	for rows.Next() {
+		var i GetConversationMembersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.LastSeen,
+			&i.JoinedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getConversationPeerIDs = `-- name: GetConversationPeerIDs :many
+SELECT DISTINCT peer.user_id
+FROM conversation_members self
+JOIN conversation_members peer ON peer.conversation_id = self.conversation_id
+WHERE self.user_id = $1 AND peer.user_id != $1
+`
+
+// Everyone the user shares at least one conversation with
+func (q *Queries) GetConversationPeerIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, getConversationPeerIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var user_id uuid.UUID
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getConversationsByUserID = `-- name: GetConversationsByUserID :many
 SELECT 
     c.id AS conversation_id,
     u.id, 
     u.last_seen, 
     u.username,
+    c.request_pending,
+    c.delete_requested_by,
+    c.last_activity_at,
     (
         SELECT COUNT(m.id) 
         FROM messages m 
-        WHERE m.is_read = FALSE 
-          AND m.conversation_id = c.id
-          AND m.sender_id != $1 
+        WHERE m.conversation_id = c.id
+          AND m.sender_id != $1::uuid
+          AND NOT EXISTS (
+              SELECT 1 FROM message_receipts r
+              WHERE r.message_id = m.id AND r.user_id = $1::uuid AND r.read_at IS NOT NULL
+          )
+          AND NOT EXISTS (
+              SELECT 1 FROM hidden_messages h
+              WHERE h.message_id = m.id AND h.user_id = $1::uuid
+          )
+          AND (s.cleared_at IS NULL OR m.created_at > s.cleared_at)
     ) AS unread_msg_count
 FROM 
     conversations c
 JOIN 
     users u 
     ON u.id IN (c.user1, c.user2)
+LEFT JOIN
+    conversation_user_settings s
+    ON s.conversation_id = c.id AND s.user_id = $1::uuid
 WHERE 
-    (c.user1 = $1 OR c.user2 = $1)
-    AND u.id != $1
+    NOT c.is_group
+    AND (c.user1 = $1::uuid OR c.user2 = $1::uuid)
+    AND u.id != $1::uuid
+    -- requests the user hasn't accepted are listed on their own
+    AND NOT (c.request_pending AND c.user2 = $1::uuid)
+    -- pinned conversations are loaded on their own, archived ones only when asked for,
+    -- deleted ones come back with the next message
+    AND (s.pin_position IS NOT NULL) = $2::boolean
+    AND (s.archived_at IS NOT NULL AND s.archived_at >= c.last_activity_at) = $3::boolean
+    AND NOT (s.deleted_at IS NOT NULL AND s.deleted_at >= c.last_activity_at)
+    AND (
+        $4::timestamptz IS NULL
+        OR (c.last_activity_at, c.id) < ($4::timestamptz, $5::uuid)
+    )
+ORDER BY c.last_activity_at DESC, c.id DESC
+LIMIT $6
 `
 
+type GetConversationsByUserIDParams struct {
+	UserID         uuid.UUID          `json:"user_id"`
+	Pinned         bool               `json:"pinned"`
+	Archived       bool               `json:"archived"`
+	BeforeActivity pgtype.Timestamptz `json:"before_activity"`
+	BeforeID       pgtype.UUID        `json:"before_id"`
+	PageSize       int32              `json:"page_size"`
+}
+
 type GetConversationsByUserIDRow struct {
-	ConversationID uuid.UUID          `json:"conversation_id"`
-	ID             uuid.UUID          `json:"id"`
-	LastSeen       pgtype.Timestamptz `json:"last_seen"`
-	Username       string             `json:"username"`
-	UnreadMsgCount int64              `json:"unread_msg_count"`
+	ConversationID    uuid.UUID          `json:"conversation_id"`
+	ID                uuid.UUID          `json:"id"`
+	LastSeen          pgtype.Timestamptz `json:"last_seen"`
+	Username          string             `json:"username"`
+	RequestPending    bool               `json:"request_pending"`
+	DeleteRequestedBy pgtype.UUID        `json:"delete_requested_by"`
+	LastActivityAt    pgtype.Timestamptz `json:"last_activity_at"`
+	UnreadMsgCount    int64              `json:"unread_msg_count"`
 }
 
-func (q *Queries) GetConversationsByUserID(ctx context.Context, senderID uuid.UUID) ([]GetConversationsByUserIDRow, error) {
-	rows, err := q.db.Query(ctx, getConversationsByUserID, senderID)
+// Most recently active first, pass the last row's (last_activity_at, conversation_id) to continue
+func (q *Queries) GetConversationsByUserID(ctx context.Context, arg GetConversationsByUserIDParams) ([]GetConversationsByUserIDRow, error) {
+	rows, err := q.db.Query(ctx, getConversationsByUserID,
+		arg.UserID,
+		arg.Pinned,
+		arg.Archived,
+		arg.BeforeActivity,
+		arg.BeforeID,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -115,6 +461,9 @@ func (q *Queries) GetConversationsByUserID(ctx context.Context, senderID uuid.UU
 			&i.ID,
 			&i.LastSeen,
 			&i.Username,
+			&i.RequestPending,
+			&i.DeleteRequestedBy,
+			&i.LastActivityAt,
 			&i.UnreadMsgCount,
 		); err != nil {
 			return nil, err
@@ -126,3 +475,293 @@ func (q *Queries) GetConversationsByUserID(ctx context.Context, senderID uuid.UU
 	}
 	return items, nil
 }
+
+const getGroupConversationsByUserID = `-- name: GetGroupConversationsByUserID :many
+SELECT
+    c.id AS conversation_id,
+    c.title,
+    c.created_by,
+    c.created_at,
+    c.last_activity_at,
+    (
+        SELECT COUNT(*)
+        FROM conversation_members cm2
+        WHERE cm2.conversation_id = c.id
+    ) AS member_count,
+    (
+        SELECT COUNT(m.id)
+        FROM messages m
+        WHERE m.conversation_id = c.id
+          AND m.sender_id != $1::uuid
+          AND NOT EXISTS (
+              SELECT 1 FROM message_receipts r
+              WHERE r.message_id = m.id AND r.user_id = $1::uuid AND r.read_at IS NOT NULL
+          )
+          AND NOT EXISTS (
+              SELECT 1 FROM hidden_messages h
+              WHERE h.message_id = m.id AND h.user_id = $1::uuid
+          )
+          AND (s.cleared_at IS NULL OR m.created_at > s.cleared_at)
+    ) AS unread_msg_count
+FROM
+    conversations c
+JOIN
+    conversation_members cm
+    ON cm.conversation_id = c.id
+LEFT JOIN
+    conversation_user_settings s
+    ON s.conversation_id = c.id AND s.user_id = $1::uuid
+WHERE
+    c.is_group
+    AND cm.user_id = $1::uuid
+    AND (s.pin_position IS NOT NULL) = $2::boolean
+    AND (s.archived_at IS NOT NULL AND s.archived_at >= c.last_activity_at) = $3::boolean
+    AND NOT (s.deleted_at IS NOT NULL AND s.deleted_at >= c.last_activity_at)
+    AND (
+        $4::timestamptz IS NULL
+        OR (c.last_activity_at, c.id) < ($4::timestamptz, $5::uuid)
+    )
+ORDER BY c.last_activity_at DESC, c.id DESC
+LIMIT $6
+`
+
+type GetGroupConversationsByUserIDParams struct {
+	UserID         uuid.UUID          `json:"user_id"`
+	Pinned         bool               `json:"pinned"`
+	Archived       bool               `json:"archived"`
+	BeforeActivity pgtype.Timestamptz `json:"before_activity"`
+	BeforeID       pgtype.UUID        `json:"before_id"`
+	PageSize       int32              `json:"page_size"`
+}
+
+type GetGroupConversationsByUserIDRow struct {
+	ConversationID uuid.UUID          `json:"conversation_id"`
+	Title          pgtype.Text        `json:"title"`
+	CreatedBy      pgtype.UUID        `json:"created_by"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	LastActivityAt pgtype.Timestamptz `json:"last_activity_at"`
+	MemberCount    int64              `json:"member_count"`
+	UnreadMsgCount int64              `json:"unread_msg_count"`
+}
+
+// Same order and cursor as GetConversationsByUserID
+func (q *Queries) GetGroupConversationsByUserID(ctx context.Context, arg GetGroupConversationsByUserIDParams) ([]GetGroupConversationsByUserIDRow, error) {
+	rows, err := q.db.Query(ctx, getGroupConversationsByUserID,
+		arg.UserID,
+		arg.Pinned,
+		arg.Archived,
+		arg.BeforeActivity,
+		arg.BeforeID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetGroupConversationsByUserIDRow
+	for rows.Next() {
+		var i GetGroupConversationsByUserIDRow
+		if err := rows.Scan(
+			&i.ConversationID,
+			&i.Title,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.LastActivityAt,
+			&i.MemberCount,
+			&i.UnreadMsgCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMembersOfConversations = `-- name: GetMembersOfConversations :many
+SELECT conversation_id, user_id FROM conversation_members
+WHERE conversation_id = ANY($1::uuid[])
+`
+
+type GetMembersOfConversationsRow struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) GetMembersOfConversations(ctx context.Context, conversationIds []uuid.UUID) ([]GetMembersOfConversationsRow, error) {
+	rows, err := q.db.Query(ctx, getMembersOfConversations, conversationIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetMembersOfConversationsRow
+	for rows.Next() {
+		var i GetMembersOfConversationsRow
+		if err := rows.Scan(&i.ConversationID, &i.UserID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMessageRequests = `-- name: GetMessageRequests :many
+SELECT
+    c.id AS conversation_id,
+    u.id,
+    u.username,
+    u.last_seen,
+    c.created_at
+FROM conversations c
+JOIN users u ON u.id = c.user1
+WHERE c.request_pending AND c.user2 = $1::uuid
+ORDER BY c.created_at DESC
+`
+
+type GetMessageRequestsRow struct {
+	ConversationID uuid.UUID          `json:"conversation_id"`
+	ID             uuid.UUID          `json:"id"`
+	Username       string             `json:"username"`
+	LastSeen       pgtype.Timestamptz `json:"last_seen"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+// Direct conversations strangers started with the user, waiting to be accepted
+func (q *Queries) GetMessageRequests(ctx context.Context, userID uuid.UUID) ([]GetMessageRequestsRow, error) {
+	rows, err := q.db.Query(ctx, getMessageRequests, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetMessageRequestsRow
+	for rows.Next() {
+		var i GetMessageRequestsRow
+		if err := rows.Scan(
+			&i.ConversationID,
+			&i.ID,
+			&i.Username,
+			&i.LastSeen,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isConversationMember = `-- name: IsConversationMember :one
+SELECT EXISTS (
+    SELECT 1 FROM conversation_members WHERE conversation_id = $1 AND user_id = $2
+)
+`
+
+type IsConversationMemberParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) IsConversationMember(ctx context.Context, arg IsConversationMemberParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isConversationMember, arg.ConversationID, arg.UserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const isMessageRequestDeclined = `-- name: IsMessageRequestDeclined :one
+SELECT EXISTS (
+    SELECT 1 FROM message_request_declines
+    WHERE requester_id = $1::uuid
+      AND recipient_id = $2::uuid
+      AND declined_at > $3::timestamptz
+)
+`
+
+type IsMessageRequestDeclinedParams struct {
+	RequesterID   uuid.UUID `json:"requester_id"`
+	RecipientID   uuid.UUID `json:"recipient_id"`
+	DeclinedAfter time.Time `json:"declined_after"`
+}
+
+// Whether the recipient declined a request of the requester since declined_after
+func (q *Queries) IsMessageRequestDeclined(ctx context.Context, arg IsMessageRequestDeclinedParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isMessageRequestDeclined, arg.RequesterID, arg.RecipientID, arg.DeclinedAfter)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const recordMessageRequestDecline = `-- name: RecordMessageRequestDecline :exec
+INSERT INTO message_request_declines (requester_id, recipient_id)
+VALUES ($1::uuid, $2::uuid)
+ON CONFLICT (requester_id, recipient_id) DO UPDATE
+SET declined_at = CURRENT_TIMESTAMP
+`
+
+type RecordMessageRequestDeclineParams struct {
+	RequesterID uuid.UUID `json:"requester_id"`
+	RecipientID uuid.UUID `json:"recipient_id"`
+}
+
+func (q *Queries) RecordMessageRequestDecline(ctx context.Context, arg RecordMessageRequestDeclineParams) error {
+	_, err := q.db.Exec(ctx, recordMessageRequestDecline, arg.RequesterID, arg.RecipientID)
+	return err
+}
+
+const removeConversationMember = `-- name: RemoveConversationMember :execrows
+DELETE FROM conversation_members
+WHERE conversation_id = $1 AND user_id = $2
+`
+
+type RemoveConversationMemberParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) RemoveConversationMember(ctx context.Context, arg RemoveConversationMemberParams) (int64, error) {
+	result, err := q.db.Exec(ctx, removeConversationMember, arg.ConversationID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const requestConversationDeletion = `-- name: RequestConversationDeletion :one
+UPDATE conversations
+SET delete_requested_by = $1::uuid,
+    delete_requested_at = CURRENT_TIMESTAMP
+WHERE id = $2 AND NOT is_group
+RETURNING id, user1, user2, created_at, is_group, title, created_by, request_pending, last_activity_at, delete_requested_by, delete_requested_at
+`
+
+type RequestConversationDeletionParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	ID     uuid.UUID `json:"id"`
+}
+
+func (q *Queries) RequestConversationDeletion(ctx context.Context, arg RequestConversationDeletionParams) (Conversation, error) {
+	row := q.db.QueryRow(ctx, requestConversationDeletion, arg.UserID, arg.ID)
+	var i Conversation
+	err := row.Scan(
+		&i.ID,
+		&i.User1,
+		&i.User2,
+		&i.CreatedAt,
+		&i.IsGroup,
+		&i.Title,
+		&i.CreatedBy,
+		&i.RequestPending,
+		&i.LastActivityAt,
+		&i.DeleteRequestedBy,
+		&i.DeleteRequestedAt,
+	)
+	return i, err
+}
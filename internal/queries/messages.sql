@@ -1,36 +1,164 @@
 -- name: CreateMessage :one
+-- Moves the conversation to the top of the members' lists in the same statement
+WITH touched AS (
+    UPDATE conversations
+    SET last_activity_at = CURRENT_TIMESTAMP
+    WHERE id = $2
+)
 INSERT INTO messages (
-    sender_id, 
-    conversation_id, 
-    content
+    sender_id,
+    conversation_id,
+    content,
+    reply_to_id
 ) VALUES (
-    $1, 
-    (
-        SELECT id FROM conversations 
-        WHERE (user1 = $1 AND user2 = $2) 
-           OR (user1 = $2 AND user2 = $1)
-        LIMIT 1
-    ), 
-    $3
+    $1,
+    $2,
+    $3,
+    $4
 )
 RETURNING *;
 
--- name: GetMessagesByConversationID :many
--- Ordered by created_at DESC for easy pagination
+-- name: CountSenderMessages :one
+SELECT COUNT(*) FROM messages
+WHERE conversation_id = @conversation_id AND sender_id = @sender_id;
+
+-- name: GetMessageByID :one
+SELECT * FROM messages WHERE id = $1;
+
+-- name: GetVisibleMessageByID :one
+-- Same as GetMessageByID but misses messages the viewer deleted or cleared for themselves
+SELECT * FROM messages
+WHERE id = @id
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = @viewer_id
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = messages.conversation_id AND s.user_id = @viewer_id AND s.cleared_at >= messages.created_at
+  );
+
+-- name: GetQuotedMessages :many
+SELECT
+    m.id,
+    m.sender_id,
+    u.username AS sender_username,
+    m.content,
+    m.deleted_at
+FROM messages m
+JOIN users u ON u.id = m.sender_id
+WHERE m.id = ANY(@ids::uuid[]);
+
+-- name: GetLatestMessages :many
+-- Newest first, the caller flips the page into chronological order
+SELECT *
+FROM messages
+WHERE messages.conversation_id = @conversation_id
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = @viewer_id
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = messages.conversation_id AND s.user_id = @viewer_id AND s.cleared_at >= messages.created_at
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT @page_size;
+
+-- name: GetMessagesBefore :many
+-- Newest first, the caller flips the page into chronological order
+SELECT *
+FROM messages
+WHERE messages.conversation_id = @conversation_id
+  AND (created_at, id) < (@created_at::timestamptz, @id::uuid)
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = @viewer_id
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = messages.conversation_id AND s.user_id = @viewer_id AND s.cleared_at >= messages.created_at
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT @page_size;
+
+-- name: GetMessagesAfter :many
 SELECT *
 FROM messages
-WHERE conversation_id = $1 
-ORDER BY created_at ASC
-LIMIT $2 OFFSET $3;
+WHERE messages.conversation_id = @conversation_id
+  AND (created_at, id) > (@created_at::timestamptz, @id::uuid)
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = @viewer_id
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = messages.conversation_id AND s.user_id = @viewer_id AND s.cleared_at >= messages.created_at
+  )
+ORDER BY created_at ASC, id ASC
+LIMIT @page_size;
 
--- name: MarkMessagesAsRead :many
--- Marks all messages sent FROM the contact TO the current user as read
+-- name: GetMessageByIDForUpdate :one
+SELECT * FROM messages WHERE id = $1 FOR UPDATE;
+
+-- name: EditMessage :one
+-- Only the sender can edit, and only while the message is newer than edit_window_start
+UPDATE messages
+SET content = @content,
+    edited_at = CURRENT_TIMESTAMP
+WHERE id = @id
+  AND sender_id = @sender_id
+  AND created_at > @edit_window_start::timestamptz
+  AND deleted_at IS NULL
+RETURNING *;
+
+-- name: CreateMessageEdit :exec
+INSERT INTO message_edits (message_id, content) VALUES ($1, $2);
+
+-- name: GetMessageEdits :many
+SELECT * FROM message_edits
+WHERE message_id = $1
+ORDER BY edited_at ASC;
+
+-- name: DeleteMessageForEveryone :one
+-- Leaves a tombstone behind so the history keeps its order
 UPDATE messages
-SET is_read = TRUE
-WHERE sender_id = $1 
-  AND conversation_id = $2 
-  AND is_read = FALSE RETURNING id;
-
--- name: DeleteMessage :exec
-DELETE FROM messages
-WHERE id = $1;
+SET content = '',
+    deleted_at = CURRENT_TIMESTAMP
+WHERE id = @id
+  AND sender_id = @sender_id
+  AND deleted_at IS NULL
+RETURNING *;
+
+-- name: DeleteMessageEdits :exec
+DELETE FROM message_edits WHERE message_id = $1;
+
+-- name: HideMessage :exec
+INSERT INTO hidden_messages (message_id, user_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: GetLastMessages :many
+-- The newest message of every conversation the viewer can still see, with how far it got
+SELECT DISTINCT ON (m.conversation_id)
+    m.conversation_id,
+    m.id,
+    m.sender_id,
+    LEFT(m.content, 200)::text AS preview,
+    m.created_at,
+    m.deleted_at,
+    (SELECT COUNT(*) FROM attachments a WHERE a.message_id = m.id) AS attachment_count,
+    ((SELECT COUNT(*) FROM conversation_members cm WHERE cm.conversation_id = m.conversation_id) - 1)::bigint AS recipient_count,
+    (SELECT COUNT(*) FROM message_receipts r WHERE r.message_id = m.id) AS delivered_count,
+    (SELECT COUNT(r.read_at) FROM message_receipts r WHERE r.message_id = m.id) AS read_count
+FROM messages m
+WHERE m.conversation_id = ANY(@conversation_ids::uuid[])
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = m.id AND h.user_id = @viewer_id
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = m.conversation_id AND s.user_id = @viewer_id AND s.cleared_at >= m.created_at
+  )
+ORDER BY m.conversation_id, m.created_at DESC, m.id DESC;
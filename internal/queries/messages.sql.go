@@ -7,75 +7,276 @@ package queries
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const countSenderMessages = `-- name: CountSenderMessages :one
+SELECT COUNT(*) FROM messages
+WHERE conversation_id = $1 AND sender_id = $2
+`
+
+type CountSenderMessagesParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+}
+
+func (q *Queries) CountSenderMessages(ctx context.Context, arg CountSenderMessagesParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countSenderMessages, arg.ConversationID, arg.SenderID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createMessage = `-- name: CreateMessage :one
+WITH touched AS (
+    UPDATE conversations
+    SET last_activity_at = CURRENT_TIMESTAMP
+    WHERE id = $2
+)
 INSERT INTO messages (
-    sender_id, 
-    conversation_id, 
-    content
+    sender_id,
+    conversation_id,
+    content,
+    reply_to_id
 ) VALUES (
-    $1, 
-    (
-        SELECT id FROM conversations 
-        WHERE (user1 = $1 AND user2 = $2) 
-           OR (user1 = $2 AND user2 = $1)
-        LIMIT 1
-    ), 
-    $3
+    $1,
+    $2,
+    $3,
+    $4
 )
-RETURNING id, conversation_id, sender_id, content, is_read, created_at
+RETURNING id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector
 `
 
 type CreateMessageParams struct {
-	SenderID uuid.UUID `json:"sender_id"`
-	User2    uuid.UUID `json:"user2"`
-	Content  string    `json:"content"`
+	SenderID       uuid.UUID   `json:"sender_id"`
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	Content        string      `json:"content"`
+	ReplyToID      pgtype.UUID `json:"reply_to_id"`
 }
 
+// Moves the conversation to the top of the members' lists in the same statement
 func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (Message, error) {
-	row := q.db.QueryRow(ctx, createMessage, arg.SenderID, arg.User2, arg.Content)
+	row := q.db.QueryRow(ctx, createMessage,
+		arg.SenderID,
+		arg.ConversationID,
+		arg.Content,
+		arg.ReplyToID,
+	)
 	var i Message
 	err := row.Scan(
 		&i.ID,
 		&i.ConversationID,
 		&i.SenderID,
 		&i.Content,
-		&i.IsRead,
 		&i.CreatedAt,
+		&i.EditedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+		&i.SearchVector,
 	)
 	return i, err
 }
 
-const deleteMessage = `-- name: DeleteMessage :exec
-DELETE FROM messages
-WHERE id = $1
+const createMessageEdit = `-- name: CreateMessageEdit :exec
+INSERT INTO message_edits (message_id, content) VALUES ($1, $2)
+`
+
+type CreateMessageEditParams struct {
+	MessageID uuid.UUID `json:"message_id"`
+	Content   string    `json:"content"`
+}
+
+func (q *Queries) CreateMessageEdit(ctx context.Context, arg CreateMessageEditParams) error {
+	_, err := q.db.Exec(ctx, createMessageEdit, arg.MessageID, arg.Content)
+	return err
+}
+
+const deleteMessageEdits = `-- name: DeleteMessageEdits :exec
+DELETE FROM message_edits WHERE message_id = $1
 `
 
-func (q *Queries) DeleteMessage(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, deleteMessage, id)
+func (q *Queries) DeleteMessageEdits(ctx context.Context, messageID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteMessageEdits, messageID)
 	return err
 }
 
-const getMessagesByConversationID = `-- name: GetMessagesByConversationID :many
-SELECT id, conversation_id, sender_id, content, is_read, created_at
+const deleteMessageForEveryone = `-- name: DeleteMessageForEveryone :one
+UPDATE messages
+SET content = '',
+    deleted_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND sender_id = $2
+  AND deleted_at IS NULL
+RETURNING id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector
+`
+
+type DeleteMessageForEveryoneParams struct {
+	ID       uuid.UUID `json:"id"`
+	SenderID uuid.UUID `json:"sender_id"`
+}
+
+// Leaves a tombstone behind so the history keeps its order
+func (q *Queries) DeleteMessageForEveryone(ctx context.Context, arg DeleteMessageForEveryoneParams) (Message, error) {
+	row := q.db.QueryRow(ctx, deleteMessageForEveryone, arg.ID, arg.SenderID)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.CreatedAt,
+		&i.EditedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const editMessage = `-- name: EditMessage :one
+UPDATE messages
+SET content = $1,
+    edited_at = CURRENT_TIMESTAMP
+WHERE id = $2
+  AND sender_id = $3
+  AND created_at > $4::timestamptz
+  AND deleted_at IS NULL
+RETURNING id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector
+`
+
+type EditMessageParams struct {
+	Content         string    `json:"content"`
+	ID              uuid.UUID `json:"id"`
+	SenderID        uuid.UUID `json:"sender_id"`
+	EditWindowStart time.Time `json:"edit_window_start"`
+}
+
+// Only the sender can edit, and only while the message is newer than edit_window_start
+func (q *Queries) EditMessage(ctx context.Context, arg EditMessageParams) (Message, error) {
+	row := q.db.QueryRow(ctx, editMessage,
+		arg.Content,
+		arg.ID,
+		arg.SenderID,
+		arg.EditWindowStart,
+	)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.CreatedAt,
+		&i.EditedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const getLastMessages = `-- name: GetLastMessages :many
+SELECT DISTINCT ON (m.conversation_id)
+    m.conversation_id,
+    m.id,
+    m.sender_id,
+    LEFT(m.content, 200)::text AS preview,
+    m.created_at,
+    m.deleted_at,
+    (SELECT COUNT(*) FROM attachments a WHERE a.message_id = m.id) AS attachment_count,
+    ((SELECT COUNT(*) FROM conversation_members cm WHERE cm.conversation_id = m.conversation_id) - 1)::bigint AS recipient_count,
+    (SELECT COUNT(*) FROM message_receipts r WHERE r.message_id = m.id) AS delivered_count,
+    (SELECT COUNT(r.read_at) FROM message_receipts r WHERE r.message_id = m.id) AS read_count
+FROM messages m
+WHERE m.conversation_id = ANY($1::uuid[])
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = m.id AND h.user_id = $2
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = m.conversation_id AND s.user_id = $2 AND s.cleared_at >= m.created_at
+  )
+ORDER BY m.conversation_id, m.created_at DESC, m.id DESC
+`
+
+type GetLastMessagesParams struct {
+	ConversationIds []uuid.UUID `json:"conversation_ids"`
+	ViewerID        uuid.UUID   `json:"viewer_id"`
+}
+
+type GetLastMessagesRow struct {
+	ConversationID  uuid.UUID          `json:"conversation_id"`
+	ID              uuid.UUID          `json:"id"`
+	SenderID        uuid.UUID          `json:"sender_id"`
+	Preview         string             `json:"preview"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	DeletedAt       pgtype.Timestamptz `json:"deleted_at"`
+	AttachmentCount int64              `json:"attachment_count"`
+	RecipientCount  int64              `json:"recipient_count"`
+	DeliveredCount  int64              `json:"delivered_count"`
+	ReadCount       int64              `json:"read_count"`
+}
+
+// The newest message of every conversation the viewer can still see, with how far it got
+func (q *Queries) GetLastMessages(ctx context.Context, arg GetLastMessagesParams) ([]GetLastMessagesRow, error) {
+	rows, err := q.db.Query(ctx, getLastMessages, arg.ConversationIds, arg.ViewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetLastMessagesRow
+	for rows.Next() {
+		var i GetLastMessagesRow
+		if err := rows.Scan(
+			&i.ConversationID,
+			&i.ID,
+			&i.SenderID,
+			&i.Preview,
+			&i.CreatedAt,
+			&i.DeletedAt,
+			&i.AttachmentCount,
+			&i.RecipientCount,
+			&i.DeliveredCount,
+			&i.ReadCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestMessages = `-- name: GetLatestMessages :many
+SELECT id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector
 FROM messages
-WHERE conversation_id = $1 
-ORDER BY created_at ASC
-LIMIT $2 OFFSET $3
+WHERE messages.conversation_id = $1
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = $2
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = messages.conversation_id AND s.user_id = $2 AND s.cleared_at >= messages.created_at
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT $3
 `
 
-type GetMessagesByConversationIDParams struct {
+type GetLatestMessagesParams struct {
 	ConversationID uuid.UUID `json:"conversation_id"`
-	Limit          int32     `json:"limit"`
-	Offset         int32     `json:"offset"`
+	ViewerID       uuid.UUID `json:"viewer_id"`
+	PageSize       int32     `json:"page_size"`
 }
 
-// Ordered by created_at DESC for easy pagination
-func (q *Queries) GetMessagesByConversationID(ctx context.Context, arg GetMessagesByConversationIDParams) ([]Message, error) {
-	rows, err := q.db.Query(ctx, getMessagesByConversationID, arg.ConversationID, arg.Limit, arg.Offset)
+// Newest first, the caller flips the page into chronological order
+func (q *Queries) GetLatestMessages(ctx context.Context, arg GetLatestMessagesParams) ([]Message, error) {
+	rows, err := q.db.Query(ctx, getLatestMessages, arg.ConversationID, arg.ViewerID, arg.PageSize)
 	if err != nil {
 		return nil, err
 	}
@@ -88,8 +289,11 @@ func (q *Queries) GetMessagesByConversationID(ctx context.Context, arg GetMessag
 			&i.ConversationID,
 			&i.SenderID,
 			&i.Content,
-			&i.IsRead,
 			&i.CreatedAt,
+			&i.EditedAt,
+			&i.DeletedAt,
+			&i.ReplyToID,
+			&i.SearchVector,
 		); err != nil {
 			return nil, err
 		}
@@ -101,36 +305,296 @@ func (q *Queries) GetMessagesByConversationID(ctx context.Context, arg GetMessag
 	return items, nil
 }
 
-const markMessagesAsRead = `-- name: MarkMessagesAsRead :many
-UPDATE messages
-SET is_read = TRUE
-WHERE sender_id = $1 
-  AND conversation_id = $2 
-  AND is_read = FALSE RETURNING id
+const getMessageByID = `-- name: GetMessageByID :one
+SELECT id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector FROM messages WHERE id = $1
 `
 
-type MarkMessagesAsReadParams struct {
-	SenderID       uuid.UUID `json:"sender_id"`
+func (q *Queries) GetMessageByID(ctx context.Context, id uuid.UUID) (Message, error) {
+	row := q.db.QueryRow(ctx, getMessageByID, id)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.CreatedAt,
+		&i.EditedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const getMessageByIDForUpdate = `-- name: GetMessageByIDForUpdate :one
+SELECT id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector FROM messages WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) GetMessageByIDForUpdate(ctx context.Context, id uuid.UUID) (Message, error) {
+	row := q.db.QueryRow(ctx, getMessageByIDForUpdate, id)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.CreatedAt,
+		&i.EditedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const getMessageEdits = `-- name: GetMessageEdits :many
+SELECT id, message_id, content, edited_at FROM message_edits
+WHERE message_id = $1
+ORDER BY edited_at ASC
+`
+
+func (q *Queries) GetMessageEdits(ctx context.Context, messageID uuid.UUID) ([]MessageEdit, error) {
+	rows, err := q.db.Query(ctx, getMessageEdits, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MessageEdit
+	for rows.Next() {
+		var i MessageEdit
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.Content,
+			&i.EditedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMessagesAfter = `-- name: GetMessagesAfter :many
+SELECT id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector
+FROM messages
+WHERE messages.conversation_id = $1
+  AND (created_at, id) > ($2::timestamptz, $3::uuid)
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = $4
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = messages.conversation_id AND s.user_id = $4 AND s.cleared_at >= messages.created_at
+  )
+ORDER BY created_at ASC, id ASC
+LIMIT $5
+`
+
+type GetMessagesAfterParams struct {
 	ConversationID uuid.UUID `json:"conversation_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ID             uuid.UUID `json:"id"`
+	ViewerID       uuid.UUID `json:"viewer_id"`
+	PageSize       int32     `json:"page_size"`
+}
+
+func (q *Queries) GetMessagesAfter(ctx context.Context, arg GetMessagesAfterParams) ([]Message, error) {
+	rows, err := q.db.Query(ctx, getMessagesAfter,
+		arg.ConversationID,
+		arg.CreatedAt,
+		arg.ID,
+		arg.ViewerID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.DeletedAt,
+			&i.ReplyToID,
+			&i.SearchVector,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMessagesBefore = `-- name: GetMessagesBefore :many
+SELECT id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector
+FROM messages
+WHERE messages.conversation_id = $1
+  AND (created_at, id) < ($2::timestamptz, $3::uuid)
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = $4
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = messages.conversation_id AND s.user_id = $4 AND s.cleared_at >= messages.created_at
+  )
+ORDER BY created_at DESC, id DESC
+LIMIT $5
+`
+
+type GetMessagesBeforeParams struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ID             uuid.UUID `json:"id"`
+	ViewerID       uuid.UUID `json:"viewer_id"`
+	PageSize       int32     `json:"page_size"`
+}
+
+// Newest first, the caller flips the page into chronological order
+func (q *Queries) GetMessagesBefore(ctx context.Context, arg GetMessagesBeforeParams) ([]Message, error) {
+	rows, err := q.db.Query(ctx, getMessagesBefore,
+		arg.ConversationID,
+		arg.CreatedAt,
+		arg.ID,
+		arg.ViewerID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.DeletedAt,
+			&i.ReplyToID,
+			&i.SearchVector,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-// Marks all messages sent FROM the contact TO the current user as read
-func (q *Queries) MarkMessagesAsRead(ctx context.Context, arg MarkMessagesAsReadParams) ([]uuid.UUID, error) {
-	rows, err := q.db.Query(ctx, markMessagesAsRead, arg.SenderID, arg.ConversationID)
+const getQuotedMessages = `-- name: GetQuotedMessages :many
+SELECT
+    m.id,
+    m.sender_id,
+    u.username AS sender_username,
+    m.content,
+    m.deleted_at
+FROM messages m
+JOIN users u ON u.id = m.sender_id
+WHERE m.id = ANY($1::uuid[])
+`
+
+type GetQuotedMessagesRow struct {
+	ID             uuid.UUID          `json:"id"`
+	SenderID       uuid.UUID          `json:"sender_id"`
+	SenderUsername string             `json:"sender_username"`
+	Content        string             `json:"content"`
+	DeletedAt      pgtype.Timestamptz `json:"deleted_at"`
+}
+
+func (q *Queries) GetQuotedMessages(ctx context.Context, ids []uuid.UUID) ([]GetQuotedMessagesRow, error) {
+	rows, err := q.db.Query(ctx, getQuotedMessages, ids)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []uuid.UUID
+	var items []GetQuotedMessagesRow
 	for rows.Next() {
-		var id uuid.UUID
-		if err := rows.Scan(&id); err != nil {
+		var i GetQuotedMessagesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.SenderID,
+			&i.SenderUsername,
+			&i.Content,
+			&i.DeletedAt,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, id)
+		items = append(items, i)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 	return items, nil
 }
+
+const getVisibleMessageByID = `-- name: GetVisibleMessageByID :one
+SELECT id, conversation_id, sender_id, content, created_at, edited_at, deleted_at, reply_to_id, search_vector FROM messages
+WHERE id = $1
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = $2
+  )
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_user_settings s
+      WHERE s.conversation_id = messages.conversation_id AND s.user_id = $2 AND s.cleared_at >= messages.created_at
+  )
+`
+
+type GetVisibleMessageByIDParams struct {
+	ID       uuid.UUID `json:"id"`
+	ViewerID uuid.UUID `json:"viewer_id"`
+}
+
+// Same as GetMessageByID but misses messages the viewer deleted or cleared for themselves
+func (q *Queries) GetVisibleMessageByID(ctx context.Context, arg GetVisibleMessageByIDParams) (Message, error) {
+	row := q.db.QueryRow(ctx, getVisibleMessageByID, arg.ID, arg.ViewerID)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.CreatedAt,
+		&i.EditedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const hideMessage = `-- name: HideMessage :exec
+INSERT INTO hidden_messages (message_id, user_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING
+`
+
+type HideMessageParams struct {
+	MessageID uuid.UUID `json:"message_id"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) HideMessage(ctx context.Context, arg HideMessageParams) error {
+	_, err := q.db.Exec(ctx, hideMessage, arg.MessageID, arg.UserID)
+	return err
+}
@@ -9,6 +9,33 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type ApiNode struct {
+	ID          uuid.UUID          `json:"id"`
+	StartedAt   pgtype.Timestamptz `json:"started_at"`
+	HeartbeatAt pgtype.Timestamptz `json:"heartbeat_at"`
+}
+
+type Attachment struct {
+	ID             uuid.UUID          `json:"id"`
+	UploaderID     uuid.UUID          `json:"uploader_id"`
+	ConversationID uuid.UUID          `json:"conversation_id"`
+	MessageID      pgtype.UUID        `json:"message_id"`
+	StorageKey     string             `json:"-"`
+	FileName       string             `json:"file_name"`
+	MimeType       string             `json:"mime_type"`
+	SizeBytes      int64              `json:"size_bytes"`
+	ChecksumSha256 string             `json:"checksum_sha256"`
+	Width          pgtype.Int4        `json:"width"`
+	Height         pgtype.Int4        `json:"height"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+type BrokerPayload struct {
+	ID        int64              `json:"id"`
+	Payload   string             `json:"payload"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
 type Contact struct {
 	ID            uuid.UUID          `json:"id"`
 	UserID        uuid.UUID          `json:"user_id"`
@@ -18,10 +45,40 @@ type Contact struct {
 }
 
 type Conversation struct {
-	ID        uuid.UUID          `json:"id"`
-	User1     uuid.UUID          `json:"user1"`
-	User2     uuid.UUID          `json:"user2"`
-	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	ID                uuid.UUID          `json:"id"`
+	User1             pgtype.UUID        `json:"user1"`
+	User2             pgtype.UUID        `json:"user2"`
+	CreatedAt         pgtype.Timestamptz `json:"created_at"`
+	IsGroup           bool               `json:"is_group"`
+	Title             pgtype.Text        `json:"title"`
+	CreatedBy         pgtype.UUID        `json:"created_by"`
+	RequestPending    bool               `json:"request_pending"`
+	LastActivityAt    pgtype.Timestamptz `json:"last_activity_at"`
+	DeleteRequestedBy pgtype.UUID        `json:"delete_requested_by"`
+	DeleteRequestedAt pgtype.Timestamptz `json:"delete_requested_at"`
+}
+
+type ConversationMember struct {
+	ConversationID uuid.UUID          `json:"conversation_id"`
+	UserID         uuid.UUID          `json:"user_id"`
+	JoinedAt       pgtype.Timestamptz `json:"joined_at"`
+}
+
+type ConversationUserSetting struct {
+	ConversationID uuid.UUID          `json:"conversation_id"`
+	UserID         uuid.UUID          `json:"user_id"`
+	ArchivedAt     pgtype.Timestamptz `json:"archived_at"`
+	PinPosition    pgtype.Int4        `json:"pin_position"`
+	MutedAt        pgtype.Timestamptz `json:"muted_at"`
+	MutedUntil     pgtype.Timestamptz `json:"muted_until"`
+	DeletedAt      pgtype.Timestamptz `json:"deleted_at"`
+	ClearedAt      pgtype.Timestamptz `json:"cleared_at"`
+}
+
+type HiddenMessage struct {
+	MessageID uuid.UUID          `json:"message_id"`
+	UserID    uuid.UUID          `json:"user_id"`
+	HiddenAt  pgtype.Timestamptz `json:"hidden_at"`
 }
 
 type Message struct {
@@ -29,15 +86,92 @@ type Message struct {
 	ConversationID uuid.UUID          `json:"conversation_id"`
 	SenderID       uuid.UUID          `json:"sender_id"`
 	Content        string             `json:"content"`
-	IsRead         pgtype.Bool        `json:"is_read"`
 	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	EditedAt       pgtype.Timestamptz `json:"edited_at"`
+	DeletedAt      pgtype.Timestamptz `json:"deleted_at"`
+	ReplyToID      pgtype.UUID        `json:"reply_to_id"`
+	SearchVector   string             `json:"-"`
+}
+
+type MessageEdit struct {
+	ID        uuid.UUID          `json:"id"`
+	MessageID uuid.UUID          `json:"message_id"`
+	Content   string             `json:"content"`
+	EditedAt  pgtype.Timestamptz `json:"edited_at"`
+}
+
+type MessageReaction struct {
+	MessageID uuid.UUID          `json:"message_id"`
+	UserID    uuid.UUID          `json:"user_id"`
+	Emoji     string             `json:"emoji"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type MessageReceipt struct {
+	MessageID   uuid.UUID          `json:"message_id"`
+	UserID      uuid.UUID          `json:"user_id"`
+	DeliveredAt pgtype.Timestamptz `json:"delivered_at"`
+	ReadAt      pgtype.Timestamptz `json:"read_at"`
+}
+
+type MessageRequestDecline struct {
+	RequesterID uuid.UUID          `json:"requester_id"`
+	RecipientID uuid.UUID          `json:"recipient_id"`
+	DeclinedAt  pgtype.Timestamptz `json:"declined_at"`
+}
+
+type RefreshToken struct {
+	ID        uuid.UUID          `json:"id"`
+	UserID    uuid.UUID          `json:"user_id"`
+	FamilyID  uuid.UUID          `json:"family_id"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	UsedAt    pgtype.Timestamptz `json:"used_at"`
+	RevokedAt pgtype.Timestamptz `json:"revoked_at"`
+}
+
+type Session struct {
+	ID         uuid.UUID          `json:"id"`
+	UserID     uuid.UUID          `json:"user_id"`
+	DeviceName pgtype.Text        `json:"device_name"`
+	UserAgent  pgtype.Text        `json:"user_agent"`
+	IpAddress  pgtype.Text        `json:"ip_address"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	RevokedAt  pgtype.Timestamptz `json:"revoked_at"`
 }
 
 type User struct {
-	ID           uuid.UUID          `json:"id"`
-	Username     string             `json:"username"`
-	Email        string             `json:"email"`
-	PasswordHash string             `json:"-"`
-	CreatedAt    pgtype.Timestamptz `json:"created_at"`
-	LastSeen     pgtype.Timestamptz `json:"last_seen"`
+	ID             uuid.UUID          `json:"id"`
+	Username       string             `json:"username"`
+	Email          string             `json:"email"`
+	PasswordHash   string             `json:"-"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	LastSeen       pgtype.Timestamptz `json:"last_seen"`
+	MessagePrivacy string             `json:"message_privacy"`
+}
+
+type UserBlock struct {
+	BlockerID uuid.UUID          `json:"blocker_id"`
+	BlockedID uuid.UUID          `json:"blocked_id"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type UserEvent struct {
+	UserID    uuid.UUID          `json:"user_id"`
+	Seq       int64              `json:"seq"`
+	EventType string             `json:"event_type"`
+	Payload   []byte             `json:"payload"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type UserEventCounter struct {
+	UserID  uuid.UUID `json:"user_id"`
+	LastSeq int64     `json:"last_seq"`
+}
+
+type UserPresence struct {
+	UserID      uuid.UUID          `json:"user_id"`
+	NodeID      uuid.UUID          `json:"node_id"`
+	ConnectedAt pgtype.Timestamptz `json:"connected_at"`
 }
@@ -0,0 +1,64 @@
+-- name: RegisterNode :exec
+INSERT INTO api_nodes (id) VALUES (@id::uuid)
+ON CONFLICT (id) DO UPDATE SET heartbeat_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteNode :exec
+DELETE FROM api_nodes WHERE id = @id::uuid;
+
+-- name: DeleteStaleNodes :many
+-- The presence of their users goes with them. Returns the users who were only connected to them,
+-- the select still sees the presence the delete cascades away
+WITH stale AS (
+    DELETE FROM api_nodes WHERE heartbeat_at < @heartbeat_before::timestamptz
+    RETURNING id
+)
+SELECT DISTINCT p.user_id
+FROM user_presence p
+WHERE p.node_id IN (SELECT id FROM stale)
+  AND NOT EXISTS (
+      SELECT 1 FROM user_presence other
+      WHERE other.user_id = p.user_id
+        AND other.node_id NOT IN (SELECT id FROM stale)
+  );
+
+-- name: AddUserPresence :one
+-- Returns on how many live nodes the user is connected now, this one included
+WITH added AS (
+    INSERT INTO user_presence (user_id, node_id) VALUES (@user_id::uuid, @node_id::uuid)
+    ON CONFLICT (user_id, node_id) DO NOTHING
+)
+SELECT COUNT(*)::int + 1 AS nodes
+FROM user_presence p
+JOIN api_nodes n ON n.id = p.node_id
+WHERE p.user_id = @user_id::uuid
+  AND p.node_id <> @node_id::uuid
+  AND n.heartbeat_at >= @alive_since::timestamptz;
+
+-- name: RemoveUserPresence :one
+-- Returns on how many live nodes the user is still connected
+WITH removed AS (
+    DELETE FROM user_presence WHERE user_id = @user_id::uuid AND node_id = @node_id::uuid
+)
+SELECT COUNT(*)::int AS nodes
+FROM user_presence p
+JOIN api_nodes n ON n.id = p.node_id
+WHERE p.user_id = @user_id::uuid
+  AND p.node_id <> @node_id::uuid
+  AND n.heartbeat_at >= @alive_since::timestamptz;
+
+-- name: GetOnlineUserIDs :many
+SELECT DISTINCT p.user_id
+FROM user_presence p
+JOIN api_nodes n ON n.id = p.node_id
+WHERE p.user_id = ANY(@user_ids::uuid[])
+  AND n.heartbeat_at >= @alive_since::timestamptz;
+
+-- name: CreateBrokerPayload :one
+INSERT INTO broker_payloads (payload) VALUES (@payload::text)
+RETURNING id;
+
+-- name: GetBrokerPayload :one
+SELECT payload FROM broker_payloads WHERE id = @id::bigint;
+
+-- name: DeleteBrokerPayloadsBefore :execrows
+DELETE FROM broker_payloads WHERE created_at < @created_before::timestamptz;
@@ -0,0 +1,190 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: presence.sql
+
+package queries
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const addUserPresence = `-- name: AddUserPresence :one
+WITH added AS (
+    INSERT INTO user_presence (user_id, node_id) VALUES ($1::uuid, $2::uuid)
+    ON CONFLICT (user_id, node_id) DO NOTHING
+)
+SELECT COUNT(*)::int + 1 AS nodes
+FROM user_presence p
+JOIN api_nodes n ON n.id = p.node_id
+WHERE p.user_id = $1::uuid
+  AND p.node_id <> $2::uuid
+  AND n.heartbeat_at >= $3::timestamptz
+`
+
+type AddUserPresenceParams struct {
+	UserID     uuid.UUID `json:"user_id"`
+	NodeID     uuid.UUID `json:"node_id"`
+	AliveSince time.Time `json:"alive_since"`
+}
+
+// Returns on how many live nodes the user is connected now, this one included
+func (q *Queries) AddUserPresence(ctx context.Context, arg AddUserPresenceParams) (int32, error) {
+	row := q.db.QueryRow(ctx, addUserPresence, arg.UserID, arg.NodeID, arg.AliveSince)
+	var nodes int32
+	err := row.Scan(&nodes)
+	return nodes, err
+}
+
+const createBrokerPayload = `-- name: CreateBrokerPayload :one
+INSERT INTO broker_payloads (payload) VALUES ($1::text)
+RETURNING id
+`
+
+func (q *Queries) CreateBrokerPayload(ctx context.Context, payload string) (int64, error) {
+	row := q.db.QueryRow(ctx, createBrokerPayload, payload)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteBrokerPayloadsBefore = `-- name: DeleteBrokerPayloadsBefore :execrows
+DELETE FROM broker_payloads WHERE created_at < $1::timestamptz
+`
+
+func (q *Queries) DeleteBrokerPayloadsBefore(ctx context.Context, createdBefore time.Time) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteBrokerPayloadsBefore, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteNode = `-- name: DeleteNode :exec
+DELETE FROM api_nodes WHERE id = $1::uuid
+`
+
+func (q *Queries) DeleteNode(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteNode, id)
+	return err
+}
+
+const deleteStaleNodes = `-- name: DeleteStaleNodes :many
+WITH stale AS (
+    DELETE FROM api_nodes WHERE heartbeat_at < $1::timestamptz
+    RETURNING id
+)
+SELECT DISTINCT p.user_id
+FROM user_presence p
+WHERE p.node_id IN (SELECT id FROM stale)
+  AND NOT EXISTS (
+      SELECT 1 FROM user_presence other
+      WHERE other.user_id = p.user_id
+        AND other.node_id NOT IN (SELECT id FROM stale)
+  )
+`
+
+// The presence of their users goes with them. Returns the users who were only connected to them,
+// the select still sees the presence the delete cascades away
+func (q *Queries) DeleteStaleNodes(ctx context.Context, heartbeatBefore time.Time) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, deleteStaleNodes, heartbeatBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var user_id uuid.UUID
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getBrokerPayload = `-- name: GetBrokerPayload :one
+SELECT payload FROM broker_payloads WHERE id = $1::bigint
+`
+
+func (q *Queries) GetBrokerPayload(ctx context.Context, id int64) (string, error) {
+	row := q.db.QueryRow(ctx, getBrokerPayload, id)
+	var payload string
+	err := row.Scan(&payload)
+	return payload, err
+}
+
+const getOnlineUserIDs = `-- name: GetOnlineUserIDs :many
+SELECT DISTINCT p.user_id
+FROM user_presence p
+JOIN api_nodes n ON n.id = p.node_id
+WHERE p.user_id = ANY($1::uuid[])
+  AND n.heartbeat_at >= $2::timestamptz
+`
+
+type GetOnlineUserIDsParams struct {
+	UserIds    []uuid.UUID `json:"user_ids"`
+	AliveSince time.Time   `json:"alive_since"`
+}
+
+func (q *Queries) GetOnlineUserIDs(ctx context.Context, arg GetOnlineUserIDsParams) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, getOnlineUserIDs, arg.UserIds, arg.AliveSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var user_id uuid.UUID
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const registerNode = `-- name: RegisterNode :exec
+INSERT INTO api_nodes (id) VALUES ($1::uuid)
+ON CONFLICT (id) DO UPDATE SET heartbeat_at = CURRENT_TIMESTAMP
+`
+
+func (q *Queries) RegisterNode(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, registerNode, id)
+	return err
+}
+
+const removeUserPresence = `-- name: RemoveUserPresence :one
+WITH removed AS (
+    DELETE FROM user_presence WHERE user_id = $1::uuid AND node_id = $2::uuid
+)
+SELECT COUNT(*)::int AS nodes
+FROM user_presence p
+JOIN api_nodes n ON n.id = p.node_id
+WHERE p.user_id = $1::uuid
+  AND p.node_id <> $2::uuid
+  AND n.heartbeat_at >= $3::timestamptz
+`
+
+type RemoveUserPresenceParams struct {
+	UserID     uuid.UUID `json:"user_id"`
+	NodeID     uuid.UUID `json:"node_id"`
+	AliveSince time.Time `json:"alive_since"`
+}
+
+// Returns on how many live nodes the user is still connected
+func (q *Queries) RemoveUserPresence(ctx context.Context, arg RemoveUserPresenceParams) (int32, error) {
+	row := q.db.QueryRow(ctx, removeUserPresence, arg.UserID, arg.NodeID, arg.AliveSince)
+	var nodes int32
+	err := row.Scan(&nodes)
+	return nodes, err
+}
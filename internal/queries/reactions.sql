@@ -0,0 +1,29 @@
+-- name: AddReaction :exec
+INSERT INTO message_reactions (
+    message_id,
+    user_id,
+    emoji
+) VALUES (
+    $1,
+    $2,
+    $3
+) ON CONFLICT DO NOTHING;
+
+-- name: RemoveReaction :exec
+DELETE FROM message_reactions
+WHERE message_id = $1 AND user_id = $2 AND emoji = $3;
+
+-- name: CountReactions :one
+SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2;
+
+-- name: GetReactionSummaries :many
+-- One row per (message, emoji), in the order the emojis were first used
+SELECT
+    message_id,
+    emoji,
+    COUNT(*) AS count,
+    BOOL_OR(user_id = @viewer_id::uuid)::boolean AS reacted_by_me
+FROM message_reactions
+WHERE message_id = ANY(@message_ids::uuid[])
+GROUP BY message_id, emoji
+ORDER BY message_id, MIN(created_at) ASC;
@@ -0,0 +1,117 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: reactions.sql
+
+package queries
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const addReaction = `-- name: AddReaction :exec
+INSERT INTO message_reactions (
+    message_id,
+    user_id,
+    emoji
+) VALUES (
+    $1,
+    $2,
+    $3
+) ON CONFLICT DO NOTHING
+`
+
+type AddReactionParams struct {
+	MessageID uuid.UUID `json:"message_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Emoji     string    `json:"emoji"`
+}
+
+func (q *Queries) AddReaction(ctx context.Context, arg AddReactionParams) error {
+	_, err := q.db.Exec(ctx, addReaction, arg.MessageID, arg.UserID, arg.Emoji)
+	return err
+}
+
+const countReactions = `-- name: CountReactions :one
+SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2
+`
+
+type CountReactionsParams struct {
+	MessageID uuid.UUID `json:"message_id"`
+	Emoji     string    `json:"emoji"`
+}
+
+func (q *Queries) CountReactions(ctx context.Context, arg CountReactionsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countReactions, arg.MessageID, arg.Emoji)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const getReactionSummaries = `-- name: GetReactionSummaries :many
+SELECT
+    message_id,
+    emoji,
+    COUNT(*) AS count,
+    BOOL_OR(user_id = $1::uuid)::boolean AS reacted_by_me
+FROM message_reactions
+WHERE message_id = ANY($2::uuid[])
+GROUP BY message_id, emoji
+ORDER BY message_id, MIN(created_at) ASC
+`
+
+type GetReactionSummariesParams struct {
+	ViewerID   uuid.UUID   `json:"viewer_id"`
+	MessageIds []uuid.UUID `json:"message_ids"`
+}
+
+type GetReactionSummariesRow struct {
+	MessageID   uuid.UUID `json:"message_id"`
+	Emoji       string    `json:"emoji"`
+	Count       int64     `json:"count"`
+	ReactedByMe bool      `json:"reacted_by_me"`
+}
+
+// One row per (message, emoji), in the order the emojis were first used
+func (q *Queries) GetReactionSummaries(ctx context.Context, arg GetReactionSummariesParams) ([]GetReactionSummariesRow, error) {
+	rows, err := q.db.Query(ctx, getReactionSummaries, arg.ViewerID, arg.MessageIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetReactionSummariesRow
+	for rows.Next() {
+		var i GetReactionSummariesRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.Emoji,
+			&i.Count,
+			&i.ReactedByMe,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const removeReaction = `-- name: RemoveReaction :exec
+DELETE FROM message_reactions
+WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+`
+
+type RemoveReactionParams struct {
+	MessageID uuid.UUID `json:"message_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Emoji     string    `json:"emoji"`
+}
+
+func (q *Queries) RemoveReaction(ctx context.Context, arg RemoveReactionParams) error {
+	_, err := q.db.Exec(ctx, removeReaction, arg.MessageID, arg.UserID, arg.Emoji)
+	return err
+}
@@ -0,0 +1,55 @@
+-- name: MarkMessagesDelivered :many
+-- Only messages of the recipient's conversations that weren't delivered yet come back
+WITH delivered AS (
+    INSERT INTO message_receipts (message_id, user_id)
+    SELECT m.id, @recipient_id::uuid
+    FROM messages m
+    JOIN conversation_members cm
+        ON cm.conversation_id = m.conversation_id AND cm.user_id = @recipient_id::uuid
+    WHERE m.id = ANY(@message_ids::uuid[])
+      AND m.sender_id != @recipient_id::uuid
+    ON CONFLICT DO NOTHING
+    RETURNING message_id, delivered_at
+)
+SELECT d.message_id, d.delivered_at::timestamptz AS delivered_at, m.sender_id, m.conversation_id
+FROM delivered d
+JOIN messages m ON m.id = d.message_id;
+
+-- name: MarkConversationRead :many
+-- Marks every message the reader didn't send, reading implies delivery
+WITH read AS (
+    INSERT INTO message_receipts (message_id, user_id, read_at)
+    SELECT m.id, @reader_id::uuid, CURRENT_TIMESTAMP
+    FROM messages m
+    WHERE m.conversation_id = @conversation_id::uuid
+      AND m.sender_id != @reader_id::uuid
+      AND NOT EXISTS (
+          SELECT 1 FROM message_receipts r
+          WHERE r.message_id = m.id AND r.user_id = @reader_id::uuid AND r.read_at IS NOT NULL
+      )
+    ON CONFLICT (message_id, user_id) DO UPDATE SET read_at = EXCLUDED.read_at
+    RETURNING message_id, read_at
+)
+SELECT r.message_id, r.read_at::timestamptz AS read_at, m.sender_id
+FROM read r
+JOIN messages m ON m.id = r.message_id;
+
+-- name: GetMessageReceipts :many
+SELECT
+    r.user_id,
+    u.username,
+    r.delivered_at::timestamptz AS delivered_at,
+    r.read_at
+FROM message_receipts r
+JOIN users u ON u.id = r.user_id
+WHERE r.message_id = $1
+ORDER BY r.delivered_at ASC;
+
+-- name: GetReceiptCounts :many
+SELECT
+    message_id,
+    COUNT(*) AS delivered_count,
+    COUNT(read_at) AS read_count
+FROM message_receipts
+WHERE message_id = ANY(@message_ids::uuid[])
+GROUP BY message_id;
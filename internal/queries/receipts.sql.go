@@ -0,0 +1,200 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: receipts.sql
+
+package queries
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const getMessageReceipts = `-- name: GetMessageReceipts :many
+SELECT
+    r.user_id,
+    u.username,
+    r.delivered_at::timestamptz AS delivered_at,
+    r.read_at
+FROM message_receipts r
+JOIN users u ON u.id = r.user_id
+WHERE r.message_id = $1
+ORDER BY r.delivered_at ASC
+`
+
+type GetMessageReceiptsRow struct {
+	UserID      uuid.UUID          `json:"user_id"`
+	Username    string             `json:"username"`
+	DeliveredAt time.Time          `json:"delivered_at"`
+	ReadAt      pgtype.Timestamptz `json:"read_at"`
+}
+
+func (q *Queries) GetMessageReceipts(ctx context.Context, messageID uuid.UUID) ([]GetMessageReceiptsRow, error) {
+	rows, err := q.db.Query(ctx, getMessageReceipts, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetMessageReceiptsRow
+	for rows.Next() {
+		var i GetMessageReceiptsRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Username,
+			&i.DeliveredAt,
+			&i.ReadAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReceiptCounts = `-- name: GetReceiptCounts :many
+SELECT
+    message_id,
+    COUNT(*) AS delivered_count,
+    COUNT(read_at) AS read_count
+FROM message_receipts
+WHERE message_id = ANY($1::uuid[])
+GROUP BY message_id
+`
+
+type GetReceiptCountsRow struct {
+	MessageID      uuid.UUID `json:"message_id"`
+	DeliveredCount int64     `json:"delivered_count"`
+	ReadCount      int64     `json:"read_count"`
+}
+
+func (q *Queries) GetReceiptCounts(ctx context.Context, messageIds []uuid.UUID) ([]GetReceiptCountsRow, error) {
+	rows, err := q.db.Query(ctx, getReceiptCounts, messageIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetReceiptCountsRow
+	for rows.Next() {
+		var i GetReceiptCountsRow
+		if err := rows.Scan(&i.MessageID, &i.DeliveredCount, &i.ReadCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markConversationRead = `-- name: MarkConversationRead :many
+WITH read AS (
+    INSERT INTO message_receipts (message_id, user_id, read_at)
+    SELECT m.id, $1::uuid, CURRENT_TIMESTAMP
+    FROM messages m
+    WHERE m.conversation_id = $2::uuid
+      AND m.sender_id != $1::uuid
+      AND NOT EXISTS (
+          SELECT 1 FROM message_receipts r
+          WHERE r.message_id = m.id AND r.user_id = $1::uuid AND r.read_at IS NOT NULL
+      )
+    ON CONFLICT (message_id, user_id) DO UPDATE SET read_at = EXCLUDED.read_at
+    RETURNING message_id, read_at
+)
+SELECT r.message_id, r.read_at::timestamptz AS read_at, m.sender_id
+FROM read r
+JOIN messages m ON m.id = r.message_id
+`
+
+type MarkConversationReadParams struct {
+	ReaderID       uuid.UUID `json:"reader_id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+type MarkConversationReadRow struct {
+	MessageID uuid.UUID `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+	SenderID  uuid.UUID `json:"sender_id"`
+}
+
+// Marks every message the reader didn't send, reading implies delivery
+func (q *Queries) MarkConversationRead(ctx context.Context, arg MarkConversationReadParams) ([]MarkConversationReadRow, error) {
+	rows, err := q.db.Query(ctx, markConversationRead, arg.ReaderID, arg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MarkConversationReadRow
+	for rows.Next() {
+		var i MarkConversationReadRow
+		if err := rows.Scan(&i.MessageID, &i.ReadAt, &i.SenderID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markMessagesDelivered = `-- name: MarkMessagesDelivered :many
+WITH delivered AS (
+    INSERT INTO message_receipts (message_id, user_id)
+    SELECT m.id, $1::uuid
+    FROM messages m
+    JOIN conversation_members cm
+        ON cm.conversation_id = m.conversation_id AND cm.user_id = $1::uuid
+    WHERE m.id = ANY($2::uuid[])
+      AND m.sender_id != $1::uuid
+    ON CONFLICT DO NOTHING
+    RETURNING message_id, delivered_at
+)
+SELECT d.message_id, d.delivered_at::timestamptz AS delivered_at, m.sender_id, m.conversation_id
+FROM delivered d
+JOIN messages m ON m.id = d.message_id
+`
+
+type MarkMessagesDeliveredParams struct {
+	RecipientID uuid.UUID   `json:"recipient_id"`
+	MessageIds  []uuid.UUID `json:"message_ids"`
+}
+
+type MarkMessagesDeliveredRow struct {
+	MessageID      uuid.UUID `json:"message_id"`
+	DeliveredAt    time.Time `json:"delivered_at"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+// Only messages of the recipient's conversations that weren't delivered yet come back
+func (q *Queries) MarkMessagesDelivered(ctx context.Context, arg MarkMessagesDeliveredParams) ([]MarkMessagesDeliveredRow, error) {
+	rows, err := q.db.Query(ctx, markMessagesDelivered, arg.RecipientID, arg.MessageIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MarkMessagesDeliveredRow
+	for rows.Next() {
+		var i MarkMessagesDeliveredRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.DeliveredAt,
+			&i.SenderID,
+			&i.ConversationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,28 @@
+-- name: CreateRefreshToken :exec
+INSERT INTO refresh_tokens (
+    id,
+    user_id,
+    family_id,
+    expires_at
+) VALUES (
+    $1,
+    $2,
+    $3,
+    $4
+);
+
+-- name: GetRefreshTokenForUpdate :one
+SELECT * FROM refresh_tokens WHERE id = $1 FOR UPDATE;
+
+-- name: MarkRefreshTokenUsed :exec
+UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND revoked_at IS NULL;
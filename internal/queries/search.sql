@@ -0,0 +1,53 @@
+-- name: SearchMessages :many
+-- Best matches first, only messages of conversations the user is a member of.
+-- Snippets are HTML escaped with the matches wrapped in <mark>.
+WITH search AS (
+    SELECT websearch_to_tsquery('simple', @query::text) AS query
+),
+ranked AS (
+    SELECT
+        m.id,
+        m.conversation_id,
+        m.sender_id,
+        m.content,
+        m.created_at,
+        ts_rank(m.search_vector, search.query) AS rank
+    FROM messages m
+    CROSS JOIN search
+    JOIN conversation_members cm
+        ON cm.conversation_id = m.conversation_id AND cm.user_id = @user_id::uuid
+    WHERE m.search_vector @@ search.query
+      AND m.deleted_at IS NULL
+      AND NOT EXISTS (
+          SELECT 1 FROM hidden_messages h
+          WHERE h.message_id = m.id AND h.user_id = @user_id::uuid
+      )
+      AND NOT EXISTS (
+          SELECT 1 FROM conversation_user_settings s
+          WHERE s.conversation_id = m.conversation_id AND s.user_id = @user_id::uuid AND s.cleared_at >= m.created_at
+      )
+      AND (sqlc.narg('conversation_id')::uuid IS NULL OR m.conversation_id = sqlc.narg('conversation_id')::uuid)
+      AND (sqlc.narg('sender_id')::uuid IS NULL OR m.sender_id = sqlc.narg('sender_id')::uuid)
+      AND (sqlc.narg('sent_after')::timestamptz IS NULL OR m.created_at >= sqlc.narg('sent_after')::timestamptz)
+      AND (sqlc.narg('sent_before')::timestamptz IS NULL OR m.created_at < sqlc.narg('sent_before')::timestamptz)
+)
+SELECT
+    r.id,
+    r.conversation_id,
+    r.sender_id,
+    u.username AS sender_username,
+    r.created_at::timestamptz AS created_at,
+    r.rank::real AS rank,
+    ts_headline(
+        'simple',
+        replace(replace(replace(r.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+        search.query,
+        'StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=5, MaxFragments=2'
+    )::text AS snippet
+FROM ranked r
+CROSS JOIN search
+JOIN users u ON u.id = r.sender_id
+WHERE sqlc.narg('after_rank')::real IS NULL
+   OR (r.rank, r.created_at, r.id) < (sqlc.narg('after_rank')::real, @after_created_at::timestamptz, @after_id::uuid)
+ORDER BY r.rank DESC, r.created_at DESC, r.id DESC
+LIMIT @page_size;
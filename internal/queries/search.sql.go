@@ -0,0 +1,131 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: search.sql
+
+package queries
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const searchMessages = `-- name: SearchMessages :many
+WITH search AS (
+    SELECT websearch_to_tsquery('simple', $5::text) AS query
+),
+ranked AS (
+    SELECT
+        m.id,
+        m.conversation_id,
+        m.sender_id,
+        m.content,
+        m.created_at,
+        ts_rank(m.search_vector, search.query) AS rank
+    FROM messages m
+    CROSS JOIN search
+    JOIN conversation_members cm
+        ON cm.conversation_id = m.conversation_id AND cm.user_id = $6::uuid
+    WHERE m.search_vector @@ search.query
+      AND m.deleted_at IS NULL
+      AND NOT EXISTS (
+          SELECT 1 FROM hidden_messages h
+          WHERE h.message_id = m.id AND h.user_id = $6::uuid
+      )
+      AND NOT EXISTS (
+          SELECT 1 FROM conversation_user_settings s
+          WHERE s.conversation_id = m.conversation_id AND s.user_id = $6::uuid AND s.cleared_at >= m.created_at
+      )
+      AND ($7::uuid IS NULL OR m.conversation_id = $7::uuid)
+      AND ($8::uuid IS NULL OR m.sender_id = $8::uuid)
+      AND ($9::timestamptz IS NULL OR m.created_at >= $9::timestamptz)
+      AND ($10::timestamptz IS NULL OR m.created_at < $10::timestamptz)
+)
+SELECT
+    r.id,
+    r.conversation_id,
+    r.sender_id,
+    u.username AS sender_username,
+    r.created_at::timestamptz AS created_at,
+    r.rank::real AS rank,
+    ts_headline(
+        'simple',
+        replace(replace(replace(r.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+        search.query,
+        'StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=5, MaxFragments=2'
+    )::text AS snippet
+FROM ranked r
+CROSS JOIN search
+JOIN users u ON u.id = r.sender_id
+WHERE $1::real IS NULL
+   OR (r.rank, r.created_at, r.id) < ($1::real, $2::timestamptz, $3::uuid)
+ORDER BY r.rank DESC, r.created_at DESC, r.id DESC
+LIMIT $4
+`
+
+type SearchMessagesParams struct {
+	AfterRank      pgtype.Float4      `json:"after_rank"`
+	AfterCreatedAt time.Time          `json:"after_created_at"`
+	AfterID        uuid.UUID          `json:"after_id"`
+	PageSize       int32              `json:"page_size"`
+	Query          string             `json:"query"`
+	UserID         uuid.UUID          `json:"user_id"`
+	ConversationID pgtype.UUID        `json:"conversation_id"`
+	SenderID       pgtype.UUID        `json:"sender_id"`
+	SentAfter      pgtype.Timestamptz `json:"sent_after"`
+	SentBefore     pgtype.Timestamptz `json:"sent_before"`
+}
+
+type SearchMessagesRow struct {
+	ID             uuid.UUID `json:"id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	SenderUsername string    `json:"sender_username"`
+	CreatedAt      time.Time `json:"created_at"`
+	Rank           float32   `json:"rank"`
+	Snippet        string    `json:"snippet"`
+}
+
+// Best matches first, only messages of conversations the user is a member of.
+// Snippets are HTML escaped with the matches wrapped in <mark>.
+func (q *Queries) SearchMessages(ctx context.Context, arg SearchMessagesParams) ([]SearchMessagesRow, error) {
+	rows, err := q.db.Query(ctx, searchMessages,
+		arg.AfterRank,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.PageSize,
+		arg.Query,
+		arg.UserID,
+		arg.ConversationID,
+		arg.SenderID,
+		arg.SentAfter,
+		arg.SentBefore,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchMessagesRow
+	for rows.Next() {
+		var i SearchMessagesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.SenderUsername,
+			&i.CreatedAt,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
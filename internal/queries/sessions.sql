@@ -0,0 +1,46 @@
+-- name: CreateSession :exec
+INSERT INTO sessions (
+    id,
+    user_id,
+    device_name,
+    user_agent,
+    ip_address
+) VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+);
+
+-- name: GetActiveSessionsByUserID :many
+-- Sessions that weren't revoked and were refreshed recently enough to still be usable
+SELECT * FROM sessions
+WHERE user_id = @user_id
+  AND revoked_at IS NULL
+  AND last_used_at > @active_since::timestamptz
+ORDER BY last_used_at DESC;
+
+-- name: IsSessionActive :one
+SELECT EXISTS (
+    SELECT 1 FROM sessions
+    WHERE id = @id AND user_id = @user_id AND revoked_at IS NULL
+);
+
+-- name: TouchSession :exec
+UPDATE sessions
+SET last_used_at = CURRENT_TIMESTAMP,
+    ip_address = @ip_address
+WHERE id = @id;
+
+-- name: RevokeSession :one
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = @id AND user_id = @user_id AND revoked_at IS NULL
+RETURNING id;
+
+-- name: RevokeUserSessions :many
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = @user_id AND revoked_at IS NULL
+RETURNING id;
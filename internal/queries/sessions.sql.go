@@ -0,0 +1,174 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: sessions.sql
+
+package queries
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const createSession = `-- name: CreateSession :exec
+INSERT INTO sessions (
+    id,
+    user_id,
+    device_name,
+    user_agent,
+    ip_address
+) VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+`
+
+type CreateSessionParams struct {
+	ID         uuid.UUID   `json:"id"`
+	UserID     uuid.UUID   `json:"user_id"`
+	DeviceName pgtype.Text `json:"device_name"`
+	UserAgent  pgtype.Text `json:"user_agent"`
+	IpAddress  pgtype.Text `json:"ip_address"`
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
+	_, err := q.db.Exec(ctx, createSession,
+		arg.ID,
+		arg.UserID,
+		arg.DeviceName,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
+	return err
+}
+
+const getActiveSessionsByUserID = `-- name: GetActiveSessionsByUserID :many
+SELECT id, user_id, device_name, user_agent, ip_address, created_at, last_used_at, revoked_at FROM sessions
+WHERE user_id = $1
+  AND revoked_at IS NULL
+  AND last_used_at > $2::timestamptz
+ORDER BY last_used_at DESC
+`
+
+type GetActiveSessionsByUserIDParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	ActiveSince time.Time `json:"active_since"`
+}
+
+// Sessions that weren't revoked and were refreshed recently enough to still be usable
+func (q *Queries) GetActiveSessionsByUserID(ctx context.Context, arg GetActiveSessionsByUserIDParams) ([]Session, error) {
+	rows, err := q.db.Query(ctx, getActiveSessionsByUserID, arg.UserID, arg.ActiveSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.DeviceName,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isSessionActive = `-- name: IsSessionActive :one
+SELECT EXISTS (
+    SELECT 1 FROM sessions
+    WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+)
+`
+
+type IsSessionActiveParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) IsSessionActive(ctx context.Context, arg IsSessionActiveParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isSessionActive, arg.ID, arg.UserID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const revokeSession = `-- name: RevokeSession :one
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+RETURNING id
+`
+
+type RevokeSessionParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, revokeSession, arg.ID, arg.UserID)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const revokeUserSessions = `-- name: RevokeUserSessions :many
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND revoked_at IS NULL
+RETURNING id
+`
+
+func (q *Queries) RevokeUserSessions(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, revokeUserSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions
+SET last_used_at = CURRENT_TIMESTAMP,
+    ip_address = $1
+WHERE id = $2
+`
+
+type TouchSessionParams struct {
+	IpAddress pgtype.Text `json:"ip_address"`
+	ID        uuid.UUID   `json:"id"`
+}
+
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.Exec(ctx, touchSession, arg.IpAddress, arg.ID)
+	return err
+}
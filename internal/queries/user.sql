@@ -37,3 +37,9 @@ LIMIT 20;
 UPDATE users
     SET last_seen = CURRENT_TIMESTAMP
     WHERE id = $1;
+
+-- name: UpdateUserMessagePrivacy :one
+UPDATE users
+    SET message_privacy = @message_privacy
+    WHERE id = @id
+    RETURNING *;
@@ -22,7 +22,7 @@ VALUES (
     $1,
     $2,
     $3
-) RETURNING id, username, email, password_hash, created_at, last_seen
+) RETURNING id, username, email, password_hash, created_at, last_seen, message_privacy
 `
 
 type CreateUserParams struct {
@@ -41,12 +41,13 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.PasswordHash,
 		&i.CreatedAt,
 		&i.LastSeen,
+		&i.MessagePrivacy,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, username, email, password_hash, created_at, last_seen FROM users WHERE email = $1
+SELECT id, username, email, password_hash, created_at, last_seen, message_privacy FROM users WHERE email = $1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -59,12 +60,13 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.PasswordHash,
 		&i.CreatedAt,
 		&i.LastSeen,
+		&i.MessagePrivacy,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, username, email, password_hash, created_at, last_seen FROM users WHERE id = $1
+SELECT id, username, email, password_hash, created_at, last_seen, message_privacy FROM users WHERE id = $1
 `
 
 func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
@@ -77,12 +79,13 @@ func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.PasswordHash,
 		&i.CreatedAt,
 		&i.LastSeen,
+		&i.MessagePrivacy,
 	)
 	return i, err
 }
 
 const getUserByUsername = `-- name: GetUserByUsername :one
-SELECT id, username, email, password_hash, created_at, last_seen FROM users WHERE username = $1
+SELECT id, username, email, password_hash, created_at, last_seen, message_privacy FROM users WHERE username = $1
 `
 
 func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
@@ -95,6 +98,7 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User,
 		&i.PasswordHash,
 		&i.CreatedAt,
 		&i.LastSeen,
+		&i.MessagePrivacy,
 	)
 	return i, err
 }
@@ -103,7 +107,7 @@ const listUsers = `-- name: ListUsers :many
 		-- SearchUsers(ctx context.Context, username string) ([]queries.User, error)
 		-- UpdateUserLastSeen(ctx context.Context, id uuid.UUID) error
 
-SELECT id, username, email, password_hash, created_at, last_seen FROM users
+SELECT id, username, email, password_hash, created_at, last_seen, message_privacy FROM users
 `
 
 // ListUsers(ctx context.Context) ([]queries.User, error)
@@ -123,6 +127,7 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 			&i.PasswordHash,
 			&i.CreatedAt,
 			&i.LastSeen,
+			&i.MessagePrivacy,
 		); err != nil {
 			return nil, err
 		}
@@ -182,3 +187,30 @@ func (q *Queries) UpdateUserLastSeen(ctx context.Context, id uuid.UUID) error {
 	_, err := q.db.Exec(ctx, updateUserLastSeen, id)
 	return err
 }
+
+const updateUserMessagePrivacy = `-- name: UpdateUserMessagePrivacy :one
+UPDATE users
+    SET message_privacy = $1
+    WHERE id = $2
+    RETURNING id, username, email, password_hash, created_at, last_seen, message_privacy
+`
+
+type UpdateUserMessagePrivacyParams struct {
+	MessagePrivacy string    `json:"message_privacy"`
+	ID             uuid.UUID `json:"id"`
+}
+
+func (q *Queries) UpdateUserMessagePrivacy(ctx context.Context, arg UpdateUserMessagePrivacyParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateUserMessagePrivacy, arg.MessagePrivacy, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.PasswordHash,
+		&i.CreatedAt,
+		&i.LastSeen,
+		&i.MessagePrivacy,
+	)
+	return i, err
+}
@@ -0,0 +1,28 @@
+-- name: CreateUserEvents :many
+-- Bumps the counter of every user and stores the event under the new number,
+-- the counter row stays locked until commit so numbers are handed out in commit order
+WITH counters AS (
+    INSERT INTO user_event_counters (user_id, last_seq)
+    SELECT unnest(@user_ids::uuid[]), 1
+    ON CONFLICT (user_id) DO UPDATE SET last_seq = user_event_counters.last_seq + 1
+    RETURNING user_id, last_seq
+)
+INSERT INTO user_events (user_id, seq, event_type, payload)
+SELECT user_id, last_seq, @event_type::text, @payload::jsonb
+FROM counters
+RETURNING user_id, seq;
+
+-- name: GetUserEventsAfter :many
+SELECT * FROM user_events
+WHERE user_id = @user_id::uuid AND seq > @after_seq::bigint
+ORDER BY seq ASC
+LIMIT @page_size::int;
+
+-- name: GetUserEventBounds :one
+-- The newest sequence number handed out and the oldest one still stored
+SELECT
+    COALESCE((SELECT last_seq FROM user_event_counters c WHERE c.user_id = @user_id::uuid), 0)::bigint AS last_seq,
+    COALESCE((SELECT MIN(seq) FROM user_events e WHERE e.user_id = @user_id::uuid), 0)::bigint AS oldest_seq;
+
+-- name: DeleteUserEventsBefore :execrows
+DELETE FROM user_events WHERE created_at < @created_before::timestamptz;
@@ -0,0 +1,129 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: user_events.sql
+
+package queries
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const createUserEvents = `-- name: CreateUserEvents :many
+WITH counters AS (
+    INSERT INTO user_event_counters (user_id, last_seq)
+    SELECT unnest($3::uuid[]), 1
+    ON CONFLICT (user_id) DO UPDATE SET last_seq = user_event_counters.last_seq + 1
+    RETURNING user_id, last_seq
+)
+INSERT INTO user_events (user_id, seq, event_type, payload)
+SELECT user_id, last_seq, $1::text, $2::jsonb
+FROM counters
+RETURNING user_id, seq
+`
+
+type CreateUserEventsParams struct {
+	EventType string      `json:"event_type"`
+	Payload   []byte      `json:"payload"`
+	UserIds   []uuid.UUID `json:"user_ids"`
+}
+
+type CreateUserEventsRow struct {
+	UserID uuid.UUID `json:"user_id"`
+	Seq    int64     `json:"seq"`
+}
+
+// Bumps the counter of every user and stores the event under the new number,
+// the counter row stays locked until commit so numbers are handed out in commit order
+func (q *Queries) CreateUserEvents(ctx context.Context, arg CreateUserEventsParams) ([]CreateUserEventsRow, error) {
+	rows, err := q.db.Query(ctx, createUserEvents, arg.EventType, arg.Payload, arg.UserIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CreateUserEventsRow
+	for rows.Next() {
+		var i CreateUserEventsRow
+		if err := rows.Scan(&i.UserID, &i.Seq); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteUserEventsBefore = `-- name: DeleteUserEventsBefore :execrows
+DELETE FROM user_events WHERE created_at < $1::timestamptz
+`
+
+func (q *Queries) DeleteUserEventsBefore(ctx context.Context, createdBefore time.Time) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserEventsBefore, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getUserEventBounds = `-- name: GetUserEventBounds :one
+SELECT
+    COALESCE((SELECT last_seq FROM user_event_counters c WHERE c.user_id = $1::uuid), 0)::bigint AS last_seq,
+    COALESCE((SELECT MIN(seq) FROM user_events e WHERE e.user_id = $1::uuid), 0)::bigint AS oldest_seq
+`
+
+type GetUserEventBoundsRow struct {
+	LastSeq   int64 `json:"last_seq"`
+	OldestSeq int64 `json:"oldest_seq"`
+}
+
+// The newest sequence number handed out and the oldest one still stored
+func (q *Queries) GetUserEventBounds(ctx context.Context, userID uuid.UUID) (GetUserEventBoundsRow, error) {
+	row := q.db.QueryRow(ctx, getUserEventBounds, userID)
+	var i GetUserEventBoundsRow
+	err := row.Scan(&i.LastSeq, &i.OldestSeq)
+	return i, err
+}
+
+const getUserEventsAfter = `-- name: GetUserEventsAfter :many
+SELECT user_id, seq, event_type, payload, created_at FROM user_events
+WHERE user_id = $1::uuid AND seq > $2::bigint
+ORDER BY seq ASC
+LIMIT $3::int
+`
+
+type GetUserEventsAfterParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	AfterSeq int64     `json:"after_seq"`
+	PageSize int32     `json:"page_size"`
+}
+
+func (q *Queries) GetUserEventsAfter(ctx context.Context, arg GetUserEventsAfterParams) ([]UserEvent, error) {
+	rows, err := q.db.Query(ctx, getUserEventsAfter, arg.UserID, arg.AfterSeq, arg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UserEvent
+	for rows.Next() {
+		var i UserEvent
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Seq,
+			&i.EventType,
+			&i.Payload,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+type AttachmentStore struct {
+	q *queries.Queries
+}
+
+func NewAttachmentStore(q *queries.Queries) *AttachmentStore {
+	return &AttachmentStore{q: q}
+}
+
+func (s *AttachmentStore) Create(ctx context.Context, arg queries.CreateAttachmentParams) (queries.Attachment, error) {
+	attachment, err := s.q.CreateAttachment(ctx, arg)
+	if err != nil {
+		return queries.Attachment{}, mapError(err)
+	}
+	return attachment, nil
+}
+
+func (s *AttachmentStore) GetByID(ctx context.Context, id uuid.UUID) (queries.Attachment, error) {
+	attachment, err := s.q.GetAttachmentByID(ctx, id)
+	if err != nil {
+		return queries.Attachment{}, mapError(err)
+	}
+	return attachment, nil
+}
+
+func (s *AttachmentStore) GetByMessageIDs(ctx context.Context, messageIDs []uuid.UUID) ([]queries.Attachment, error) {
+	attachments, err := s.q.GetAttachmentsByMessageIDs(ctx, messageIDs)
+	return attachments, mapError(err)
+}
+
+// DeleteByMessageID drops the metadata and returns the storage keys of the blobs left behind
+func (s *AttachmentStore) DeleteByMessageID(ctx context.Context, messageID uuid.UUID) ([]string, error) {
+	keys, err := s.q.DeleteAttachmentsByMessageID(ctx, messageID)
+	return keys, mapError(err)
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+type BlockStore struct {
+	q *queries.Queries
+}
+
+func NewBlockStore(q *queries.Queries) *BlockStore {
+	return &BlockStore{q: q}
+}
+
+// Block is a no-op when the user is blocked already
+func (s *BlockStore) Block(ctx context.Context, arg queries.BlockUserParams) error {
+	return mapError(s.q.BlockUser(ctx, arg))
+}
+
+func (s *BlockStore) Unblock(ctx context.Context, arg queries.UnblockUserParams) error {
+	_, err := s.q.UnblockUser(ctx, arg)
+	return mapError(err)
+}
+
+func (s *BlockStore) GetByUserID(ctx context.Context, userID uuid.UUID) ([]queries.GetBlockedUsersRow, error) {
+	blocked, err := s.q.GetBlockedUsers(ctx, userID)
+	return blocked, mapError(err)
+}
+
+// IsBlockedBetween tells whether either of the users blocked the other
+func (s *BlockStore) IsBlockedBetween(ctx context.Context, user1, user2 uuid.UUID) (bool, error) {
+	blocked, err := s.q.IsBlockedBetween(ctx, queries.IsBlockedBetweenParams{
+		User1: user1,
+		User2: user2,
+	})
+	return blocked, mapError(err)
+}
+
+// IsBlockedInConversation tells whether the members of a direct conversation blocked one another
+func (s *BlockStore) IsBlockedInConversation(ctx context.Context, conversationID uuid.UUID) (bool, error) {
+	blocked, err := s.q.IsBlockedInConversation(ctx, conversationID)
+	return blocked, mapError(err)
+}
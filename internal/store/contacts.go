@@ -30,7 +30,32 @@ func (s *ContactStore) GetByUserID(ctx context.Context, userID uuid.UUID) ([]que
 	return users, nil
 }
 
+func (s *ContactStore) Get(ctx context.Context, arg queries.GetContactParams) (queries.GetContactRow, error) {
+	contact, err := s.q.GetContact(ctx, arg)
+	if err != nil {
+		return queries.GetContactRow{}, mapError(err)
+	}
+	return contact, nil
+}
+
+func (s *ContactStore) Search(ctx context.Context, arg queries.SearchContactsParams) ([]queries.SearchContactsRow, error) {
+	contacts, err := s.q.SearchContacts(ctx, arg)
+	return contacts, mapError(err)
+}
+
+// Rename sets the nickname, a null one goes back to the username
+func (s *ContactStore) Rename(ctx context.Context, arg queries.UpdateContactNicknameParams) error {
+	_, err := s.q.UpdateContactNickname(ctx, arg)
+	return mapError(err)
+}
+
 func (s *ContactStore) Delete(ctx context.Context, arg queries.DeleteContactParams) error {
 	_, err := s.q.DeleteContact(ctx, arg)
 	return mapError(err)
 }
+
+// Exists tells whether the user has the other one in their contacts
+func (s *ContactStore) Exists(ctx context.Context, arg queries.IsContactParams) (bool, error) {
+	exists, err := s.q.IsContact(ctx, arg)
+	return exists, mapError(err)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+type ConversationSettingsStore struct {
+	db *pgxpool.Pool
+	q  *queries.Queries
+}
+
+func NewConversationSettingsStore(db *pgxpool.Pool, q *queries.Queries) *ConversationSettingsStore {
+	return &ConversationSettingsStore{db: db, q: q}
+}
+
+// Get returns the user's settings of the conversations, ones they never changed are left out
+func (s *ConversationSettingsStore) Get(ctx context.Context, arg queries.GetConversationSettingsParams) ([]queries.GetConversationSettingsRow, error) {
+	settings, err := s.q.GetConversationSettings(ctx, arg)
+	return settings, mapError(err)
+}
+
+func (s *ConversationSettingsStore) Archive(ctx context.Context, arg queries.ArchiveConversationParams) error {
+	return mapError(s.q.ArchiveConversation(ctx, arg))
+}
+
+func (s *ConversationSettingsStore) Unarchive(ctx context.Context, arg queries.UnarchiveConversationParams) error {
+	return mapError(s.q.UnarchiveConversation(ctx, arg))
+}
+
+// Clear hides the conversation's messages so far from the user, the other members keep them
+func (s *ConversationSettingsStore) Clear(ctx context.Context, arg queries.ClearConversationParams) error {
+	return mapError(s.q.ClearConversation(ctx, arg))
+}
+
+// DeleteForUser clears the conversation for the user and takes it off their list,
+// until it has a new message
+func (s *ConversationSettingsStore) DeleteForUser(ctx context.Context, arg queries.DeleteConversationForUserParams) error {
+	return mapError(s.q.DeleteConversationForUser(ctx, arg))
+}
+
+func (s *ConversationSettingsStore) GetPinnedIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	ids, err := s.q.GetPinnedConversationIDs(ctx, userID)
+	return ids, mapError(err)
+}
+
+// Pin pins the conversation below the others, it gives ErrLimitReached
+// when the user has limit conversations pinned already
+func (s *ConversationSettingsStore) Pin(ctx context.Context, arg queries.PinConversationParams, limit int) error {
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		// concurrent pins would both count the same pins and take the same position otherwise
+		if err := q.LockUserPins(ctx, arg.UserID); err != nil {
+			return err
+		}
+
+		pinned, err := q.GetPinnedConversationIDs(ctx, arg.UserID)
+		if err != nil {
+			return err
+		}
+
+		if slices.Contains(pinned, arg.ConversationID) {
+			return nil
+		}
+
+		if len(pinned) >= limit {
+			return ErrLimitReached
+		}
+
+		return q.PinConversation(ctx, arg)
+	})
+
+	if err == ErrLimitReached {
+		return err
+	}
+	return mapError(err)
+}
+
+func (s *ConversationSettingsStore) Unpin(ctx context.Context, arg queries.UnpinConversationParams) error {
+	return mapError(s.q.UnpinConversation(ctx, arg))
+}
+
+// Reorder moves the pinned conversations into the order of the ids
+func (s *ConversationSettingsStore) Reorder(ctx context.Context, arg queries.ReorderPinnedConversationsParams) error {
+	return mapError(s.q.ReorderPinnedConversations(ctx, arg))
+}
+
+func (s *ConversationSettingsStore) Mute(ctx context.Context, arg queries.MuteConversationParams) error {
+	return mapError(s.q.MuteConversation(ctx, arg))
+}
+
+func (s *ConversationSettingsStore) Unmute(ctx context.Context, arg queries.UnmuteConversationParams) error {
+	return mapError(s.q.UnmuteConversation(ctx, arg))
+}
+
+// GetMutedMemberIDs returns the members who muted the conversation, and the mute hasn't expired
+func (s *ConversationSettingsStore) GetMutedMemberIDs(ctx context.Context, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	ids, err := s.q.GetMutedMemberIDs(ctx, conversationID)
+	return ids, mapError(err)
+}
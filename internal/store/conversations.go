@@ -4,32 +4,99 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/myselfBZ/chatrix-v2/internal/queries"
 )
 
 
-func NewConversationStore(queries *queries.Queries) *ConversationStore {
+func NewConversationStore(db *pgxpool.Pool, queries *queries.Queries) *ConversationStore {
 	return &ConversationStore{
+		db:      db,
 		queries: queries,
 	}
 }
 
 type ConversationStore struct {
+	db      *pgxpool.Pool
 	queries *queries.Queries
 }
 
-func (s *ConversationStore) GetByUserID(ctx context.Context, id uuid.UUID) ([]queries.GetConversationsByUserIDRow, error) {
-	conversations, err := s.queries.GetConversationsByUserID(ctx, id)
+// GetByUserID returns a page of the user's direct conversations, most recently active first
+func (s *ConversationStore) GetByUserID(ctx context.Context, arg queries.GetConversationsByUserIDParams) ([]queries.GetConversationsByUserIDRow, error) {
+	conversations, err := s.queries.GetConversationsByUserID(ctx, arg)
 	return conversations, mapError(err)
 }
 
-func (s *ConversationStore) Delete(ctx context.Context, id uuid.UUID) error {
-	_, err := s.queries.DeleteConversation(ctx, id)
-	return mapError(err)
+// GetGroupsByUserID is GetByUserID for groups
+func (s *ConversationStore) GetGroupsByUserID(ctx context.Context, arg queries.GetGroupConversationsByUserIDParams) ([]queries.GetGroupConversationsByUserIDRow, error) {
+	groups, err := s.queries.GetGroupConversationsByUserID(ctx, arg)
+	return groups, mapError(err)
 }
 
+func (s *ConversationStore) GetByID(ctx context.Context, id uuid.UUID) (queries.Conversation, error) {
+	c, err := s.queries.GetConversationByID(ctx, id)
+	return c, mapError(err)
+}
+
+// Delete deletes the conversation for every member along with its messages,
+// it returns the storage keys of the attachments whose files have to be deleted
+func (s *ConversationStore) Delete(ctx context.Context, id uuid.UUID) ([]string, error) {
+	var keys []string
+	err := withTx(ctx, s.db, s.queries, func(q *queries.Queries) error {
+		var err error
+		keys, err = deleteConversation(ctx, q, id)
+		return err
+	})
+	return keys, mapError(err)
+}
+
+// deleteConversation deletes the attachments of the conversation before the cascade
+// gets to them, so their storage keys are known
+func deleteConversation(ctx context.Context, q *queries.Queries, id uuid.UUID) ([]string, error) {
+	keys, err := q.DeleteAttachmentsByConversationID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := q.DeleteConversation(ctx, id); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Create creates a direct conversation, both users become its members
 func (s *ConversationStore) Create(ctx context.Context, params queries.CreateConversationParams) (queries.Conversation,error) {
-	conversation, err := s.queries.CreateConversation(ctx, params)
+	var conversation queries.Conversation
+	err := withTx(ctx, s.db, s.queries, func(q *queries.Queries) error {
+		var err error
+		conversation, err = q.CreateConversation(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		return q.AddConversationMembers(ctx, queries.AddConversationMembersParams{
+			ConversationID: conversation.ID,
+			UserIds:        []uuid.UUID{params.User1, params.User2},
+		})
+	})
+	return conversation, mapError(err)
+}
+
+// CreateGroup creates a group conversation, memberIDs should already contain the creator
+func (s *ConversationStore) CreateGroup(ctx context.Context, params queries.CreateGroupConversationParams, memberIDs []uuid.UUID) (queries.Conversation, error) {
+	var conversation queries.Conversation
+	err := withTx(ctx, s.db, s.queries, func(q *queries.Queries) error {
+		var err error
+		conversation, err = q.CreateGroupConversation(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		return q.AddConversationMembers(ctx, queries.AddConversationMembersParams{
+			ConversationID: conversation.ID,
+			UserIds:        memberIDs,
+		})
+	})
 	return conversation, mapError(err)
 }
 
@@ -37,3 +104,159 @@ func (s *ConversationStore) GetByMembers(ctx context.Context, params queries.Get
 	c, err := s.queries.GetConversationByMembers(ctx, params)
 	return c, mapError(err)
 }
+
+func (s *ConversationStore) GetMemberIDs(ctx context.Context, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	ids, err := s.queries.GetConversationMemberIDs(ctx, conversationID)
+	return ids, mapError(err)
+}
+
+// GetMembersOfMany returns the members of every conversation in one go
+func (s *ConversationStore) GetMembersOfMany(ctx context.Context, conversationIDs []uuid.UUID) ([]queries.GetMembersOfConversationsRow, error) {
+	members, err := s.queries.GetMembersOfConversations(ctx, conversationIDs)
+	return members, mapError(err)
+}
+
+func (s *ConversationStore) GetMembers(ctx context.Context, conversationID uuid.UUID) ([]queries.GetConversationMembersRow, error) {
+	members, err := s.queries.GetConversationMembers(ctx, conversationID)
+	return members, mapError(err)
+}
+
+func (s *ConversationStore) IsMember(ctx context.Context, conversationID, userID uuid.UUID) (bool, error) {
+	isMember, err := s.queries.IsConversationMember(ctx, queries.IsConversationMemberParams{
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	return isMember, mapError(err)
+}
+
+// GetPeerIDs returns everyone the user shares a conversation with
+func (s *ConversationStore) GetPeerIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	ids, err := s.queries.GetConversationPeerIDs(ctx, userID)
+	return ids, mapError(err)
+}
+
+// GetRequests returns the message requests waiting for the user to accept them
+func (s *ConversationStore) GetRequests(ctx context.Context, userID uuid.UUID) ([]queries.GetMessageRequestsRow, error) {
+	requests, err := s.queries.GetMessageRequests(ctx, userID)
+	return requests, mapError(err)
+}
+
+// AcceptRequest turns a message request into a normal conversation,
+// ErrNotFound unless the user is the one it was sent to
+func (s *ConversationStore) AcceptRequest(ctx context.Context, arg queries.AcceptMessageRequestParams) (queries.Conversation, error) {
+	c, err := s.queries.AcceptMessageRequest(ctx, arg)
+	return c, mapError(err)
+}
+
+// DeclineRequest deletes a message request along with its messages and records that it was declined,
+// ErrNotFound unless the user is the one it was sent to. It returns the storage keys of the
+// attachments whose files have to be deleted
+func (s *ConversationStore) DeclineRequest(ctx context.Context, arg queries.DeclineMessageRequestParams) (queries.Conversation, []string, error) {
+	var (
+		conversation queries.Conversation
+		keys         []string
+	)
+	err := withTx(ctx, s.db, s.queries, func(q *queries.Queries) error {
+		var err error
+		// rolled back along with everything else when it isn't the user's request
+		keys, err = q.DeleteAttachmentsByConversationID(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+
+		conversation, err = q.DeclineMessageRequest(ctx, arg)
+		if err != nil {
+			return err
+		}
+
+		return q.RecordMessageRequestDecline(ctx, queries.RecordMessageRequestDeclineParams{
+			RequesterID: conversation.User1.Bytes,
+			RecipientID: conversation.User2.Bytes,
+		})
+	})
+	if err != nil {
+		return queries.Conversation{}, nil, mapError(err)
+	}
+	return conversation, keys, nil
+}
+
+// IsRequestDeclined tells whether the recipient declined a message request of the requester lately
+func (s *ConversationStore) IsRequestDeclined(ctx context.Context, arg queries.IsMessageRequestDeclinedParams) (bool, error) {
+	declined, err := s.queries.IsMessageRequestDeclined(ctx, arg)
+	return declined, mapError(err)
+}
+
+// RequestDeletion records that the user wants the direct conversation deleted for both members.
+// When the other member asked first it's deleted right away instead, deleted tells which one happened
+// and keys are the storage keys of the attachments whose files have to be deleted then
+func (s *ConversationStore) RequestDeletion(ctx context.Context, arg queries.RequestConversationDeletionParams) (conversation queries.Conversation, deleted bool, keys []string, err error) {
+	err = withTx(ctx, s.db, s.queries, func(q *queries.Queries) error {
+		var err error
+		conversation, err = q.GetConversationByIDForUpdate(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+
+		if conversation.DeleteRequestedBy.Valid && conversation.DeleteRequestedBy.Bytes != arg.UserID {
+			deleted = true
+			keys, err = deleteConversation(ctx, q, arg.ID)
+			return err
+		}
+
+		conversation, err = q.RequestConversationDeletion(ctx, arg)
+		return err
+	})
+	return conversation, deleted, keys, mapError(err)
+}
+
+// CancelDeletion drops the request to delete the conversation for both members,
+// ErrNotFound when there isn't one
+func (s *ConversationStore) CancelDeletion(ctx context.Context, id uuid.UUID) (queries.Conversation, error) {
+	c, err := s.queries.CancelConversationDeletion(ctx, id)
+	return c, mapError(err)
+}
+
+// Leave takes the user out of a group along with their settings of it, the group is deleted
+// when they were the last member. It returns the storage keys of the attachments whose files
+// have to be deleted then
+func (s *ConversationStore) Leave(ctx context.Context, conversationID, userID uuid.UUID) ([]string, error) {
+	var keys []string
+	err := withTx(ctx, s.db, s.queries, func(q *queries.Queries) error {
+		removed, err := q.RemoveConversationMember(ctx, queries.RemoveConversationMemberParams{
+			ConversationID: conversationID,
+			UserID:         userID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if removed == 0 {
+			return ErrNotFound
+		}
+
+		err = q.DeleteConversationSettings(ctx, queries.DeleteConversationSettingsParams{
+			ConversationID: conversationID,
+			UserID:         userID,
+		})
+		if err != nil {
+			return err
+		}
+
+		memberIDs, err := q.GetConversationMemberIDs(ctx, conversationID)
+		if err != nil {
+			return err
+		}
+
+		if len(memberIDs) > 0 {
+			return nil
+		}
+
+		keys, err = deleteConversation(ctx, q, conversationID)
+		return err
+	})
+
+	if err == ErrNotFound {
+		return nil, err
+	}
+	return keys, mapError(err)
+}
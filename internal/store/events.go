@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+// EventStore is the per-user log of events devices replay after being offline
+type EventStore struct {
+	q *queries.Queries
+}
+
+func NewEventStore(q *queries.Queries) *EventStore {
+	return &EventStore{q: q}
+}
+
+// Record appends the event to the log of every user and returns the sequence number each of them got.
+// The user ids have to be unique.
+func (s *EventStore) Record(ctx context.Context, arg queries.CreateUserEventsParams) (map[uuid.UUID]int64, error) {
+	rows, err := s.q.CreateUserEvents(ctx, arg)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	seqs := make(map[uuid.UUID]int64, len(rows))
+	for _, r := range rows {
+		seqs[r.UserID] = r.Seq
+	}
+	return seqs, nil
+}
+
+// GetAfter returns the events following afterSeq, oldest first
+func (s *EventStore) GetAfter(ctx context.Context, arg queries.GetUserEventsAfterParams) ([]queries.UserEvent, error) {
+	events, err := s.q.GetUserEventsAfter(ctx, arg)
+	return events, mapError(err)
+}
+
+func (s *EventStore) GetBounds(ctx context.Context, userID uuid.UUID) (queries.GetUserEventBoundsRow, error) {
+	bounds, err := s.q.GetUserEventBounds(ctx, userID)
+	if err != nil {
+		return queries.GetUserEventBoundsRow{}, mapError(err)
+	}
+	return bounds, nil
+}
+
+// Prune drops the events older than the given time, devices that were offline
+// for longer have to refetch everything instead
+func (s *EventStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	deleted, err := s.q.DeleteUserEventsBefore(ctx, before)
+	return deleted, mapError(err)
+}
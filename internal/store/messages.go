@@ -2,41 +2,233 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/myselfBZ/chatrix-v2/internal/metrics"
 	"github.com/myselfBZ/chatrix-v2/internal/queries"
 )
 
 type MessageStore struct {
-	q *queries.Queries
+	db *pgxpool.Pool
+	q  *queries.Queries
 }
 
-func NewMessageStore(q *queries.Queries) *MessageStore {
-	return &MessageStore{q: q}
+func NewMessageStore(db *pgxpool.Pool, q *queries.Queries) *MessageStore {
+	return &MessageStore{db: db, q: q}
 }
 
-func (s *MessageStore) Create(ctx context.Context, arg queries.CreateMessageParams) (queries.Message, error) {
-	msg, err := s.q.CreateMessage(ctx, arg)
+// Create stores a message and links the given, already uploaded attachments to it.
+// ErrConstraintMessage is returned if any of the attachments can't be linked.
+func (s *MessageStore) Create(ctx context.Context, arg queries.CreateMessageParams, attachmentIDs []uuid.UUID) (queries.Message, []queries.Attachment, error) {
+	defer metrics.ObserveSince(metrics.MessageWriteDuration, time.Now())
+
+	if len(attachmentIDs) == 0 {
+		msg, err := s.q.CreateMessage(ctx, arg)
+		if err != nil {
+			return queries.Message{}, nil, mapError(err)
+		}
+		return msg, nil, nil
+	}
+
+	var (
+		msg         queries.Message
+		attachments []queries.Attachment
+	)
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		var err error
+		msg, attachments, err = createMessage(ctx, q, arg, attachmentIDs)
+		return err
+	})
+
+	if err == ErrConstraintMessage {
+		return queries.Message{}, nil, err
+	}
+	if err != nil {
+		return queries.Message{}, nil, mapError(err)
+	}
+	return msg, attachments, nil
+}
+
+// CreateInRequest is Create for a conversation that may be a message request, the requester
+// gets a single message in until it's accepted and ErrLimitReached after that
+func (s *MessageStore) CreateInRequest(ctx context.Context, arg queries.CreateMessageParams, attachmentIDs []uuid.UUID) (queries.Message, []queries.Attachment, error) {
+	defer metrics.ObserveSince(metrics.MessageWriteDuration, time.Now())
+
+	var (
+		msg         queries.Message
+		attachments []queries.Attachment
+	)
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		// concurrent messages of the requester wait for each other here
+		conversation, err := q.GetConversationByIDForUpdate(ctx, arg.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		if conversation.RequestPending {
+			sent, err := q.CountSenderMessages(ctx, queries.CountSenderMessagesParams{
+				ConversationID: arg.ConversationID,
+				SenderID:       arg.SenderID,
+			})
+			if err != nil {
+				return err
+			}
+			if sent > 0 {
+				return ErrLimitReached
+			}
+		}
+
+		msg, attachments, err = createMessage(ctx, q, arg, attachmentIDs)
+		return err
+	})
+
+	if err == ErrConstraintMessage || err == ErrLimitReached {
+		return queries.Message{}, nil, err
+	}
+	if err != nil {
+		return queries.Message{}, nil, mapError(err)
+	}
+	return msg, attachments, nil
+}
+
+// createMessage creates the message and links the attachments to it, within a transaction
+func createMessage(ctx context.Context, q *queries.Queries, arg queries.CreateMessageParams, attachmentIDs []uuid.UUID) (queries.Message, []queries.Attachment, error) {
+	msg, err := q.CreateMessage(ctx, arg)
+	if err != nil {
+		return queries.Message{}, nil, err
+	}
+
+	if len(attachmentIDs) == 0 {
+		return msg, nil, nil
+	}
+
+	attachments, err := q.LinkAttachmentsToMessage(ctx, queries.LinkAttachmentsToMessageParams{
+		MessageID:      msg.ID,
+		Ids:            attachmentIDs,
+		UploaderID:     arg.SenderID,
+		ConversationID: arg.ConversationID,
+	})
+	if err != nil {
+		return queries.Message{}, nil, err
+	}
+
+	if len(attachments) != len(attachmentIDs) {
+		return queries.Message{}, nil, ErrConstraintMessage
+	}
+	return msg, attachments, nil
+}
+
+func (s *MessageStore) GetByID(ctx context.Context, id uuid.UUID) (queries.Message, error) {
+	msg, err := s.q.GetMessageByID(ctx, id)
+	if err != nil {
+		return queries.Message{}, mapError(err)
+	}
+	return msg, nil
+}
+
+// GetVisibleByID returns the message unless the viewer has deleted it for themselves
+func (s *MessageStore) GetVisibleByID(ctx context.Context, arg queries.GetVisibleMessageByIDParams) (queries.Message, error) {
+	msg, err := s.q.GetVisibleMessageByID(ctx, arg)
 	if err != nil {
 		return queries.Message{}, mapError(err)
 	}
 	return msg, nil
 }
 
-func (s *MessageStore) GetByConversationID(ctx context.Context, arg queries.GetMessagesByConversationIDParams) ([]queries.Message, error) {
-	msgs, err := s.q.GetMessagesByConversationID(ctx, arg)
+// GetQuoted returns the snapshots of the messages replies point to
+func (s *MessageStore) GetQuoted(ctx context.Context, ids []uuid.UUID) ([]queries.GetQuotedMessagesRow, error) {
+	quoted, err := s.q.GetQuotedMessages(ctx, ids)
+	return quoted, mapError(err)
+}
+
+// GetLast returns the newest message of each conversation that the viewer didn't hide,
+// conversations without one are left out
+func (s *MessageStore) GetLast(ctx context.Context, arg queries.GetLastMessagesParams) ([]queries.GetLastMessagesRow, error) {
+	msgs, err := s.q.GetLastMessages(ctx, arg)
+	return msgs, mapError(err)
+}
+
+// GetLatest returns the newest messages of a conversation, newest first
+func (s *MessageStore) GetLatest(ctx context.Context, arg queries.GetLatestMessagesParams) ([]queries.Message, error) {
+	msgs, err := s.q.GetLatestMessages(ctx, arg)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return msgs, nil
+}
+
+// GetBefore returns the messages older than the cursor, newest first
+func (s *MessageStore) GetBefore(ctx context.Context, arg queries.GetMessagesBeforeParams) ([]queries.Message, error) {
+	msgs, err := s.q.GetMessagesBefore(ctx, arg)
 	if err != nil {
 		return nil, mapError(err)
 	}
 	return msgs, nil
 }
 
-func (s *MessageStore) MarkAsRead(ctx context.Context, arg queries.MarkMessagesAsReadParams) ([]uuid.UUID, error) {
-	msgIds, err := s.q.MarkMessagesAsRead(ctx, arg)
-	return msgIds, mapError(err)
+// GetAfter returns the messages newer than the cursor, oldest first
+func (s *MessageStore) GetAfter(ctx context.Context, arg queries.GetMessagesAfterParams) ([]queries.Message, error) {
+	msgs, err := s.q.GetMessagesAfter(ctx, arg)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return msgs, nil
+}
+
+// Edit replaces the content of a message, keeping the previous one in message_edits.
+// ErrNotFound is returned if the message doesn't exist, isn't the sender's or is outside the edit window.
+func (s *MessageStore) Edit(ctx context.Context, arg queries.EditMessageParams) (queries.Message, error) {
+	var msg queries.Message
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		prev, err := q.GetMessageByIDForUpdate(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+
+		msg, err = q.EditMessage(ctx, arg)
+		if err != nil {
+			return err
+		}
+
+		return q.CreateMessageEdit(ctx, queries.CreateMessageEditParams{
+			MessageID: prev.ID,
+			Content:   prev.Content,
+		})
+	})
+	return msg, mapError(err)
 }
 
-func (s *MessageStore) Delete(ctx context.Context, id uuid.UUID) error {
-	err := s.q.DeleteMessage(ctx, id)
+func (s *MessageStore) GetEdits(ctx context.Context, messageID uuid.UUID) ([]queries.MessageEdit, error) {
+	edits, err := s.q.GetMessageEdits(ctx, messageID)
+	return edits, mapError(err)
+}
+
+// DeleteForEveryone blanks the message and drops its previous revisions,
+// the row itself stays as a tombstone
+func (s *MessageStore) DeleteForEveryone(ctx context.Context, arg queries.DeleteMessageForEveryoneParams) (queries.Message, error) {
+	var msg queries.Message
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		var err error
+		msg, err = q.DeleteMessageForEveryone(ctx, arg)
+		if err != nil {
+			return err
+		}
+
+		return q.DeleteMessageEdits(ctx, msg.ID)
+	})
+	return msg, mapError(err)
+}
+
+// DeleteForUser hides the message from a single user's history
+func (s *MessageStore) DeleteForUser(ctx context.Context, arg queries.HideMessageParams) error {
+	err := s.q.HideMessage(ctx, arg)
 	return mapError(err)
 }
+
+// Search runs a full-text search over the messages the user can see, best matches first
+func (s *MessageStore) Search(ctx context.Context, arg queries.SearchMessagesParams) ([]queries.SearchMessagesRow, error) {
+	results, err := s.q.SearchMessages(ctx, arg)
+	return results, mapError(err)
+}
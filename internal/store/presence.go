@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+// PresenceStore keeps track of the running API nodes and which of them every user is connected to
+type PresenceStore struct {
+	q *queries.Queries
+}
+
+func NewPresenceStore(q *queries.Queries) *PresenceStore {
+	return &PresenceStore{q: q}
+}
+
+// Heartbeat registers the node or tells it's still alive
+func (s *PresenceStore) Heartbeat(ctx context.Context, nodeID uuid.UUID) error {
+	return mapError(s.q.RegisterNode(ctx, nodeID))
+}
+
+// RemoveNode takes the node and the presence of everyone connected to it away
+func (s *PresenceStore) RemoveNode(ctx context.Context, nodeID uuid.UUID) error {
+	return mapError(s.q.DeleteNode(ctx, nodeID))
+}
+
+// PruneNodes removes the nodes that stopped sending heartbeats, crashed ones most likely.
+// It returns the users who aren't connected to any node anymore because of it
+func (s *PresenceStore) PruneNodes(ctx context.Context, heartbeatBefore time.Time) ([]uuid.UUID, error) {
+	userIDs, err := s.q.DeleteStaleNodes(ctx, heartbeatBefore)
+	return userIDs, mapError(err)
+}
+
+// Connect returns on how many live nodes the user is connected now
+func (s *PresenceStore) Connect(ctx context.Context, arg queries.AddUserPresenceParams) (int32, error) {
+	nodes, err := s.q.AddUserPresence(ctx, arg)
+	return nodes, mapError(err)
+}
+
+// Disconnect returns on how many live nodes the user is still connected
+func (s *PresenceStore) Disconnect(ctx context.Context, arg queries.RemoveUserPresenceParams) (int32, error) {
+	nodes, err := s.q.RemoveUserPresence(ctx, arg)
+	return nodes, mapError(err)
+}
+
+func (s *PresenceStore) GetOnline(ctx context.Context, arg queries.GetOnlineUserIDsParams) ([]uuid.UUID, error) {
+	ids, err := s.q.GetOnlineUserIDs(ctx, arg)
+	return ids, mapError(err)
+}
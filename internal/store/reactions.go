@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+type ReactionStore struct {
+	q *queries.Queries
+}
+
+func NewReactionStore(q *queries.Queries) *ReactionStore {
+	return &ReactionStore{q: q}
+}
+
+func (s *ReactionStore) Add(ctx context.Context, arg queries.AddReactionParams) error {
+	err := s.q.AddReaction(ctx, arg)
+	return mapError(err)
+}
+
+func (s *ReactionStore) Remove(ctx context.Context, arg queries.RemoveReactionParams) error {
+	err := s.q.RemoveReaction(ctx, arg)
+	return mapError(err)
+}
+
+func (s *ReactionStore) Count(ctx context.Context, arg queries.CountReactionsParams) (int64, error) {
+	count, err := s.q.CountReactions(ctx, arg)
+	return count, mapError(err)
+}
+
+// GetSummaries aggregates the reactions of several messages from the point of view of a viewer
+func (s *ReactionStore) GetSummaries(ctx context.Context, arg queries.GetReactionSummariesParams) ([]queries.GetReactionSummariesRow, error) {
+	summaries, err := s.q.GetReactionSummaries(ctx, arg)
+	return summaries, mapError(err)
+}
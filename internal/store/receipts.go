@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+// ReceiptStore keeps track of which recipient got and read which message
+type ReceiptStore struct {
+	q *queries.Queries
+}
+
+func NewReceiptStore(q *queries.Queries) *ReceiptStore {
+	return &ReceiptStore{q: q}
+}
+
+// MarkDelivered returns the messages that were delivered to the recipient for the first time
+func (s *ReceiptStore) MarkDelivered(ctx context.Context, arg queries.MarkMessagesDeliveredParams) ([]queries.MarkMessagesDeliveredRow, error) {
+	delivered, err := s.q.MarkMessagesDelivered(ctx, arg)
+	return delivered, mapError(err)
+}
+
+// MarkRead returns the messages of the conversation the reader hadn't read yet
+func (s *ReceiptStore) MarkRead(ctx context.Context, arg queries.MarkConversationReadParams) ([]queries.MarkConversationReadRow, error) {
+	read, err := s.q.MarkConversationRead(ctx, arg)
+	return read, mapError(err)
+}
+
+func (s *ReceiptStore) GetByMessageID(ctx context.Context, messageID uuid.UUID) ([]queries.GetMessageReceiptsRow, error) {
+	receipts, err := s.q.GetMessageReceipts(ctx, messageID)
+	return receipts, mapError(err)
+}
+
+func (s *ReceiptStore) GetCounts(ctx context.Context, messageIDs []uuid.UUID) ([]queries.GetReceiptCountsRow, error) {
+	counts, err := s.q.GetReceiptCounts(ctx, messageIDs)
+	return counts, mapError(err)
+}
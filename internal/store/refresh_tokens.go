@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+type RefreshTokenStore struct {
+	db *pgxpool.Pool
+	q  *queries.Queries
+}
+
+func NewRefreshTokenStore(db *pgxpool.Pool, q *queries.Queries) *RefreshTokenStore {
+	return &RefreshTokenStore{db: db, q: q}
+}
+
+// Rotate swaps the token for the next one of its family.
+// A token that was already swapped is being reused, it most likely leaked,
+// so the whole family is revoked and ErrTokenReused is returned.
+// Revoked, expired or unknown tokens give ErrNotFound.
+func (s *RefreshTokenStore) Rotate(ctx context.Context, tokenID uuid.UUID, next queries.CreateRefreshTokenParams) error {
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		token, err := q.GetRefreshTokenForUpdate(ctx, tokenID)
+		if err != nil {
+			return err
+		}
+
+		if token.RevokedAt.Valid || !token.ExpiresAt.Time.After(time.Now()) || token.FamilyID != next.FamilyID || token.UserID != next.UserID {
+			return ErrNotFound
+		}
+
+		if token.UsedAt.Valid {
+			return ErrTokenReused
+		}
+
+		if err := q.MarkRefreshTokenUsed(ctx, tokenID); err != nil {
+			return err
+		}
+
+		return q.CreateRefreshToken(ctx, next)
+	})
+
+	switch err {
+	case ErrTokenReused:
+		// the revocation has to outlive the rolled back transaction
+		if err := s.q.RevokeRefreshTokenFamily(ctx, next.FamilyID); err != nil {
+			return mapError(err)
+		}
+		return ErrTokenReused
+	case ErrNotFound:
+		return ErrNotFound
+	default:
+		return mapError(err)
+	}
+}
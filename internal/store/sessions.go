@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
+)
+
+type SessionStore struct {
+	db *pgxpool.Pool
+	q  *queries.Queries
+}
+
+func NewSessionStore(db *pgxpool.Pool, q *queries.Queries) *SessionStore {
+	return &SessionStore{db: db, q: q}
+}
+
+// Create records a login along with the first refresh token of its family
+func (s *SessionStore) Create(ctx context.Context, arg queries.CreateSessionParams, token queries.CreateRefreshTokenParams) error {
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		if err := q.CreateSession(ctx, arg); err != nil {
+			return err
+		}
+		return q.CreateRefreshToken(ctx, token)
+	})
+	return mapError(err)
+}
+
+func (s *SessionStore) GetActive(ctx context.Context, arg queries.GetActiveSessionsByUserIDParams) ([]queries.Session, error) {
+	sessions, err := s.q.GetActiveSessionsByUserID(ctx, arg)
+	return sessions, mapError(err)
+}
+
+func (s *SessionStore) IsActive(ctx context.Context, arg queries.IsSessionActiveParams) (bool, error) {
+	active, err := s.q.IsSessionActive(ctx, arg)
+	return active, mapError(err)
+}
+
+func (s *SessionStore) Touch(ctx context.Context, arg queries.TouchSessionParams) error {
+	err := s.q.TouchSession(ctx, arg)
+	return mapError(err)
+}
+
+// Revoke ends one session of the user and its refresh tokens,
+// ErrNotFound is returned if it isn't theirs or has already ended
+func (s *SessionStore) Revoke(ctx context.Context, arg queries.RevokeSessionParams) error {
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		if _, err := q.RevokeSession(ctx, arg); err != nil {
+			return err
+		}
+		return q.RevokeRefreshTokenFamily(ctx, arg.ID)
+	})
+	return mapError(err)
+}
+
+// RevokeAll ends every session of the user and returns their ids
+func (s *SessionStore) RevokeAll(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := withTx(ctx, s.db, s.q, func(q *queries.Queries) error {
+		var err error
+		ids, err = q.RevokeUserSessions(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return q.RevokeUserRefreshTokens(ctx, userID)
+	})
+
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return ids, nil
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,8 +14,17 @@ func NewStorage(db *pgxpool.Pool) *Storage {
 	return &Storage{
 		Users: NewUserStore(queries),
 		Contacts: NewContactStore(queries),
-		Messages: NewMessageStore(queries),
-		Conversations: NewConversationStore(queries),
+		Messages: NewMessageStore(db, queries),
+		Conversations: NewConversationStore(db, queries),
+		Reactions: NewReactionStore(queries),
+		Attachments: NewAttachmentStore(queries),
+		Events: NewEventStore(queries),
+		Receipts: NewReceiptStore(queries),
+		RefreshTokens: NewRefreshTokenStore(db, queries),
+		Sessions: NewSessionStore(db, queries),
+		Presence: NewPresenceStore(queries),
+		Blocks: NewBlockStore(queries),
+		ConversationSettings: NewConversationSettingsStore(db, queries),
 	}
 }
 
@@ -30,6 +40,8 @@ type Storage struct {
 		Search(ctx context.Context, selfUsername, targetUsername string) ([]queries.SearchUsersRow, error)
 
 		UpdateLastSeen(ctx context.Context, id uuid.UUID) error
+
+		UpdateMessagePrivacy(ctx context.Context, arg queries.UpdateUserMessagePrivacyParams) (queries.User, error)
 		// UpdatePassword(ctx context.Context, arg queries.UpdateUserPasswordParams) error
 	}
 
@@ -38,32 +50,196 @@ type Storage struct {
 
 		GetByUserID(ctx context.Context, userID uuid.UUID) ([]queries.GetContactsByUserIDRow, error)
 
+		Get(ctx context.Context, arg queries.GetContactParams) (queries.GetContactRow, error)
+
+		Rename(ctx context.Context, arg queries.UpdateContactNicknameParams) error
+
 		Delete(ctx context.Context, arg queries.DeleteContactParams) error
 
-		// Search(ctx context.Context, arg queries.SearchContactsParams) ([]queries.User, error)
+		Search(ctx context.Context, arg queries.SearchContactsParams) ([]queries.SearchContactsRow, error)
+
+		Exists(ctx context.Context, arg queries.IsContactParams) (bool, error)
+	}
+
+	Blocks interface {
+		Block(ctx context.Context, arg queries.BlockUserParams) error
+
+		Unblock(ctx context.Context, arg queries.UnblockUserParams) error
+
+		GetByUserID(ctx context.Context, userID uuid.UUID) ([]queries.GetBlockedUsersRow, error)
+
+		IsBlockedBetween(ctx context.Context, user1, user2 uuid.UUID) (bool, error)
+
+		IsBlockedInConversation(ctx context.Context, conversationID uuid.UUID) (bool, error)
 	}
 
 
 	Messages interface {
-		Create(ctx context.Context, arg queries.CreateMessageParams) (queries.Message, error)
+		Create(ctx context.Context, arg queries.CreateMessageParams, attachmentIDs []uuid.UUID) (queries.Message, []queries.Attachment, error)
+
+		CreateInRequest(ctx context.Context, arg queries.CreateMessageParams, attachmentIDs []uuid.UUID) (queries.Message, []queries.Attachment, error)
 
-		GetByConversationID(ctx context.Context, arg queries.GetMessagesByConversationIDParams) ([]queries.Message, error)
+		GetByID(ctx context.Context, id uuid.UUID) (queries.Message, error)
 
-		MarkAsRead(ctx context.Context, arg queries.MarkMessagesAsReadParams) ([]uuid.UUID, error)
+		GetVisibleByID(ctx context.Context, arg queries.GetVisibleMessageByIDParams) (queries.Message, error)
 
-		// GetLast(ctx context.Context, userID uuid.UUID) ([]queries.Message, error)
+		GetQuoted(ctx context.Context, ids []uuid.UUID) ([]queries.GetQuotedMessagesRow, error)
 
-		Delete(ctx context.Context, id uuid.UUID) error
+		GetLast(ctx context.Context, arg queries.GetLastMessagesParams) ([]queries.GetLastMessagesRow, error)
+
+		GetLatest(ctx context.Context, arg queries.GetLatestMessagesParams) ([]queries.Message, error)
+
+		GetBefore(ctx context.Context, arg queries.GetMessagesBeforeParams) ([]queries.Message, error)
+
+		GetAfter(ctx context.Context, arg queries.GetMessagesAfterParams) ([]queries.Message, error)
+
+		Edit(ctx context.Context, arg queries.EditMessageParams) (queries.Message, error)
+
+		GetEdits(ctx context.Context, messageID uuid.UUID) ([]queries.MessageEdit, error)
+
+		DeleteForEveryone(ctx context.Context, arg queries.DeleteMessageForEveryoneParams) (queries.Message, error)
+
+		DeleteForUser(ctx context.Context, arg queries.HideMessageParams) error
+
+		Search(ctx context.Context, arg queries.SearchMessagesParams) ([]queries.SearchMessagesRow, error)
 	}
 
 
 	Conversations interface {
-		GetByUserID(ctx context.Context, id uuid.UUID) ([]queries.GetConversationsByUserIDRow, error) 
+		GetByUserID(ctx context.Context, arg queries.GetConversationsByUserIDParams) ([]queries.GetConversationsByUserIDRow, error)
+
+		GetGroupsByUserID(ctx context.Context, arg queries.GetGroupConversationsByUserIDParams) ([]queries.GetGroupConversationsByUserIDRow, error)
+
+		GetByID(ctx context.Context, id uuid.UUID) (queries.Conversation, error)
 
-		Delete(ctx context.Context, id uuid.UUID) error 
+		Delete(ctx context.Context, id uuid.UUID) ([]string, error)
 
 		Create(ctx context.Context, params queries.CreateConversationParams) (queries.Conversation,error) 
 
+		CreateGroup(ctx context.Context, params queries.CreateGroupConversationParams, memberIDs []uuid.UUID) (queries.Conversation, error)
+
 		GetByMembers(ctx context.Context, params queries.GetConversationByMembersParams) (queries.Conversation, error)
+
+		GetMemberIDs(ctx context.Context, conversationID uuid.UUID) ([]uuid.UUID, error)
+
+		GetMembersOfMany(ctx context.Context, conversationIDs []uuid.UUID) ([]queries.GetMembersOfConversationsRow, error)
+
+		GetMembers(ctx context.Context, conversationID uuid.UUID) ([]queries.GetConversationMembersRow, error)
+
+		IsMember(ctx context.Context, conversationID, userID uuid.UUID) (bool, error)
+
+		GetPeerIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+
+		GetRequests(ctx context.Context, userID uuid.UUID) ([]queries.GetMessageRequestsRow, error)
+
+		AcceptRequest(ctx context.Context, arg queries.AcceptMessageRequestParams) (queries.Conversation, error)
+
+		DeclineRequest(ctx context.Context, arg queries.DeclineMessageRequestParams) (queries.Conversation, []string, error)
+
+		IsRequestDeclined(ctx context.Context, arg queries.IsMessageRequestDeclinedParams) (bool, error)
+
+		RequestDeletion(ctx context.Context, arg queries.RequestConversationDeletionParams) (queries.Conversation, bool, []string, error)
+
+		CancelDeletion(ctx context.Context, id uuid.UUID) (queries.Conversation, error)
+
+		Leave(ctx context.Context, conversationID, userID uuid.UUID) ([]string, error)
+	}
+
+	ConversationSettings interface {
+		Get(ctx context.Context, arg queries.GetConversationSettingsParams) ([]queries.GetConversationSettingsRow, error)
+
+		Archive(ctx context.Context, arg queries.ArchiveConversationParams) error
+
+		Unarchive(ctx context.Context, arg queries.UnarchiveConversationParams) error
+
+		Clear(ctx context.Context, arg queries.ClearConversationParams) error
+
+		DeleteForUser(ctx context.Context, arg queries.DeleteConversationForUserParams) error
+
+		GetPinnedIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+
+		Pin(ctx context.Context, arg queries.PinConversationParams, limit int) error
+
+		Unpin(ctx context.Context, arg queries.UnpinConversationParams) error
+
+		Reorder(ctx context.Context, arg queries.ReorderPinnedConversationsParams) error
+
+		Mute(ctx context.Context, arg queries.MuteConversationParams) error
+
+		Unmute(ctx context.Context, arg queries.UnmuteConversationParams) error
+
+		GetMutedMemberIDs(ctx context.Context, conversationID uuid.UUID) ([]uuid.UUID, error)
+	}
+
+	Reactions interface {
+		Add(ctx context.Context, arg queries.AddReactionParams) error
+
+		Remove(ctx context.Context, arg queries.RemoveReactionParams) error
+
+		Count(ctx context.Context, arg queries.CountReactionsParams) (int64, error)
+
+		GetSummaries(ctx context.Context, arg queries.GetReactionSummariesParams) ([]queries.GetReactionSummariesRow, error)
+	}
+
+	Attachments interface {
+		Create(ctx context.Context, arg queries.CreateAttachmentParams) (queries.Attachment, error)
+
+		GetByID(ctx context.Context, id uuid.UUID) (queries.Attachment, error)
+
+		GetByMessageIDs(ctx context.Context, messageIDs []uuid.UUID) ([]queries.Attachment, error)
+
+		DeleteByMessageID(ctx context.Context, messageID uuid.UUID) ([]string, error)
+	}
+
+	Receipts interface {
+		MarkDelivered(ctx context.Context, arg queries.MarkMessagesDeliveredParams) ([]queries.MarkMessagesDeliveredRow, error)
+
+		MarkRead(ctx context.Context, arg queries.MarkConversationReadParams) ([]queries.MarkConversationReadRow, error)
+
+		GetByMessageID(ctx context.Context, messageID uuid.UUID) ([]queries.GetMessageReceiptsRow, error)
+
+		GetCounts(ctx context.Context, messageIDs []uuid.UUID) ([]queries.GetReceiptCountsRow, error)
+	}
+
+	RefreshTokens interface {
+		Rotate(ctx context.Context, tokenID uuid.UUID, next queries.CreateRefreshTokenParams) error
+	}
+
+	Sessions interface {
+		Create(ctx context.Context, arg queries.CreateSessionParams, token queries.CreateRefreshTokenParams) error
+
+		GetActive(ctx context.Context, arg queries.GetActiveSessionsByUserIDParams) ([]queries.Session, error)
+
+		IsActive(ctx context.Context, arg queries.IsSessionActiveParams) (bool, error)
+
+		Touch(ctx context.Context, arg queries.TouchSessionParams) error
+
+		Revoke(ctx context.Context, arg queries.RevokeSessionParams) error
+
+		RevokeAll(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+	}
+
+	Presence interface {
+		Heartbeat(ctx context.Context, nodeID uuid.UUID) error
+
+		RemoveNode(ctx context.Context, nodeID uuid.UUID) error
+
+		PruneNodes(ctx context.Context, heartbeatBefore time.Time) ([]uuid.UUID, error)
+
+		Connect(ctx context.Context, arg queries.AddUserPresenceParams) (int32, error)
+
+		Disconnect(ctx context.Context, arg queries.RemoveUserPresenceParams) (int32, error)
+
+		GetOnline(ctx context.Context, arg queries.GetOnlineUserIDsParams) ([]uuid.UUID, error)
+	}
+
+	Events interface {
+		Record(ctx context.Context, arg queries.CreateUserEventsParams) (map[uuid.UUID]int64, error)
+
+		GetAfter(ctx context.Context, arg queries.GetUserEventsAfterParams) ([]queries.UserEvent, error)
+
+		GetBounds(ctx context.Context, userID uuid.UUID) (queries.GetUserEventBoundsRow, error)
+
+		Prune(ctx context.Context, before time.Time) (int64, error)
 	}
 }
@@ -73,6 +73,11 @@ func (s *UserStore) UpdateLastSeen(ctx context.Context, id uuid.UUID) error {
 	return mapError(err)
 }
 
+func (s *UserStore) UpdateMessagePrivacy(ctx context.Context, arg queries.UpdateUserMessagePrivacyParams) (queries.User, error) {
+	user, err := s.q.UpdateUserMessagePrivacy(ctx, arg)
+	return user, mapError(err)
+}
+
 
 // UpdatePassword(ctx context.Context, arg queries.UpdateUserPasswordParams) error
 
@@ -1,11 +1,15 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/myselfBZ/chatrix-v2/internal/metrics"
+	"github.com/myselfBZ/chatrix-v2/internal/queries"
 )
 
 var (
@@ -13,6 +17,8 @@ var (
 	ErrAlreadyExists     = errors.New("resource already exists")
 	ErrConstraintMessage = errors.New("operation violated a database constraint")
 	ErrInternal          = errors.New("an internal storage error occurred")
+	ErrTokenReused       = errors.New("token has already been used")
+	ErrLimitReached      = errors.New("limit reached")
 )
 
 
@@ -23,6 +29,7 @@ func mapError(err error) error {
 	}
 
 	if errors.Is(err, pgx.ErrNoRows) {
+		metrics.StoreErrors.WithLabelValues("not_found").Inc()
 		return ErrNotFound
 	}
 
@@ -30,13 +37,32 @@ func mapError(err error) error {
 	if errors.As(err, &pgErr) {
 		switch pgErr.Code {
 		case "23505": // unique_violation
+			metrics.StoreErrors.WithLabelValues("already_exists").Inc()
 			return ErrAlreadyExists
 		case "23503": // foreign_key_violation
+			metrics.StoreErrors.WithLabelValues("constraint").Inc()
 			return ErrConstraintMessage
 		}
 	}
 
 	slog.Info(err.Error())
 
+	metrics.StoreErrors.WithLabelValues("internal").Inc()
 	return ErrInternal
 }
+
+// withTx runs fn inside a transaction, rolling back if fn returns an error.
+// The returned error is not mapped, callers should pass it through mapError.
+func withTx(ctx context.Context, db *pgxpool.Pool, q *queries.Queries, fn func(q *queries.Queries) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(q.WithTx(tx)); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}